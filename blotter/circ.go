@@ -16,23 +16,26 @@ package blotter
 
 import (
 	"bytes"
-	"hash"
-	"hash/fnv"
 )
 
 type Circ struct {
-	hashes []uint32
-	hash   uint32
-	fn     hash.Hash32
+	hashes []uint64
+	hash   uint64
+	th     *tokHash
+	width  int
 	i      int
 }
 
 func NewCirc(n int) *Circ {
-	return &Circ{hashes: make([]uint32, n), hash: 0, fn: fnv.New32()}
+	return newCirc(n, defaultHash(HashFNV32), 32)
+}
+
+func newCirc(n int, th *tokHash, width int) *Circ {
+	return &Circ{hashes: make([]uint64, n), hash: 0, th: th, width: width}
 }
 
 func (c *Circ) Config() *Config {
-	return &Config{SeqLen: len(c.hashes), Interleave: 1}
+	return &Config{SeqLen: len(c.hashes), Interleave: 1, Hash: c.th.name, Seed: c.th.seed, Width: cfgWidth(c.width)}
 }
 
 func (c *Circ) Interleaving() int {
@@ -40,10 +43,11 @@ func (c *Circ) Interleaving() int {
 }
 
 func (c *Circ) Blot(word []byte) uint32 {
-	fn := c.fn
-	fn.Reset()
-	fn.Write(bytes.ToLower(word))
-	h := fn.Sum32()
+	return uint32(c.Blot64(word))
+}
+
+func (c *Circ) Blot64(word []byte) uint64 {
+	h := c.th.sum(bytes.ToLower(word))
 	c.hash ^= c.hashes[c.i]
 	c.hashes[c.i] = h
 	c.hash ^= h
@@ -51,5 +55,5 @@ func (c *Circ) Blot(word []byte) uint32 {
 	if c.i == len(c.hashes) {
 		c.i = 0
 	}
-	return c.hash
+	return widen(uint32(c.hash), c.hash, c.width)
 }
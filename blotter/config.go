@@ -14,11 +14,74 @@
 
 package blotter
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
 
+// Config describes blotter configurations.
 type Config struct {
+	// Name is the name under which the blotter is
+	// registered.  The empty name is the built in
+	// circular blotter, PolyName the built in
+	// polynomial blotter, and SkipName the built in
+	// skip-gram blotter.  The first two are interleaved
+	// if Interleave > 1.
+	Name       string `json:",omitempty"`
 	SeqLen     int
 	Interleave int
+	// Winnow, if greater than 1, is the number of
+	// consecutive blots of which dupi posts only the
+	// minimum, see Winnow.
+	Winnow int `json:",omitempty"`
+	// Fanout is the number of blots per token of the
+	// skip-gram blotter, see SkipGram.  0 means 1.
+	Fanout int `json:",omitempty"`
+	// Hash names the hash function of tokens of the
+	// built in blotters, one of the Hash constants, and
+	// Seed seeds it.  The empty name is the default of
+	// each blotter.
+	Hash string `json:",omitempty"`
+	Seed uint64 `json:",omitempty"`
+	// Width is the number of bits of blots, a multiple
+	// of 8 from 32 to 64.  0 means 32.  Blots wider than
+	// 32 bits need a Wide blotter, and the circular
+	// blotter needs a 64 bit hash for them.
+	Width int `json:",omitempty"`
+	// Params holds parameters for registered
+	// blotters, which dupi does not interpret.
+	Params map[string]string `json:",omitempty"`
+}
+
+// Factory creates a blotter from a config.
+type Factory func(c *Config) (T, error)
+
+// ErrUnregistered is returned by FromConfig when no
+// blotter is registered under the name in the config.
+var ErrUnregistered = errors.New("blotter not registered")
+
+var (
+	regMu    sync.Mutex
+	registry = map[string]Factory{
+		"":       newDefault,
+		PolyName: newPolyConfig,
+		SkipName: newSkip}
+)
+
+// Register makes the blotter created by f available
+// under name.  Register panics if name is already
+// registered or f is nil.
+func Register(name string, f Factory) {
+	regMu.Lock()
+	defer regMu.Unlock()
+	if f == nil {
+		panic("blotter: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("blotter: Register called twice for %q", name))
+	}
+	registry[name] = f
 }
 
 func DefaultConfig() *Config {
@@ -26,11 +89,51 @@ func DefaultConfig() *Config {
 }
 
 func FromConfig(c *Config) (T, error) {
+	regMu.Lock()
+	f := registry[c.Name]
+	regMu.Unlock()
+	if f == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnregistered, c.Name)
+	}
+	return f(c)
+}
+
+func newDefault(c *Config) (T, error) {
 	if c.Interleave < 1 {
 		return nil, fmt.Errorf("invalid config: interleave=%d", c.Interleave)
 	}
+	th, err := newTokHash(c, HashFNV32)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWidth(c, th.bits); err != nil {
+		return nil, err
+	}
+	if c.Interleave == 1 {
+		return newCirc(c.SeqLen, th, c.Width), nil
+	}
+	return interleave(c.Interleave, func() T {
+		th, _ := newTokHash(c, HashFNV32)
+		return newCirc(c.SeqLen, th, c.Width)
+	}), nil
+}
+
+func newPolyConfig(c *Config) (T, error) {
+	if c.Interleave < 1 {
+		return nil, fmt.Errorf("invalid config: interleave=%d", c.Interleave)
+	}
+	th, err := newTokHash(c, HashFNV64a)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWidth(c, 64); err != nil {
+		return nil, err
+	}
 	if c.Interleave == 1 {
-		return NewCirc(c.SeqLen), nil
+		return newPoly(c.SeqLen, th, c.Width), nil
 	}
-	return NewInterleaved(c.SeqLen, c.Interleave), nil
+	return interleave(c.Interleave, func() T {
+		th, _ := newTokHash(c, HashFNV64a)
+		return newPoly(c.SeqLen, th, c.Width)
+	}), nil
 }
@@ -0,0 +1,237 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blotter
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math/bits"
+
+	"github.com/go-air/dupi/internal/splitmix"
+)
+
+// Names of the hash functions of tokens in Config.
+const (
+	// HashFNV32 is 32 bit FNV-1, the default of the
+	// circular blotter.
+	HashFNV32 = "fnv32"
+	// HashFNV64a is 64 bit FNV-1a, the default of the
+	// polynomial and skip-gram blotters.
+	HashFNV64a = "fnv64a"
+	// HashXX is the 64 bit xxHash (XXH64) with seed
+	// Config.Seed.
+	HashXX = "xxhash"
+	// HashSip is SipHash-2-4 with the key Config.Seed,
+	// ^Config.Seed.  Since the key is secret, so are
+	// blots, and texts cannot be crafted to collide.
+	HashSip = "siphash"
+)
+
+// tokHash hashes tokens.
+type tokHash struct {
+	// the name of the hash in the config, and its seed.
+	name string
+	seed uint64
+	// the number of bits of hashes.
+	bits int
+	sum  func(tok []byte) uint64
+}
+
+// newTokHash returns the token hash of c, with the name
+// def if c has none.
+func newTokHash(c *Config, def string) (*tokHash, error) {
+	res := &tokHash{name: c.Hash, seed: c.Seed, bits: 64}
+	name := c.Hash
+	if name == "" {
+		name = def
+	}
+	switch name {
+	case HashFNV32:
+		fn := fnv.New32()
+		res.bits = 32
+		res.sum = func(tok []byte) uint64 {
+			fn.Reset()
+			fn.Write(tok)
+			return uint64(fn.Sum32())
+		}
+	case HashFNV64a:
+		fn := fnv.New64a()
+		res.sum = func(tok []byte) uint64 {
+			fn.Reset()
+			fn.Write(tok)
+			return fn.Sum64()
+		}
+	case HashXX:
+		seed := c.Seed
+		res.sum = func(tok []byte) uint64 { return xxh64(tok, seed) }
+	case HashSip:
+		k0, k1 := c.Seed, ^c.Seed
+		res.sum = func(tok []byte) uint64 { return sip24(k0, k1, tok) }
+	default:
+		return nil, fmt.Errorf("invalid config: unknown hash %q", c.Hash)
+	}
+	return res, nil
+}
+
+// defaultHash returns the token hash named def, without
+// a seed.
+func defaultHash(def string) *tokHash {
+	res, err := newTokHash(&Config{}, def)
+	if err != nil {
+		panic(err)
+	}
+	return res
+}
+
+// width returns the number of bits of the blots of c.
+func (c *Config) width() int {
+	if c.Width == 0 {
+		return 32
+	}
+	return c.Width
+}
+
+// cfgWidth returns the Config.Width of blots of width
+// bits.
+func cfgWidth(width int) int {
+	if width <= 32 {
+		return 0
+	}
+	return width
+}
+
+// checkWidth checks that the blot width of c is valid for
+// windows hashed to hashBits bits.
+func checkWidth(c *Config, hashBits int) error {
+	w := c.width()
+	if w < 32 || w > 64 || w%8 != 0 {
+		return fmt.Errorf("invalid config: width=%d is not a multiple of 8 from 32 to 64", c.Width)
+	}
+	if w > hashBits {
+		return fmt.Errorf("invalid config: width=%d is more than the %d bits of hash %q", w, hashBits, c.Hash)
+	}
+	return nil
+}
+
+// widen returns the blot of width bits of a window whose
+// blot of 32 bits is lo and whose hash is h.  The upper
+// bits are taken from a mix of h, so that they are as
+// uniform as the lower ones.
+func widen(lo uint32, h uint64, width int) uint64 {
+	if width <= 32 {
+		return uint64(lo)
+	}
+	hi := splitmix.Mix(h) & (1<<uint(width-32) - 1)
+	return hi<<32 | uint64(lo)
+}
+
+const (
+	xxP1 = 11400714785074694791
+	xxP2 = 14029467366897019727
+	xxP3 = 1609587929392839161
+	xxP4 = 9650029242287828579
+	xxP5 = 2870177450012600261
+)
+
+// xxh64 returns the 64 bit xxHash of b with seed.
+func xxh64(b []byte, seed uint64) uint64 {
+	n := len(b)
+	var h uint64
+	if n >= 32 {
+		v1, v2, v3, v4 := seed+xxP1+xxP2, seed+xxP2, seed, seed-xxP1
+		for ; len(b) >= 32; b = b[32:] {
+			v1 = xxRound(v1, binary.LittleEndian.Uint64(b[0:]))
+			v2 = xxRound(v2, binary.LittleEndian.Uint64(b[8:]))
+			v3 = xxRound(v3, binary.LittleEndian.Uint64(b[16:]))
+			v4 = xxRound(v4, binary.LittleEndian.Uint64(b[24:]))
+		}
+		h = bits.RotateLeft64(v1, 1) + bits.RotateLeft64(v2, 7) +
+			bits.RotateLeft64(v3, 12) + bits.RotateLeft64(v4, 18)
+		for _, v := range [4]uint64{v1, v2, v3, v4} {
+			h = (h^xxRound(0, v))*xxP1 + xxP4
+		}
+	} else {
+		h = seed + xxP5
+	}
+	h += uint64(n)
+	for ; len(b) >= 8; b = b[8:] {
+		h ^= xxRound(0, binary.LittleEndian.Uint64(b))
+		h = bits.RotateLeft64(h, 27)*xxP1 + xxP4
+	}
+	if len(b) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(b)) * xxP1
+		h = bits.RotateLeft64(h, 23)*xxP2 + xxP3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h ^= uint64(c) * xxP5
+		h = bits.RotateLeft64(h, 11) * xxP1
+	}
+	h ^= h >> 33
+	h *= xxP2
+	h ^= h >> 29
+	h *= xxP3
+	h ^= h >> 32
+	return h
+}
+
+func xxRound(acc, v uint64) uint64 {
+	return bits.RotateLeft64(acc+v*xxP2, 31) * xxP1
+}
+
+// sip24 returns the SipHash-2-4 of b with the key k0, k1.
+func sip24(k0, k1 uint64, b []byte) uint64 {
+	v0 := k0 ^ 0x736f6d6570736575
+	v1 := k1 ^ 0x646f72616e646f6d
+	v2 := k0 ^ 0x6c7967656e657261
+	v3 := k1 ^ 0x7465646279746573
+	round := func() {
+		v0 += v1
+		v1 = bits.RotateLeft64(v1, 13)
+		v1 ^= v0
+		v0 = bits.RotateLeft64(v0, 32)
+		v2 += v3
+		v3 = bits.RotateLeft64(v3, 16)
+		v3 ^= v2
+		v0 += v3
+		v3 = bits.RotateLeft64(v3, 21)
+		v3 ^= v0
+		v2 += v1
+		v1 = bits.RotateLeft64(v1, 17)
+		v1 ^= v2
+		v2 = bits.RotateLeft64(v2, 32)
+	}
+	last := uint64(len(b)) << 56
+	for ; len(b) >= 8; b = b[8:] {
+		m := binary.LittleEndian.Uint64(b)
+		v3 ^= m
+		round()
+		round()
+		v0 ^= m
+	}
+	for i, c := range b {
+		last |= uint64(c) << (8 * uint(i))
+	}
+	v3 ^= last
+	round()
+	round()
+	v0 ^= last
+	v2 ^= 0xff
+	for i := 0; i < 4; i++ {
+		round()
+	}
+	return v0 ^ v1 ^ v2 ^ v3
+}
@@ -0,0 +1,129 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blotter
+
+import (
+	"hash/fnv"
+	"strings"
+	"testing"
+)
+
+func TestXXH64(t *testing.T) {
+	for _, c := range []struct {
+		in   string
+		seed uint64
+		want uint64
+	}{
+		{"", 0, 0xef46db3751d8e999},
+		{"abc", 0, 0x44bc2cf5ad770999},
+	} {
+		if got := xxh64([]byte(c.in), c.seed); got != c.want {
+			t.Errorf("xxh64(%q, %d) = %x want %x", c.in, c.seed, got, c.want)
+		}
+	}
+}
+
+func TestSip24(t *testing.T) {
+	// the test vector of the SipHash paper.
+	msg := make([]byte, 15)
+	for i := range msg {
+		msg[i] = byte(i)
+	}
+	if got := sip24(0x0706050403020100, 0x0f0e0d0c0b0a0908, msg); got != 0xa129ca6149be45e5 {
+		t.Errorf("sip24 gave %x", got)
+	}
+}
+
+// TestDefaultBlots checks that blots of the default
+// config are the xor of 32 bit FNV-1 hashes of the
+// lowercased tokens, as in indices made before hashes
+// and widths were configurable.
+func TestDefaultBlots(t *testing.T) {
+	b, err := FromConfig(&Config{SeqLen: 3, Interleave: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := strings.Fields("The quick brown fox")
+	var got uint32
+	for _, w := range words {
+		got = b.Blot([]byte(w))
+	}
+	var want uint32
+	for _, w := range words[1:] {
+		fn := fnv.New32()
+		fn.Write([]byte(strings.ToLower(w)))
+		want ^= fn.Sum32()
+	}
+	if got != want {
+		t.Errorf("got blot %x want %x", got, want)
+	}
+}
+
+func TestWidth(t *testing.T) {
+	words := strings.Fields("the quick brown fox jumps over the lazy dog")
+	for _, name := range []string{"", PolyName, SkipName} {
+		for _, hash := range []string{"", HashFNV32, HashFNV64a, HashXX, HashSip} {
+			for _, width := range []int{0, 32, 40, 64} {
+				c := &Config{Name: name, SeqLen: 3, Interleave: 1, Hash: hash, Seed: 7, Width: width}
+				b, err := FromConfig(c)
+				// the window hash of Circ is as wide as its
+				// token hash.
+				wide := width > 32 && name == "" && (hash == "" || hash == HashFNV32)
+				if wide {
+					if err == nil {
+						t.Errorf("%+v: no error for width wider than hash", c)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("%+v: %v", c, err)
+				}
+				if got := b.Config(); got.Hash != hash || got.Width != cfgWidth(width) {
+					t.Errorf("config %+v gave blotter with config %+v", c, got)
+				}
+				wb, ok := b.(Wide)
+				if !ok {
+					t.Fatalf("%+v: blotter is not Wide", c)
+				}
+				// Blot gives the lower bits of Blot64.
+				lb, err := FromConfig(c)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var or uint64
+				for _, w := range words {
+					b64 := wb.Blot64([]byte(w))
+					if b32 := lb.Blot([]byte(w)); b32 != uint32(b64) {
+						t.Errorf("%+v: Blot gave %x, Blot64 %x", c, b32, b64)
+					}
+					or |= b64
+				}
+				if width > 32 && or>>32 == 0 || width < 64 && or>>uint(c.width()) != 0 {
+					t.Errorf("%+v: blots or to %x", c, or)
+				}
+			}
+		}
+	}
+	for _, c := range []*Config{
+		{SeqLen: 3, Interleave: 1, Width: 36},
+		{SeqLen: 3, Interleave: 1, Width: 72},
+		{SeqLen: 3, Interleave: 1, Width: 24},
+		{SeqLen: 3, Interleave: 1, Hash: "md5"},
+	} {
+		if _, err := FromConfig(c); err == nil {
+			t.Errorf("%+v: no error", c)
+		}
+	}
+}
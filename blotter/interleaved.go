@@ -1,32 +1,48 @@
 package blotter
 
 type Interleaved struct {
-	circs []*Circ
-	i     int
+	ts []T
+	i  int
 }
 
 func NewInterleaved(seqLen, interLeaving int) *Interleaved {
-	res := &Interleaved{circs: make([]*Circ, interLeaving)}
-	for i := range res.circs {
-		res.circs[i] = NewCirc(seqLen)
+	return interleave(interLeaving, func() T { return NewCirc(seqLen) })
+}
+
+// interleave interleaves n blotters created by mk.
+func interleave(n int, mk func() T) *Interleaved {
+	res := &Interleaved{ts: make([]T, n)}
+	for i := range res.ts {
+		res.ts[i] = mk()
 	}
 	return res
 }
 
 func (i *Interleaved) Config() *Config {
-	example := i.circs[0]
-	seqLen := len(example.hashes)
-	return &Config{SeqLen: seqLen, Interleave: len(i.circs)}
+	res := i.ts[0].Config()
+	res.Interleave = len(i.ts)
+	return res
 }
 
 func (i *Interleaved) Interleaving() int {
-	return len(i.circs)
+	return len(i.ts)
 }
 
 func (i *Interleaved) Blot(tok []byte) uint32 {
-	res := i.circs[i.i].Blot(tok)
+	return uint32(i.Blot64(tok))
+}
+
+// Blot64 gives the blots of the interleaved blotters,
+// which are 32 bits wide unless they are Wide.
+func (i *Interleaved) Blot64(tok []byte) uint64 {
+	var res uint64
+	if w, ok := i.ts[i.i].(Wide); ok {
+		res = w.Blot64(tok)
+	} else {
+		res = uint64(i.ts[i.i].Blot(tok))
+	}
 	i.i++
-	if i.i == len(i.circs) {
+	if i.i == len(i.ts) {
 		i.i = 0
 	}
 	return res
@@ -0,0 +1,106 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blotter
+
+import (
+	"bytes"
+	"math/bits"
+)
+
+// PolyName is the name of the Poly blotter in Config.
+const PolyName = "poly"
+
+// polyMod is the Mersenne prime 2^61-1, modulo which
+// Poly computes its hashes.
+const polyMod = 1<<61 - 1
+
+// polyBase is the base of the polynomials of Poly.
+const polyBase = 0x1f3a_8c27_65d9_4b1
+
+// Poly is a Rabin-Karp blotter.  The blot of a window of
+// tokens t₁ … tₖ is the polynomial
+//
+//	fₕ(t₁)·Bᵏ⁻¹ + fₕ(t₂)·Bᵏ⁻² + … + fₕ(tₖ)
+//
+// modulo 2^61-1, folded to 32 bits, where fₕ hashes
+// tokens, by default with FNV-1a.  Unlike the blots of
+// Circ, those of Poly depend on the order of the tokens,
+// and tokens occurring twice in a window do not cancel.
+type Poly struct {
+	hashes []uint64
+	hash   uint64
+	// B^k, with which the hash of the token leaving the
+	// window is removed.
+	outPow uint64
+	th     *tokHash
+	width  int
+	i      int
+}
+
+func NewPoly(n int) *Poly {
+	return newPoly(n, defaultHash(HashFNV64a), 32)
+}
+
+func newPoly(n int, th *tokHash, width int) *Poly {
+	p := &Poly{hashes: make([]uint64, n), th: th, width: width, outPow: 1}
+	for i := 0; i < n; i++ {
+		p.outPow = polyMul(p.outPow, polyBase)
+	}
+	return p
+}
+
+func (p *Poly) Config() *Config {
+	return &Config{Name: PolyName, SeqLen: len(p.hashes), Interleave: 1,
+		Hash: p.th.name, Seed: p.th.seed, Width: cfgWidth(p.width)}
+}
+
+func (p *Poly) Interleaving() int {
+	return 1
+}
+
+func (p *Poly) Blot(word []byte) uint32 {
+	return uint32(p.Blot64(word))
+}
+
+func (p *Poly) Blot64(word []byte) uint64 {
+	h := p.th.sum(bytes.ToLower(word)) % polyMod
+	// h·B + fₕ(new) - fₕ(old)·Bᵏ
+	p.hash = polyAdd(polyMul(p.hash, polyBase), h)
+	p.hash = polyAdd(p.hash, polyMod-polyMul(p.hashes[p.i], p.outPow))
+	p.hashes[p.i] = h
+	p.i++
+	if p.i == len(p.hashes) {
+		p.i = 0
+	}
+	return widen(uint32(p.hash^p.hash>>32), p.hash, p.width)
+}
+
+func polyAdd(a, b uint64) uint64 {
+	s := a + b
+	if s >= polyMod {
+		s -= polyMod
+	}
+	return s
+}
+
+func polyMul(a, b uint64) uint64 {
+	hi, lo := bits.Mul64(a, b)
+	// a·b = hi·2^64 + lo, and 2^61 ≡ 1.
+	s := (lo & polyMod) + (lo>>61 | hi<<3)
+	if s >= polyMod {
+		s -= polyMod
+	}
+	return s
+}
@@ -0,0 +1,158 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blotter
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestPoly(t *testing.T) {
+	p := NewPoly(3)
+	for _, w := range strings.Fields("a b c d e") {
+		p.Blot([]byte(w))
+	}
+	h := p.Blot([]byte("f"))
+	q := NewPoly(3)
+	var g uint32
+	for _, w := range strings.Fields("d e f") {
+		g = q.Blot([]byte(w))
+	}
+	if g != h {
+		t.Errorf("rolling blot %x differs from blot %x of window", h, g)
+	}
+	if j := p.Blot([]byte("xyzabc")); j == h {
+		t.Errorf("impossible hash collision")
+	}
+}
+
+// windowBlot returns the blot of the window of words
+// given by a new blotter created by mk.
+func windowBlot(mk func(int) T, words []string) uint32 {
+	b := mk(len(words))
+	var res uint32
+	for _, w := range words {
+		res = b.Blot([]byte(w))
+	}
+	return res
+}
+
+func newCircT(n int) T { return NewCirc(n) }
+func newPolyT(n int) T { return NewPoly(n) }
+
+// collisionRate returns the fraction of n pairs of
+// windows given by gen with the same blot.
+func collisionRate(mk func(int) T, n int, gen func(r *rand.Rand) ([]string, []string)) float64 {
+	r := rand.New(rand.NewSource(1))
+	c := 0
+	for i := 0; i < n; i++ {
+		a, b := gen(r)
+		if windowBlot(mk, a) == windowBlot(mk, b) {
+			c++
+		}
+	}
+	return float64(c) / float64(n)
+}
+
+func randWords(r *rand.Rand, n int) []string {
+	res := make([]string, n)
+	for i := range res {
+		res[i] = fmt.Sprintf("w%d", r.Intn(5000))
+	}
+	return res
+}
+
+func TestPolyOrder(t *testing.T) {
+	if windowBlot(newCircT, strings.Fields("dog bites man")) != windowBlot(newCircT, strings.Fields("man bites dog")) {
+		t.Errorf("circ blots depend on order")
+	}
+	if windowBlot(newPolyT, strings.Fields("dog bites man")) == windowBlot(newPolyT, strings.Fields("man bites dog")) {
+		t.Errorf("poly blots ignore order")
+	}
+	gens := map[string]func(r *rand.Rand) ([]string, []string){
+		// windows with two distinct words swapped.
+		"swap": func(r *rand.Rand) ([]string, []string) {
+			a := randWords(r, 10)
+			b := append([]string(nil), a...)
+			i, j := r.Intn(5), 5+r.Intn(5)
+			if a[i] == a[j] {
+				a[i] += "x"
+				b[i] += "x"
+			}
+			b[i], b[j] = b[j], b[i]
+			return a, b
+		},
+		// windows differing only in a word occurring
+		// twice.
+		"repeat": func(r *rand.Rand) ([]string, []string) {
+			a := randWords(r, 10)
+			b := append([]string(nil), a...)
+			a[3], a[7] = "x", "x"
+			b[3], b[7] = "y", "y"
+			return a, b
+		},
+	}
+	for name, gen := range gens {
+		circ := collisionRate(newCircT, 2000, gen)
+		poly := collisionRate(newPolyT, 2000, gen)
+		t.Logf("%s: circ collides %.3f, poly %.3f", name, circ, poly)
+		if circ != 1 {
+			t.Errorf("%s: circ collision rate %.3f", name, circ)
+		}
+		if poly > 0.001 {
+			t.Errorf("%s: poly collision rate %.3f", name, poly)
+		}
+	}
+}
+
+// TestPolyUniform checks that random windows have as many
+// distinct blots in a small blot space as with Circ and
+// as uniformly random blots.
+func TestPolyUniform(t *testing.T) {
+	const (
+		n     = 20000
+		space = 1 << 16
+	)
+	want := space * (1 - math.Exp(-float64(n)/space))
+	for name, mk := range map[string]func(int) T{"circ": newCircT, "poly": newPolyT} {
+		r := rand.New(rand.NewSource(2))
+		seen := make(map[uint32]bool)
+		for i := 0; i < n; i++ {
+			seen[windowBlot(mk, randWords(r, 10))%space] = true
+		}
+		got := float64(len(seen))
+		t.Logf("%s: %d distinct blots, expected %.0f", name, len(seen), want)
+		if math.Abs(got-want) > 0.02*want {
+			t.Errorf("%s: %d distinct blots, expected %.0f", name, len(seen), want)
+		}
+	}
+}
+
+func TestPolyConfig(t *testing.T) {
+	for _, il := range []int{1, 2} {
+		c := &Config{Name: PolyName, SeqLen: 5, Interleave: il}
+		b, err := FromConfig(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := b.Config()
+		if got.Name != c.Name || got.SeqLen != c.SeqLen || got.Interleave != il || b.Interleaving() != il {
+			t.Errorf("config %+v gave blotter with config %+v", c, got)
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blotter
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// SkipName is the name of the SkipGram blotter in Config.
+const SkipName = "skip"
+
+// Multi is implemented by blotters which give several
+// blots for each token.  Blot gives the first of them.
+type Multi interface {
+	T
+	// Blots blots the next token, appending its blots
+	// to dst.
+	Blots(dst []uint32, tok []byte) []uint32
+	// Skips gives the windows of the blots of a token:
+	// the i'th blot is of the last SeqLen+1 tokens but
+	// the one Skips()[i] tokens before the token.
+	Skips() []int
+}
+
+// WideMulti is implemented by Multi blotters whose blots
+// may be wider than 32 bits.  Blots64 is like Blots, but
+// gives the blots of Blot64.
+type WideMulti interface {
+	Multi
+	Wide
+	Blots64(dst []uint64, tok []byte) []uint64
+}
+
+// SkipGram is a blotter for texts with inserted or deleted
+// words.  For every token, it gives the blots of Fanout
+// windows of the last SeqLen+1 tokens, each leaving out a
+// different token, as the polynomial of Poly.  The first
+// window leaves out the first token, so its blot is that
+// of Poly.  With SeqLen windows, every token but the last
+// is left out of one of them, and a window of a text with
+// an inserted word has the blot of a window of the text
+// without it.  Fewer windows tolerate insertions at fewer
+// positions of each window.
+type SkipGram struct {
+	// the hashes of the last SeqLen+1 tokens, the last
+	// one at i-1.
+	hashes []uint64
+	skips  []int
+	th     *tokHash
+	width  int
+	i      int
+	buf    []uint64
+}
+
+// NewSkipGram creates a SkipGram blotter for windows of n
+// tokens giving fanout blots per token, with fanout
+// between 1 and n.
+func NewSkipGram(n, fanout int) *SkipGram {
+	return newSkipGram(n, fanout, defaultHash(HashFNV64a), 32)
+}
+
+func newSkipGram(n, fanout int, th *tokHash, width int) *SkipGram {
+	s := &SkipGram{hashes: make([]uint64, n+1), skips: make([]int, fanout), th: th, width: width}
+	for k := range s.skips {
+		s.skips[k] = n - k*n/fanout
+	}
+	return s
+}
+
+func (s *SkipGram) Config() *Config {
+	return &Config{Name: SkipName, SeqLen: len(s.hashes) - 1, Interleave: 1, Fanout: len(s.skips),
+		Hash: s.th.name, Seed: s.th.seed, Width: cfgWidth(s.width)}
+}
+
+func (s *SkipGram) Interleaving() int {
+	return 1
+}
+
+func (s *SkipGram) Skips() []int {
+	return s.skips
+}
+
+func (s *SkipGram) Blot(tok []byte) uint32 {
+	return uint32(s.Blot64(tok))
+}
+
+func (s *SkipGram) Blot64(tok []byte) uint64 {
+	s.buf = s.Blots64(s.buf[:0], tok)
+	return s.buf[0]
+}
+
+func (s *SkipGram) Blots(dst []uint32, tok []byte) []uint32 {
+	s.buf = s.Blots64(s.buf[:0], tok)
+	for _, b := range s.buf {
+		dst = append(dst, uint32(b))
+	}
+	return dst
+}
+
+func (s *SkipGram) Blots64(dst []uint64, tok []byte) []uint64 {
+	s.hashes[s.i] = s.th.sum(bytes.ToLower(tok)) % polyMod
+	s.i++
+	if s.i == len(s.hashes) {
+		s.i = 0
+	}
+	n := len(s.hashes)
+	for _, skip := range s.skips {
+		var h uint64
+		// from the oldest token to the newest.
+		for j := n - 1; j >= 0; j-- {
+			if j == skip {
+				continue
+			}
+			h = polyAdd(polyMul(h, polyBase), s.hashes[(s.i+n-1-j)%n])
+		}
+		dst = append(dst, widen(uint32(h^h>>32), h, s.width))
+	}
+	return dst
+}
+
+func newSkip(c *Config) (T, error) {
+	if c.Interleave != 1 {
+		return nil, fmt.Errorf("invalid config: interleave=%d, skip-gram blotters are not interleaved", c.Interleave)
+	}
+	fanout := c.Fanout
+	if fanout == 0 {
+		fanout = 1
+	}
+	if fanout < 1 || fanout > c.SeqLen {
+		return nil, fmt.Errorf("invalid config: fanout=%d is not between 1 and seqlen=%d", c.Fanout, c.SeqLen)
+	}
+	th, err := newTokHash(c, HashFNV64a)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkWidth(c, 64); err != nil {
+		return nil, err
+	}
+	return newSkipGram(c.SeqLen, fanout, th, c.Width), nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blotter
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestSkipGramPoly(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	words := randWords(r, 30)
+	s, p := NewSkipGram(5, 3), NewPoly(5)
+	for i, w := range words {
+		b, c := s.Blot([]byte(w)), p.Blot([]byte(w))
+		if i >= 5 && b != c {
+			t.Errorf("word %d: skip-gram blot %x differs from poly blot %x", i, b, c)
+		}
+	}
+}
+
+func TestSkipGramInsert(t *testing.T) {
+	const n = 6
+	r := rand.New(rand.NewSource(4))
+	for fanout := 1; fanout <= n; fanout++ {
+		s := NewSkipGram(n, fanout)
+		found := 0
+		for at := 1; at < n; at++ {
+			// a window of n+1 words with a word inserted at
+			// at has the blot of the window without it.
+			words := randWords(r, n)
+			want := windowBlot(newPolyT, words)
+			ins := append(append(append([]string(nil), words[:at]...), "x"), words[at:]...)
+			var blots []uint32
+			for _, w := range ins {
+				blots = s.Blots(blots[:0], []byte(w))
+			}
+			if len(blots) != fanout {
+				t.Fatalf("fanout %d: %d blots", fanout, len(blots))
+			}
+			for k, b := range blots {
+				if b == want {
+					found++
+					if s.Skips()[k] != n-at {
+						t.Errorf("fanout %d: blot %d skips %d, inserted word is %d back", fanout, k, s.Skips()[k], n-at)
+					}
+				}
+			}
+		}
+		if fanout == n && found != n-1 {
+			t.Errorf("fanout %d: found %d of %d insertions", fanout, found, n-1)
+		}
+		if fanout > 1 && found == 0 {
+			t.Errorf("fanout %d: found no insertions", fanout)
+		}
+	}
+}
+
+func TestSkipGramConfig(t *testing.T) {
+	c := &Config{Name: SkipName, SeqLen: 5, Interleave: 1, Fanout: 3}
+	b, err := FromConfig(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := b.Config(); got.Name != c.Name || got.SeqLen != c.SeqLen || got.Interleave != 1 || got.Fanout != c.Fanout {
+		t.Errorf("config %+v gave blotter with config %+v", c, got)
+	}
+	if _, ok := b.(Multi); !ok {
+		t.Errorf("skip-gram blotter gives one blot per token")
+	}
+	for _, bad := range []Config{
+		{Name: SkipName, SeqLen: 5, Interleave: 2},
+		{Name: SkipName, SeqLen: 5, Interleave: 1, Fanout: 6},
+		{Name: SkipName, SeqLen: 5, Interleave: 1, Fanout: -1}} {
+		if _, err := FromConfig(&bad); err == nil {
+			t.Errorf("no error for config %+v", bad)
+		}
+	}
+}
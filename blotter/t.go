@@ -15,8 +15,20 @@
 // package blotter provides fingerprinting for dupi docs.
 package blotter
 
+// T is a blotter.  Blot blots the next token, giving the
+// blot of the window of tokens ending with it.
 type T interface {
 	Config() *Config
 	Blot(tok []byte) uint32
 	Interleaving() int
 }
+
+// Wide is implemented by blotters whose blots may be wider
+// than 32 bits.  Blot64 is like Blot, but gives blots of
+// Config().Width bits, or 32 if it is 0, whose lower 32
+// bits are those given by Blot.  The upper bits tell apart
+// windows whose lower 32 bits collide.
+type Wide interface {
+	T
+	Blot64(tok []byte) uint64
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blotter
+
+// Winnow selects blots from a stream by winnowing: of
+// every window of w consecutive blots, only the minimum
+// is selected, the rightmost one in case of ties.  Since
+// consecutive windows mostly select the same blot, about
+// 2/(w+1) of the blots are selected.  Any w consecutive
+// blots shared by two streams contain a blot selected in
+// both.
+type Winnow struct {
+	blots []uint64
+	// the number of blots added, and the position of the
+	// last selected blot.
+	n    int
+	last int
+}
+
+func NewWinnow(w int) *Winnow {
+	return &Winnow{blots: make([]uint64, w), last: -1}
+}
+
+// Reset starts a new stream.
+func (w *Winnow) Reset() {
+	w.n = 0
+	w.last = -1
+}
+
+// Add adds the blot b at the next position of the stream,
+// returning whether a blot is newly selected, and if so
+// its position in the stream and the blot.
+func (w *Winnow) Add(b uint64) (pos int, blot uint64, ok bool) {
+	w.blots[w.n%len(w.blots)] = b
+	w.n++
+	if w.n < len(w.blots) {
+		return 0, 0, false
+	}
+	return w.sel(w.n - len(w.blots))
+}
+
+// End ends the stream, returning a blot to select for
+// streams shorter than the winnowing window: their
+// minimum.
+func (w *Winnow) End() (pos int, blot uint64, ok bool) {
+	if w.n == 0 || w.n >= len(w.blots) {
+		return 0, 0, false
+	}
+	return w.sel(0)
+}
+
+// sel selects the minimum of the blots from position
+// start.
+func (w *Winnow) sel(start int) (pos int, blot uint64, ok bool) {
+	pos = start
+	blot = w.blots[start%len(w.blots)]
+	for i := start + 1; i < w.n; i++ {
+		if b := w.blots[i%len(w.blots)]; b <= blot {
+			pos, blot = i, b
+		}
+	}
+	if pos == w.last {
+		return 0, 0, false
+	}
+	w.last = pos
+	return pos, blot, true
+}
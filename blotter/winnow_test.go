@@ -0,0 +1,94 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package blotter
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// winnowed returns the positions selected from blots by
+// a Winnow of w blots.
+func winnowed(w int, blots []uint64) map[int]uint64 {
+	res := make(map[int]uint64)
+	wn := NewWinnow(w)
+	for _, b := range blots {
+		if pos, sb, ok := wn.Add(b); ok {
+			res[pos] = sb
+		}
+	}
+	if pos, sb, ok := wn.End(); ok {
+		res[pos] = sb
+	}
+	return res
+}
+
+func TestWinnow(t *testing.T) {
+	const w = 4
+	r := rand.New(rand.NewSource(1))
+	blots := make([]uint64, 10000)
+	for i := range blots {
+		blots[i] = uint64(r.Uint32())
+	}
+	sel := winnowed(w, blots)
+	for i := 0; i+w <= len(blots); i++ {
+		found := false
+		for j := i; j < i+w; j++ {
+			if b, ok := sel[j]; ok {
+				if b != blots[j] {
+					t.Fatalf("position %d selected with blot %x, not %x", j, b, blots[j])
+				}
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("no blot selected in window at %d", i)
+		}
+	}
+	density := float64(len(sel)) / float64(len(blots))
+	if want := 2.0 / (w + 1); density < 0.9*want || density > 1.1*want {
+		t.Errorf("density %.3f, expected about %.3f", density, want)
+	}
+
+	// shared runs of w blots share a selected blot.
+	for i := 0; i < 100; i++ {
+		a, b := make([]uint64, 20+r.Intn(20)), make([]uint64, 20+r.Intn(20))
+		for j := range a {
+			a[j] = uint64(r.Uint32())
+		}
+		for j := range b {
+			b[j] = uint64(r.Uint32())
+		}
+		ia, ib := r.Intn(len(a)-w+1), r.Intn(len(b)-w+1)
+		copy(b[ib:ib+w], a[ia:ia+w])
+		sa, sb := winnowed(w, a), winnowed(w, b)
+		found := false
+		for j := 0; j < w; j++ {
+			_, oka := sa[ia+j]
+			_, okb := sb[ib+j]
+			found = found || oka && okb
+		}
+		if !found {
+			t.Errorf("shared run %x not selected in both", a[ia:ia+w])
+		}
+	}
+
+	if sel := winnowed(w, []uint64{5, 3, 7}); len(sel) != 1 || sel[1] != 3 {
+		t.Errorf("short stream selected %v", sel)
+	}
+	if sel := winnowed(w, nil); len(sel) != 0 {
+		t.Errorf("empty stream selected %v", sel)
+	}
+}
@@ -103,7 +103,7 @@ func indexEnron(index *dupi.Indexer, fname string) error {
 	defer closer()
 	rdr.ReuseRecord = true
 	rcds := 0
-	offset := uint32(0)
+	offset := uint64(0)
 	for {
 		rcd, err := rdr.Read()
 		if err != nil {
@@ -120,8 +120,8 @@ func indexEnron(index *dupi.Indexer, fname string) error {
 		doc := &dupi.Doc{
 			Path:  fname, //"/" + rcd[0],
 			Dat:   msg,
-			Start: offset + uint32(ibody) + 2 + uint32(len(rcd[0]))}
-		doc.End = offset + uint32(len(doc.Dat))
+			Start: offset + uint64(ibody) + 2 + uint64(len(rcd[0]))}
+		doc.End = offset + uint64(len(doc.Dat))
 		offset = doc.End
 		index.Add(doc)
 		rcds++
@@ -138,7 +138,7 @@ func qEnron(index *dupi.Indexer, fname string) error {
 	}
 	defer closer()
 	rcds := 0
-	offset := uint32(0)
+	offset := uint64(0)
 	for {
 		rcd, err := rdr.Read()
 		if err != nil {
@@ -22,7 +22,6 @@ import (
 	"os"
 
 	"github.com/go-air/dupi"
-	"github.com/go-air/dupi/token"
 )
 
 type blotCmd struct {
@@ -44,7 +43,7 @@ func (b *blotCmd) Usage() string {
 func (b *blotCmd) Run(args []string) error {
 	b.flags.Parse(args)
 	root := getIndexRoot()
-	idx, err := dupi.OpenIndex(root)
+	idx, err := dupi.OpenIndex(root, indexOptions()...)
 	if err != nil {
 		log.Fatalf("couldn't open dupi index at '%s': %s", root, err)
 	}
@@ -67,21 +66,16 @@ func (bc *blotCmd) doFilename(fname string, idx *dupi.Index) error {
 	if err != nil {
 		return err
 	}
-	doc := &dupi.Doc{Path: fname, Dat: dat, End: uint32(len(dat))}
-	blots := idx.BlotDoc(nil, doc)
-	var toks []token.T
-	if *bc.offsets {
-		toks = idx.TokenFunc()(nil, doc.Dat, 0)
+	doc := &dupi.Doc{Path: fname, Dat: dat, End: uint64(len(dat))}
+	poss, err := idx.BlotPositions(doc)
+	if err != nil {
+		return err
 	}
-	N := uint32(idx.NumShards()) * (1 << 16)
-	seqLen := idx.SeqLen()
-	for i, b := range blots {
+	for _, p := range poss {
 		if *bc.offsets {
-			beg := toks[i].Pos
-			end := toks[i+seqLen].Pos + uint32(len(toks[i+seqLen].Lit))
-			fmt.Printf("%x %d:%d\n", b%N, beg, end)
+			fmt.Printf("%x %d:%d\n", p.Blot, p.Start, p.End)
 		} else {
-			fmt.Printf("%x\n", b%N)
+			fmt.Printf("%x\n", p.Blot)
 		}
 	}
 	return nil
@@ -0,0 +1,47 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+
+	"github.com/go-air/dupi"
+)
+
+type compactCmd struct {
+	verb
+}
+
+func newCompactCmd() *compactCmd {
+	return &compactCmd{
+		verb: verb{name: "compact", flags: flag.NewFlagSet("compact", flag.ExitOnError)}}
+}
+
+func (cc *compactCmd) Usage() string {
+	return "compact the index root."
+}
+
+func (cc *compactCmd) Run(args []string) error {
+	cc.flags.Parse(args)
+	idx, err := dupi.OpenIndex(getIndexRoot())
+	if err != nil {
+		return err
+	}
+	err = idx.Compact()
+	if cerr := idx.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,73 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"log"
+
+	"github.com/go-air/dupi"
+)
+
+type deleteCmd struct {
+	verb
+	prefix  *bool
+	purge   *bool
+	verbose *bool
+}
+
+func newDeleteCmd() *deleteCmd {
+	cmd := &deleteCmd{
+		verb: verb{name: "delete", flags: flag.NewFlagSet("delete", flag.ExitOnError)}}
+	cmd.prefix = cmd.flags.Bool("r", false, "delete all documents under the given directories")
+	cmd.purge = cmd.flags.Bool("purge", false, "remove the posts of deleted documents from the index")
+	cmd.verbose = cmd.flags.Bool("v", false, "verbose")
+	return cmd
+}
+
+func (dc *deleteCmd) Usage() string {
+	return "delete [paths]"
+}
+
+func (dc *deleteCmd) Run(args []string) error {
+	dc.flags.Parse(args)
+	root := getIndexRoot()
+	idx, err := dupi.OpenIndexer(root)
+	if err != nil {
+		return err
+	}
+	for _, path := range dc.flags.Args() {
+		var n int
+		if *dc.prefix {
+			n, err = idx.DeletePrefix(path)
+		} else {
+			n, err = idx.Delete(path)
+		}
+		if err != nil {
+			idx.Close()
+			return err
+		}
+		if *dc.verbose {
+			log.Printf("deleted %d docs from %s", n, path)
+		}
+	}
+	if err := idx.Close(); err != nil {
+		return err
+	}
+	if *dc.purge {
+		return dupi.PurgeIndex(root)
+	}
+	return nil
+}
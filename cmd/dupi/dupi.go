@@ -23,23 +23,45 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/go-air/dupi"
 	"github.com/google/gops/agent"
 )
 
 // TBD: write and use newIndex(), newExtract()
 // instead of init() ugliness
 var scMap = map[string]Verb{
-	"index":   newIndexCmd(),
-	"extract": newExtractCmd(),
-	"blot":    newBlotCmd(),
-	"unblot":  newUnblotCmd(),
-	"inspect": newInspectCmd(),
-	"like":    newLikeCmd()}
+	"index":    newIndexCmd(),
+	"extract":  newExtractCmd(),
+	"blot":     newBlotCmd(),
+	"unblot":   newUnblotCmd(),
+	"inspect":  newInspectCmd(),
+	"like":     newLikeCmd(),
+	"near":     newNearCmd(),
+	"similar":  newSimilarCmd(),
+	"passages": newPassagesCmd(),
+	"taboo":    newTabooCmd(),
+	"delete":   newDeleteCmd(),
+	"compact":  newCompactCmd(),
+	"merge":    newMergeCmd(),
+	"serve":    newServeCmd(),
+	"fsck":     newFsckCmd()}
 
 var gFlags = flag.NewFlagSet("dupi", flag.ExitOnError)
 
 var root = gFlags.String("r", "", "index root")
 
+var mmapFiles = gFlags.Bool("mmap", false, "read index files through memory maps (linux)")
+
+// indexOptions returns the options for opening the index
+// given by the global flags.
+func indexOptions() []dupi.IndexOption {
+	var opts []dupi.IndexOption
+	if *mmapFiles {
+		opts = append(opts, dupi.WithMmap())
+	}
+	return opts
+}
+
 func getIndexRoot() string {
 	if *root != "" {
 		return *root
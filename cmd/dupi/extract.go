@@ -32,6 +32,9 @@ type extractCmd struct {
 	json     *bool
 	sigma    *float64
 	blotonly *bool
+	strategy *string
+	seed     *int64
+	from     *string
 }
 
 func newExtractCmd() *extractCmd {
@@ -43,6 +46,9 @@ func newExtractCmd() *extractCmd {
 	extract.json = extract.flags.Bool("json", false, "output json")
 	extract.sigma = extract.flags.Float64("sigma", 2.0, "explore blots within σ of average (higher=most probable dups, lower=more volume)")
 	extract.blotonly = extract.flags.Bool("b", false, "output blots only")
+	extract.strategy = extract.flags.String("strategy", "blot", "order of extraction: blot (most docs first), doc (docs with most dups first), random")
+	extract.seed = extract.flags.Int64("seed", 0, "random seed for -strategy random")
+	extract.from = extract.flags.String("from", "", "start at this blot (hex), for -strategy blot")
 	return extract
 }
 
@@ -53,7 +59,11 @@ func (x *extractCmd) Usage() string {
 func (x *extractCmd) Run(args []string) error {
 	var err error
 	x.flags.Parse(args)
-	x.index, err = dupi.OpenIndex(getIndexRoot())
+	strategy, err := dupi.ParseQueryStrategy(*x.strategy)
+	if err != nil {
+		return err
+	}
+	x.index, err = dupi.OpenIndex(getIndexRoot(), indexOptions()...)
 	if err != nil {
 		return err
 	}
@@ -64,7 +74,16 @@ func (x *extractCmd) Run(args []string) error {
 	}
 	σ := *x.sigma
 	N := int(math.Round(st.BlotMean + σ*st.BlotSigma))
-	query := x.index.StartQuery(dupi.QueryMaxBlot)
+	query := x.index.StartQuerySeed(strategy, *x.seed)
+	if *x.from != "" {
+		var blot uint32
+		if _, err := fmt.Sscanf(*x.from, "%x", &blot); err != nil {
+			return fmt.Errorf("invalid blot %q: %w", *x.from, err)
+		}
+		if err := query.Seek(blot); err != nil {
+			return err
+		}
+	}
 	shape := []dupi.Blot{{Blot: 0}}
 	for {
 		n, err := query.Next(shape)
@@ -81,7 +100,12 @@ func (x *extractCmd) Run(args []string) error {
 			return fmt.Errorf("Query.Next gave 0 and no error")
 		}
 		if len(shape[0].Docs) < N {
-			return nil
+			if strategy == dupi.QueryMaxBlot {
+				// blots are in descending order of docs.
+				return nil
+			}
+			shape[0].Docs = nil
+			continue
 		}
 		if *x.json {
 
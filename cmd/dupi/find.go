@@ -0,0 +1,87 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	"github.com/go-air/dupi"
+)
+
+// findCmd is a verb which prints the documents of the
+// index found like each of the files given as arguments.
+type findCmd struct {
+	verb
+	// key names the documents found in json output.
+	key  string
+	json *bool
+	// find returns the documents found like doc, and a
+	// line for each of them.
+	find func(idx *dupi.Index, doc *dupi.Doc) (interface{}, []string, error)
+}
+
+func newFindCmd(name, key string) *findCmd {
+	cmd := &findCmd{
+		verb: verb{name: name, flags: flag.NewFlagSet(name, flag.ExitOnError)},
+		key:  key}
+	cmd.json = cmd.flags.Bool("json", false, "output json")
+	return cmd
+}
+
+func (fc *findCmd) Usage() string {
+	return "[files]"
+}
+
+func (fc *findCmd) Run(args []string) error {
+	fc.flags.Parse(args)
+	root := getIndexRoot()
+	idx, err := dupi.OpenIndex(root, indexOptions()...)
+	if err != nil {
+		log.Fatalf("couldn't open dupi index at '%s': %s", root, err)
+	}
+	defer idx.Close()
+	for _, fname := range fc.flags.Args() {
+		if err := fc.doFilename(idx, fname); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fc *findCmd) doFilename(idx *dupi.Index, fname string) error {
+	dat, err := ioutil.ReadFile(fname)
+	if err != nil {
+		return err
+	}
+	doc := &dupi.Doc{Path: fname, Dat: dat, End: uint64(len(dat))}
+	found, lines, err := fc.find(idx, doc)
+	if err != nil {
+		return err
+	}
+	if *fc.json {
+		enc := json.NewEncoder(os.Stdout)
+		return enc.Encode(map[string]interface{}{"Path": fname, fc.key: found})
+	}
+	fmt.Printf("%s %s:\n", fc.name, fname)
+	for _, line := range lines {
+		fmt.Printf("\t%s\n", line)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/go-air/dupi"
+)
+
+type fsckCmd struct {
+	verb
+	repair *bool
+}
+
+func newFsckCmd() *fsckCmd {
+	cmd := &fsckCmd{
+		verb: verb{name: "fsck", flags: flag.NewFlagSet("fsck", flag.ExitOnError)}}
+	cmd.repair = cmd.flags.Bool("repair", false, "repair blot counts which differ from their posting lists")
+	return cmd
+}
+
+func (fc *fsckCmd) Usage() string {
+	return "check the integrity of the index."
+}
+
+func (fc *fsckCmd) Run(args []string) error {
+	fc.flags.Parse(args)
+	// fsck reports, rather than recovers, an interrupted
+	// indexer or compaction.
+	idx, problems, err := dupi.CheckIndex(getIndexRoot())
+	if err != nil {
+		return err
+	}
+	if idx != nil {
+		defer idx.Close()
+	}
+	repairable := 0
+	for i := range problems {
+		p := &problems[i]
+		fmt.Println(p)
+		if p.Repairable() {
+			repairable++
+		}
+	}
+	if *fc.repair && repairable != 0 {
+		n, err := idx.RepairCounts()
+		if err != nil {
+			return err
+		}
+		log.Printf("repaired %d counts", n)
+		if n == len(problems) {
+			return nil
+		}
+		return fmt.Errorf("%d problems remain", len(problems)-n)
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("%d problems, %d repairable with -repair", len(problems), repairable)
+	}
+	return nil
+}
@@ -24,6 +24,7 @@ import (
 	"strings"
 
 	"github.com/go-air/dupi"
+	"github.com/go-air/dupi/blotter"
 )
 
 type indexCmd struct {
@@ -33,6 +34,15 @@ type indexCmd struct {
 	add     *bool
 	verbose *bool
 	nshat   *int
+	bits    *int
+	winnow  *int
+	fanout  *int
+	hash    *string
+	seed    *uint64
+	width   *int
+	minhash *int
+	simhash *bool
+	ckpt    *int
 	cfgPath *string
 	indexer *dupi.Indexer
 }
@@ -47,6 +57,15 @@ func newIndexCmd() *indexCmd {
 	index.verbose = index.flags.Bool("v", false, "verbose")
 	index.nshat = index.flags.Int("s", 4, "num shatterers")
 	index.shards = index.flags.Int("n", 4, "num shards")
+	index.bits = index.flags.Int("b", dupi.DefaultBlotBits, "log2 of the number of blots per shard")
+	index.winnow = index.flags.Int("w", 0, "post only the minimum blot of every w consecutive blots")
+	index.fanout = index.flags.Int("fanout", 0, "if > 0, use the skip-gram blotter with this many blots per word")
+	index.hash = index.flags.String("hash", "", "hash function of words: fnv32, fnv64a, xxhash or siphash")
+	index.seed = index.flags.Uint64("seed", 0, "seed of the hash function of words, the key of siphash")
+	index.width = index.flags.Int("width", 0, "bits of blots, a multiple of 8 from 32 to 64; wider blots tell collisions apart")
+	index.minhash = index.flags.Int("m", 0, "size of the MinHash signatures of documents for dupi near, 0 for none")
+	index.simhash = index.flags.Bool("simhash", false, "keep SimHash fingerprints of documents for dupi similar")
+	index.ckpt = index.flags.Int("ckpt", 0, "checkpoint every ckpt documents, 0 for only at the start and end")
 	index.cfgPath = index.flags.String("c", "", "config file (overrides all other flags)")
 	return index
 }
@@ -74,6 +93,18 @@ func (x *indexCmd) getIndexer() (*dupi.Indexer, error) {
 			return nil, err
 		}
 		cfg.NumShatters = *x.nshat
+		cfg.BlotBits = *x.bits
+		cfg.BlotConfig.Winnow = *x.winnow
+		if *x.fanout > 0 {
+			cfg.BlotConfig.Name = blotter.SkipName
+			cfg.BlotConfig.Fanout = *x.fanout
+		}
+		cfg.BlotConfig.Hash = *x.hash
+		cfg.BlotConfig.Seed = *x.seed
+		cfg.BlotConfig.Width = *x.width
+		cfg.MinHash = *x.minhash
+		cfg.SimHash = *x.simhash
+		cfg.CheckpointRate = *x.ckpt
 	}
 	return dupi.IndexerFromConfig(cfg)
 }
@@ -129,7 +160,7 @@ func (x *indexCmd) mkWalkFn(perr *error) func(path string, entry fs.DirEntry, er
 			*perr = err
 			return err
 		}
-		doc := &dupi.Doc{Path: path, Dat: dat, End: uint32(len(dat))}
+		doc := &dupi.Doc{Path: path, Dat: dat, End: uint64(len(dat))}
 		if *x.verbose {
 			log.Printf("indexing %s %d:%d\n", path, 0, doc.End)
 		}
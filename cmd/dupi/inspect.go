@@ -51,7 +51,7 @@ func (in *inspectCmd) Run(args []string) error {
 		idx *dupi.Index
 	)
 	in.flags.Parse(args)
-	idx, err = dupi.OpenIndex(getIndexRoot())
+	idx, err = dupi.OpenIndex(getIndexRoot(), indexOptions()...)
 	if err != nil {
 		return err
 	}
@@ -23,7 +23,6 @@ import (
 	"sort"
 
 	"github.com/go-air/dupi"
-	"github.com/go-air/dupi/token"
 )
 
 type likeCmd struct {
@@ -43,7 +42,7 @@ func (lc *likeCmd) Usage() string {
 func (lc *likeCmd) Run(args []string) error {
 	lc.flags.Parse(args)
 	root := getIndexRoot()
-	idx, err := dupi.OpenIndex(root)
+	idx, err := dupi.OpenIndex(root, indexOptions()...)
 	if err != nil {
 		log.Fatalf("couldn't open dupi index at '%s': %s", root, err)
 	}
@@ -58,7 +57,7 @@ func (lc *likeCmd) Run(args []string) error {
 
 type docKey struct {
 	Path       string
-	start, end uint32
+	start, end uint64
 }
 
 func dkey(doc *dupi.Doc) docKey {
@@ -78,35 +77,37 @@ func doFilename(idx *dupi.Index, fname string) error {
 	if err != nil {
 		return err
 	}
-	doc := &dupi.Doc{Path: fname, Dat: dat, End: uint32(len(dat))}
-	blots := idx.BlotDoc(nil, doc)
-	var toks []token.T
-	toks = idx.TokenFunc()(nil, doc.Dat, 0)
-	j := 0
-	for i := range toks {
-		if toks[i].Tag != token.Word {
-			continue
-		}
-		toks[j] = toks[i]
-		j++
+	doc := &dupi.Doc{Path: fname, Dat: dat, End: uint64(len(dat))}
+	keys, err := like(idx, doc)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("like %s:\n", fname)
+	for _, dk := range keys {
+		fmt.Printf("\t%s %d:%d\n", dk.Path, dk.start, dk.end)
+	}
+	return nil
+}
+
+// like returns the indexed documents sharing text
+// with doc.
+func like(idx *dupi.Index, doc *dupi.Doc) ([]docKey, error) {
+	poss, err := idx.BlotPositions(doc)
+	if err != nil {
+		return nil, err
 	}
-	N := uint32(idx.NumShards()) * (1 << 16)
-	seqLen := idx.SeqLen()
-	bm := make(map[uint32][]byte, len(blots))
-	for i, b := range blots {
-		beg := toks[i].Pos
-		end := toks[i+seqLen].Pos + uint32(len(toks[i+seqLen].Lit))
-		bm[b%N] = dat[beg:end]
+	bm := make(map[uint32][]byte, len(poss))
+	for _, p := range poss {
+		bm[p.Blot] = p.Text(doc)
 	}
 	query := idx.StartQuery(dupi.QueryMaxBlot)
 	var db dupi.Blot
 	found := make(map[docKey]int)
-	for _, blot := range blots {
-		b := blot % N
-		db.Blot = b
+	for _, p := range poss {
+		db.Blot = p.Blot
 		db.Docs = nil
-		if err := query.Get(&db); err != nil {
-			return err
+		if err := query.GetChecked(&db, p.Check); err != nil {
+			return nil, err
 		}
 		for i := range db.Docs {
 			doc := &db.Docs[i]
@@ -116,7 +117,7 @@ func doFilename(idx *dupi.Index, fname string) error {
 			}
 			rm, err := idx.FindBlots(bm, doc)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if len(rm) == 0 {
 				continue
@@ -131,9 +132,5 @@ func doFilename(idx *dupi.Index, fname string) error {
 	sort.Slice(keys, func(i, j int) bool {
 		return found[keys[i]] < found[keys[j]]
 	})
-	fmt.Printf("like %s:\n", fname)
-	for _, dk := range keys {
-		fmt.Printf("\t%s %d:%d\n", dk.Path, dk.start, dk.end)
-	}
-	return nil
+	return keys, nil
 }
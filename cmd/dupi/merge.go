@@ -0,0 +1,46 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/go-air/dupi"
+)
+
+type mergeCmd struct {
+	verb
+	out *string
+}
+
+func newMergeCmd() *mergeCmd {
+	cmd := &mergeCmd{
+		verb: verb{name: "merge", flags: flag.NewFlagSet("merge", flag.ExitOnError)}}
+	cmd.out = cmd.flags.String("o", "", "root of the merged index (required)")
+	return cmd
+}
+
+func (mc *mergeCmd) Usage() string {
+	return "merge -o out [index roots]"
+}
+
+func (mc *mergeCmd) Run(args []string) error {
+	mc.flags.Parse(args)
+	if *mc.out == "" {
+		return fmt.Errorf("merge: -o is required")
+	}
+	return dupi.MergeIndices(*mc.out, mc.flags.Args()...)
+}
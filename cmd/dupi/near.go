@@ -0,0 +1,39 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-air/dupi"
+)
+
+func newNearCmd() *findCmd {
+	cmd := newFindCmd("near", "Near")
+	threshold := cmd.flags.Float64("t", 0.8, "minimum estimated Jaccard similarity")
+	cmd.find = func(idx *dupi.Index, doc *dupi.Doc) (interface{}, []string, error) {
+		near, err := idx.NearDuplicates(doc, *threshold)
+		if err != nil {
+			return nil, nil, err
+		}
+		lines := make([]string, len(near))
+		for i := range near {
+			nd := &near[i]
+			lines[i] = fmt.Sprintf("%.2f %s %d:%d", nd.Similarity, nd.Path, nd.Start, nd.End)
+		}
+		return near, lines, nil
+	}
+	return cmd
+}
@@ -0,0 +1,147 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+
+	"github.com/go-air/dupi"
+)
+
+type passagesCmd struct {
+	verb
+	json  *bool
+	sigma *float64
+	text  *bool
+	seen  map[passageKey]bool
+}
+
+func newPassagesCmd() *passagesCmd {
+	cmd := &passagesCmd{
+		verb: verb{name: "passages", flags: flag.NewFlagSet("passages", flag.ExitOnError)}}
+	cmd.json = cmd.flags.Bool("json", false, "output json")
+	cmd.sigma = cmd.flags.Float64("sigma", 2.0, "without blot arguments, explore blots within σ of average")
+	cmd.text = cmd.flags.Bool("text", false, "output the text of each passage")
+	return cmd
+}
+
+func (pc *passagesCmd) Usage() string {
+	return "passages [blots]"
+}
+
+func (pc *passagesCmd) Run(args []string) error {
+	pc.flags.Parse(args)
+	root := getIndexRoot()
+	idx, err := dupi.OpenIndex(root, indexOptions()...)
+	if err != nil {
+		log.Fatalf("couldn't open dupi index at '%s': %s", root, err)
+	}
+	defer idx.Close()
+	pc.seen = make(map[passageKey]bool)
+	query := idx.StartQuery(dupi.QueryMaxBlot)
+	if pc.flags.NArg() != 0 {
+		for _, arg := range pc.flags.Args() {
+			var hex uint32
+			if _, err := fmt.Sscanf(arg, "%x", &hex); err != nil {
+				return err
+			}
+			if err := pc.doBlot(query, &dupi.Blot{Blot: hex}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	st, err := idx.Stats()
+	if err != nil {
+		return err
+	}
+	N := int(math.Round(st.BlotMean + *pc.sigma*st.BlotSigma))
+	shape := []dupi.Blot{{}}
+	for {
+		_, err := query.Next(shape)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(shape[0].Docs) < N {
+			return nil
+		}
+		if err := pc.doBlot(query, &shape[0]); err != nil {
+			return err
+		}
+		shape[0].Docs = nil
+	}
+}
+
+func (pc *passagesCmd) doBlot(query *dupi.Query, blot *dupi.Blot) error {
+	ps, err := query.Passages(blot)
+	if err != nil {
+		return err
+	}
+	for i := range ps {
+		p := &ps[i]
+		k := passageKey{a: dkey(&p.A), b: dkey(&p.B)}
+		if pc.seen[k] {
+			continue
+		}
+		pc.seen[k] = true
+		if err := pc.output(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type passageKey struct {
+	a, b docKey
+}
+
+type jsonPassage struct {
+	*dupi.Passage
+	Text string `json:",omitempty"`
+}
+
+func (pc *passagesCmd) output(p *dupi.Passage) error {
+	var text string
+	if *pc.text {
+		txt := p.A
+		if err := txt.Load(); err != nil {
+			return err
+		}
+		text = string(txt.Dat)
+	}
+	if *pc.json {
+		d, err := json.MarshalIndent(&jsonPassage{Passage: p, Text: text}, "", "\t")
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(d, '\n'))
+		return err
+	}
+	fmt.Printf("%s@%d:%d %s@%d:%d %d\n", p.A.Path, p.A.Start, p.A.End,
+		p.B.Path, p.B.Start, p.B.End, p.Blots)
+	if *pc.text {
+		fmt.Printf("'''\n%s'''\n", text)
+	}
+	return nil
+}
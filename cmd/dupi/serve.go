@@ -0,0 +1,426 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-air/dupi"
+)
+
+type serveCmd struct {
+	verb
+	addr       *string
+	ttl        *time.Duration
+	maxBody    *int64
+	maxCursors *int
+	timeout    *time.Duration
+}
+
+func newServeCmd() *serveCmd {
+	cmd := &serveCmd{
+		verb: verb{name: "serve", flags: flag.NewFlagSet("serve", flag.ExitOnError)}}
+	cmd.addr = cmd.flags.String("addr", "localhost:8080", "address to listen on")
+	cmd.ttl = cmd.flags.Duration("ttl", 10*time.Minute, "time after which unused extract cursors expire")
+	cmd.maxBody = cmd.flags.Int64("maxbody", 16<<20, "maximum size of posted documents")
+	cmd.maxCursors = cmd.flags.Int("cursors", 1024, "maximum number of live extract cursors")
+	cmd.timeout = cmd.flags.Duration("timeout", time.Minute, "time limit for reading a request and for writing its response")
+	return cmd
+}
+
+func (sc *serveCmd) Usage() string {
+	return "serve queries over http/json."
+}
+
+func (sc *serveCmd) Run(args []string) error {
+	sc.flags.Parse(args)
+	root := getIndexRoot()
+	idx, err := dupi.OpenIndex(root, indexOptions()...)
+	if err != nil {
+		return fmt.Errorf("couldn't open dupi index at '%s': %w", root, err)
+	}
+	defer idx.Close()
+	srv, err := newServer(idx, *sc.ttl, *sc.maxBody, *sc.maxCursors)
+	if err != nil {
+		return err
+	}
+	hs := &http.Server{
+		Addr:              *sc.addr,
+		Handler:           srv.mux(),
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       *sc.timeout,
+		WriteTimeout:      *sc.timeout}
+	log.Printf("serving %s on %s", root, *sc.addr)
+	return hs.ListenAndServe()
+}
+
+// server serves queries on an index over http, with
+// json responses.
+//
+//	GET  /stats
+//	GET  /extract?strategy=blot|doc|random&seed=0&sigma=2&n=100
+//	GET  /extract?cursor=c&n=100
+//	GET  /unblot?blot=hex&all=false
+//	GET  /passages?blot=hex
+//	POST /blot  (document in body)
+//	POST /like  (document in body)
+//
+// Extract responses carry a cursor while there are more
+// results, which is passed to get the next page.  Cursors
+// unused for ttl expire, and at most maxCursors are kept,
+// the least recently used being dropped first.  Errors
+// in parameters give status 400, and other errors 500.
+//
+// Requests are served in parallel, as queries on idx may
+// run in parallel.
+type server struct {
+	idx   *dupi.Index
+	stats *dupi.Stats
+	// mu guards cursors.
+	mu         sync.Mutex
+	cursors    map[string]*cursor
+	ttl        time.Duration
+	maxBody    int64
+	maxCursors int
+}
+
+// cursor is the state of an extraction across pages.
+type cursor struct {
+	// mu serializes the requests for the pages of the
+	// cursor, which use query.
+	mu       sync.Mutex
+	query    *dupi.Query
+	strategy dupi.QueryStrategy
+	// minimum number of docs per blot
+	min  int
+	used time.Time
+}
+
+type extractPage struct {
+	Blots  []dupi.Blot
+	Cursor string `json:",omitempty"`
+}
+
+type serverError struct {
+	Error string
+}
+
+// badRequest is an error in the parameters of a request.
+type badRequest struct {
+	err error
+}
+
+func (e *badRequest) Error() string {
+	return e.err.Error()
+}
+
+func (e *badRequest) Unwrap() error {
+	return e.err
+}
+
+func badRequestf(format string, args ...interface{}) error {
+	return &badRequest{err: fmt.Errorf(format, args...)}
+}
+
+const (
+	maxPage           = 10000
+	readHeaderTimeout = 10 * time.Second
+)
+
+func newServer(idx *dupi.Index, ttl time.Duration, maxBody int64, maxCursors int) (*server, error) {
+	if maxCursors < 1 {
+		return nil, fmt.Errorf("invalid maximum number of cursors %d", maxCursors)
+	}
+	st, err := idx.Stats()
+	if err != nil {
+		return nil, err
+	}
+	return &server{
+		idx:        idx,
+		stats:      st,
+		cursors:    make(map[string]*cursor),
+		ttl:        ttl,
+		maxBody:    maxBody,
+		maxCursors: maxCursors}, nil
+}
+
+func (s *server) mux() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/stats", s.handle(http.MethodGet, s.serveStats))
+	mux.HandleFunc("/extract", s.handle(http.MethodGet, s.serveExtract))
+	mux.HandleFunc("/unblot", s.handle(http.MethodGet, s.serveUnblot))
+	mux.HandleFunc("/passages", s.handle(http.MethodGet, s.servePassages))
+	mux.HandleFunc("/blot", s.handle(http.MethodPost, s.serveBlot))
+	mux.HandleFunc("/like", s.handle(http.MethodPost, s.serveLike))
+	return mux
+}
+
+func (s *server) handle(method string, fn func(r *http.Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.Method != method {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			json.NewEncoder(w).Encode(&serverError{Error: "method must be " + method})
+			return
+		}
+		res, err := fn(r)
+		if err != nil {
+			var br *badRequest
+			if errors.As(err, &br) {
+				w.WriteHeader(http.StatusBadRequest)
+			} else {
+				log.Printf("error serving %s: %s", r.URL, err)
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			res = &serverError{Error: err.Error()}
+		}
+		if err := json.NewEncoder(w).Encode(res); err != nil {
+			log.Printf("error writing response to %s: %s", r.RemoteAddr, err)
+		}
+	}
+}
+
+func (s *server) serveStats(r *http.Request) (interface{}, error) {
+	return s.idx.Stats()
+}
+
+func (s *server) serveExtract(r *http.Request) (interface{}, error) {
+	n, err := intParam(r, "n", 100)
+	if err != nil {
+		return nil, err
+	}
+	if n < 1 || n > maxPage {
+		return nil, badRequestf("n must be between 1 and %d", maxPage)
+	}
+	id := r.FormValue("cursor")
+	var c *cursor
+	if id != "" {
+		if c = s.cursor(id); c == nil {
+			return nil, badRequestf("unknown or expired cursor '%s'", id)
+		}
+	} else {
+		if c, err = s.newCursor(r); err != nil {
+			return nil, err
+		}
+		if id, err = newCursorID(); err != nil {
+			return nil, err
+		}
+	}
+	page := &extractPage{}
+	c.mu.Lock()
+	done, err := s.fill(page, c, n)
+	c.mu.Unlock()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil || done {
+		delete(s.cursors, id)
+		return page, err
+	}
+	c.used = time.Now()
+	if s.cursors[id] == nil {
+		s.expire()
+		if len(s.cursors) >= s.maxCursors {
+			s.evict()
+		}
+	}
+	s.cursors[id] = c
+	page.Cursor = id
+	return page, nil
+}
+
+// cursor returns the cursor with id, or nil if there is
+// none, after expiring unused cursors.
+func (s *server) cursor(id string) *cursor {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.expire()
+	c := s.cursors[id]
+	if c != nil {
+		c.used = time.Now()
+	}
+	return c
+}
+
+func (s *server) newCursor(r *http.Request) (*cursor, error) {
+	strategy, err := dupi.ParseQueryStrategy(stringParam(r, "strategy", "blot"))
+	if err != nil {
+		return nil, &badRequest{err: err}
+	}
+	seed, err := intParam(r, "seed", 0)
+	if err != nil {
+		return nil, err
+	}
+	sigma := 2.0
+	if v := r.FormValue("sigma"); v != "" {
+		if sigma, err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, badRequestf("invalid sigma: %w", err)
+		}
+	}
+	return &cursor{
+		query:    s.idx.StartQuerySeed(strategy, int64(seed)),
+		strategy: strategy,
+		min:      int(math.Round(s.stats.BlotMean + sigma*s.stats.BlotSigma))}, nil
+}
+
+// fill fills page with up to n blots from c, returning
+// whether c is exhausted.
+func (s *server) fill(page *extractPage, c *cursor, n int) (bool, error) {
+	shape := make([]dupi.Blot, 1)
+	for len(page.Blots) < n {
+		shape[0].Docs = nil
+		_, err := c.query.Next(shape)
+		if err == io.EOF {
+			return true, nil
+		}
+		if err != nil {
+			return true, err
+		}
+		if len(shape[0].Docs) < c.min {
+			if c.strategy == dupi.QueryMaxBlot {
+				// blots are in descending order of docs.
+				return true, nil
+			}
+			continue
+		}
+		page.Blots = append(page.Blots, shape[0])
+	}
+	return false, nil
+}
+
+// expire removes cursors unused for s.ttl.  s.mu must be
+// held.
+func (s *server) expire() {
+	now := time.Now()
+	for id, c := range s.cursors {
+		if now.Sub(c.used) > s.ttl {
+			delete(s.cursors, id)
+		}
+	}
+}
+
+// evict removes the least recently used cursor.  s.mu
+// must be held.
+func (s *server) evict() {
+	var oldID string
+	var old *cursor
+	for id, c := range s.cursors {
+		if old == nil || c.used.Before(old.used) {
+			oldID, old = id, c
+		}
+	}
+	delete(s.cursors, oldID)
+}
+
+func (s *server) serveUnblot(r *http.Request) (interface{}, error) {
+	hex, err := blotParam(r)
+	if err != nil {
+		return nil, err
+	}
+	all := stringParam(r, "all", "false") == "true"
+	query := s.idx.StartQuery(dupi.QueryMaxBlot)
+	return unblot(s.idx, query, hex, all)
+}
+
+func (s *server) servePassages(r *http.Request) (interface{}, error) {
+	hex, err := blotParam(r)
+	if err != nil {
+		return nil, err
+	}
+	query := s.idx.StartQuery(dupi.QueryMaxBlot)
+	return query.Passages(&dupi.Blot{Blot: hex})
+}
+
+func (s *server) serveBlot(r *http.Request) (interface{}, error) {
+	doc, err := s.bodyDoc(r)
+	if err != nil {
+		return nil, err
+	}
+	return s.idx.BlotPositions(doc)
+}
+
+func (s *server) serveLike(r *http.Request) (interface{}, error) {
+	doc, err := s.bodyDoc(r)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := like(s.idx, doc)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]dupi.Doc, len(keys))
+	for i, k := range keys {
+		res[i] = dupi.Doc{Path: k.Path, Start: k.start, End: k.end}
+	}
+	return res, nil
+}
+
+func (s *server) bodyDoc(r *http.Request) (*dupi.Doc, error) {
+	dat, err := ioutil.ReadAll(io.LimitReader(r.Body, s.maxBody+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(dat)) > s.maxBody {
+		return nil, badRequestf("document is larger than %d bytes", s.maxBody)
+	}
+	return &dupi.Doc{Path: "-", Dat: dat, End: uint64(len(dat))}, nil
+}
+
+func newCursorID() (string, error) {
+	var buf [8]byte
+	if _, err := rand.Read(buf[:]); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
+
+func stringParam(r *http.Request, name, def string) string {
+	if v := r.FormValue(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func intParam(r *http.Request, name string, def int) (int, error) {
+	v := r.FormValue(name)
+	if v == "" {
+		return def, nil
+	}
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, badRequestf("invalid %s: %w", name, err)
+	}
+	return i, nil
+}
+
+func blotParam(r *http.Request) (uint32, error) {
+	var hex uint32
+	if _, err := fmt.Sscanf(r.FormValue("blot"), "%x", &hex); err != nil {
+		return 0, badRequestf("invalid blot '%s': %w", r.FormValue("blot"), err)
+	}
+	return hex, nil
+}
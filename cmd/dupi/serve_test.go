@@ -0,0 +1,276 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-air/dupi"
+)
+
+var serveTestDocs = []string{
+	"the quick brown fox jumps over the lazy dog while the cat sleeps on the mat all day long.",
+	"yesterday the quick brown fox jumps over the lazy dog while the cat sleeps on the mat all day long.",
+	"a completely different text which shares nothing with the others at all in any way whatsoever"}
+
+// testIndex creates an index of docs in a temporary
+// directory, returning its root.
+func testIndex(t *testing.T, docs []string) string {
+	t.Helper()
+	tmp, err := ioutil.TempDir("", "dupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+	root := filepath.Join(tmp, "dupi")
+	idxr, err := dupi.CreateIndexer(root, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range docs {
+		path := filepath.Join(tmp, fmt.Sprintf("doc%d", i))
+		if err := ioutil.WriteFile(path, []byte(d), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := idxr.Add(&dupi.Doc{Path: path, End: uint64(len(d)), Dat: []byte(d)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+// testServer starts a server on an index of
+// serveTestDocs.
+func testServer(t *testing.T, ttl time.Duration, maxBody int64, maxCursors int) (*httptest.Server, *server) {
+	t.Helper()
+	idx, err := dupi.OpenIndex(testIndex(t, serveTestDocs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, err := newServer(idx, ttl, maxBody, maxCursors)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(srv.mux())
+	t.Cleanup(func() {
+		hs.Close()
+		idx.Close()
+	})
+	return hs, srv
+}
+
+// get gets path from hs, decoding the response into res,
+// if it is not nil, and returning the status.
+func get(t *testing.T, hs *httptest.Server, path string, res interface{}) int {
+	t.Helper()
+	resp, err := http.Get(hs.URL + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decode(t, resp, res)
+}
+
+// post posts body to path of hs, as get.
+func post(t *testing.T, hs *httptest.Server, path, body string, res interface{}) int {
+	t.Helper()
+	resp, err := http.Post(hs.URL+path, "text/plain", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decode(t, resp, res)
+}
+
+func decode(t *testing.T, resp *http.Response, res interface{}) int {
+	t.Helper()
+	defer resp.Body.Close()
+	if res == nil {
+		res = &serverError{}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	return resp.StatusCode
+}
+
+// extractAll extracts all blots from hs with pages of n
+// blots, following cursors.
+func extractAll(t *testing.T, hs *httptest.Server, n int) []dupi.Blot {
+	t.Helper()
+	var res []dupi.Blot
+	path := fmt.Sprintf("/extract?sigma=-10&n=%d", n)
+	for {
+		page := &extractPage{}
+		if st := get(t, hs, path, page); st != http.StatusOK {
+			t.Fatalf("extract gave status %d", st)
+		}
+		if len(page.Blots) > n {
+			t.Fatalf("page of %d blots, more than %d", len(page.Blots), n)
+		}
+		res = append(res, page.Blots...)
+		if page.Cursor == "" {
+			return res
+		}
+		path = fmt.Sprintf("/extract?cursor=%s&n=%d", page.Cursor, n)
+	}
+}
+
+func TestServeExtract(t *testing.T) {
+	hs, _ := testServer(t, time.Minute, 1<<10, 1024)
+	all := extractAll(t, hs, maxPage)
+	if len(all) < 2 {
+		t.Fatalf("only %d blots extracted", len(all))
+	}
+	if paged := extractAll(t, hs, 1); fmt.Sprint(paged) != fmt.Sprint(all) {
+		t.Errorf("paged extract got %v want %v", paged, all)
+	}
+	for _, path := range []string{
+		"/extract?n=0",
+		"/extract?n=x",
+		"/extract?strategy=nope",
+		"/extract?sigma=x",
+		"/extract?cursor=nope",
+	} {
+		if st := get(t, hs, path, nil); st != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", path, st)
+		}
+	}
+}
+
+func TestServeCursorExpiry(t *testing.T) {
+	hs, _ := testServer(t, time.Millisecond, 1<<10, 1024)
+	page := &extractPage{}
+	if st := get(t, hs, "/extract?sigma=-10&n=1", page); st != http.StatusOK || page.Cursor == "" {
+		t.Fatalf("extract gave status %d cursor '%s'", st, page.Cursor)
+	}
+	time.Sleep(10 * time.Millisecond)
+	serr := &serverError{}
+	if st := get(t, hs, "/extract?n=1&cursor="+page.Cursor, serr); st != http.StatusBadRequest {
+		t.Errorf("expired cursor gave status %d", st)
+	}
+	if !strings.Contains(serr.Error, "expired") {
+		t.Errorf("expired cursor gave error '%s'", serr.Error)
+	}
+}
+
+func TestServeCursorLimit(t *testing.T) {
+	hs, srv := testServer(t, time.Minute, 1<<10, 2)
+	var ids []string
+	for i := 0; i < 3; i++ {
+		page := &extractPage{}
+		if st := get(t, hs, "/extract?sigma=-10&n=1", page); st != http.StatusOK || page.Cursor == "" {
+			t.Fatalf("extract gave status %d cursor '%s'", st, page.Cursor)
+		}
+		ids = append(ids, page.Cursor)
+	}
+	if n := len(srv.cursors); n != 2 {
+		t.Errorf("%d cursors kept, want 2", n)
+	}
+	if st := get(t, hs, "/extract?n=1&cursor="+ids[0], nil); st != http.StatusBadRequest {
+		t.Errorf("least recently used cursor gave status %d", st)
+	}
+	for _, id := range ids[1:] {
+		if st := get(t, hs, "/extract?n=1&cursor="+id, &extractPage{}); st != http.StatusOK {
+			t.Errorf("cursor %s gave status %d", id, st)
+		}
+	}
+
+	// cursors expire when others are made.
+	srv.ttl = time.Millisecond
+	time.Sleep(10 * time.Millisecond)
+	if st := get(t, hs, "/extract?sigma=-10&n=1", &extractPage{}); st != http.StatusOK {
+		t.Fatalf("extract gave status %d", st)
+	}
+	if n := len(srv.cursors); n != 1 {
+		t.Errorf("%d cursors kept after expiry, want 1", n)
+	}
+}
+
+func TestServeBodyLimit(t *testing.T) {
+	doc := serveTestDocs[0]
+	hs, _ := testServer(t, time.Minute, int64(len(doc)), 1024)
+	var bps []dupi.BlotPos
+	if st := post(t, hs, "/blot", doc, &bps); st != http.StatusOK {
+		t.Fatalf("blot gave status %d", st)
+	}
+	if len(bps) == 0 {
+		t.Errorf("no blots for document")
+	}
+	for _, path := range []string{"/blot", "/like"} {
+		if st := post(t, hs, path, doc+" ", nil); st != http.StatusBadRequest {
+			t.Errorf("%s: document over the limit gave status %d", path, st)
+		}
+	}
+}
+
+func TestServeHandlers(t *testing.T) {
+	hs, _ := testServer(t, time.Minute, 1<<10, 1024)
+	st := &dupi.Stats{}
+	if code := get(t, hs, "/stats", st); code != http.StatusOK || st.NumPosts == 0 {
+		t.Errorf("stats gave status %d and %+v", code, st)
+	}
+
+	blot := extractAll(t, hs, maxPage)[0].Blot
+	var texts []unblotText
+	if code := get(t, hs, fmt.Sprintf("/unblot?blot=%x&all=true", blot), &texts); code != http.StatusOK {
+		t.Fatalf("unblot gave status %d", code)
+	}
+	n := 0
+	for _, txt := range texts {
+		n += len(txt.Docs)
+	}
+	if n != 2 {
+		t.Errorf("unblot gave %+v", texts)
+	}
+	var ps []dupi.Passage
+	if code := get(t, hs, fmt.Sprintf("/passages?blot=%x", blot), &ps); code != http.StatusOK {
+		t.Fatalf("passages gave status %d", code)
+	}
+	if len(ps) != 1 {
+		t.Errorf("passages gave %+v", ps)
+	}
+
+	var docs []dupi.Doc
+	if code := post(t, hs, "/like", serveTestDocs[0], &docs); code != http.StatusOK {
+		t.Fatalf("like gave status %d", code)
+	}
+	if len(docs) != 2 {
+		t.Errorf("like gave %+v", docs)
+	}
+
+	for _, path := range []string{"/unblot?blot=zz", "/passages?blot=" + url.QueryEscape("#")} {
+		if code := get(t, hs, path, nil); code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d", path, code)
+		}
+	}
+	if code := get(t, hs, "/like", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("get of like gave status %d", code)
+	}
+	if code := post(t, hs, "/stats", "", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("post of stats gave status %d", code)
+	}
+}
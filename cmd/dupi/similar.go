@@ -0,0 +1,39 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/go-air/dupi"
+)
+
+func newSimilarCmd() *findCmd {
+	cmd := newFindCmd("similar", "Similar")
+	bits := cmd.flags.Int("b", 3, "maximum number of differing SimHash fingerprint bits")
+	cmd.find = func(idx *dupi.Index, doc *dupi.Doc) (interface{}, []string, error) {
+		similar, err := idx.SimilarDocs(doc, *bits)
+		if err != nil {
+			return nil, nil, err
+		}
+		lines := make([]string, len(similar))
+		for i := range similar {
+			sd := &similar[i]
+			lines[i] = fmt.Sprintf("%d %s %d:%d", sd.Distance, sd.Path, sd.Start, sd.End)
+		}
+		return similar, lines, nil
+	}
+	return cmd
+}
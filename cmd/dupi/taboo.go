@@ -0,0 +1,112 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/go-air/dupi"
+)
+
+type tabooCmd struct {
+	verb
+	name *string
+	text *string
+	show *bool
+}
+
+func newTabooCmd() *tabooCmd {
+	cmd := &tabooCmd{
+		verb: verb{name: "taboo", flags: flag.NewFlagSet("taboo", flag.ExitOnError)}}
+	cmd.name = cmd.flags.String("name", "", "name of the -text snippet (add)")
+	cmd.text = cmd.flags.String("text", "", "taboo text snippet (add)")
+	cmd.show = cmd.flags.Bool("v", false, "output the text of entries (ls)")
+	return cmd
+}
+
+func (tc *tabooCmd) Usage() string {
+	return "taboo add [files] | rm [names] | ls"
+}
+
+func (tc *tabooCmd) Run(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: %s", tc.Usage())
+	}
+	tc.flags.Parse(args[1:])
+	root := getIndexRoot()
+	switch args[0] {
+	case "add":
+		return dupi.EditTaboo(root, tc.add)
+	case "rm":
+		return dupi.EditTaboo(root, tc.rm)
+	case "ls":
+		return tc.ls(root)
+	default:
+		return fmt.Errorf("unknown taboo command '%s', usage: %s", args[0], tc.Usage())
+	}
+}
+
+func (tc *tabooCmd) add(t *dupi.Taboo) error {
+	if *tc.text != "" {
+		if *tc.name == "" {
+			return fmt.Errorf("-text requires -name")
+		}
+		t.Add(*tc.name, *tc.text)
+	}
+	for _, fname := range tc.flags.Args() {
+		abs, err := filepath.Abs(fname)
+		if err != nil {
+			return err
+		}
+		dat, err := ioutil.ReadFile(abs)
+		if err != nil {
+			return err
+		}
+		t.Add(abs, string(dat))
+	}
+	return nil
+}
+
+func (tc *tabooCmd) rm(t *dupi.Taboo) error {
+	for _, name := range tc.flags.Args() {
+		if t.Remove(name) {
+			continue
+		}
+		abs, err := filepath.Abs(name)
+		if err != nil || !t.Remove(abs) {
+			return fmt.Errorf("no taboo entry '%s'", name)
+		}
+	}
+	return nil
+}
+
+func (tc *tabooCmd) ls(root string) error {
+	idx, err := dupi.OpenIndex(root)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+	for _, e := range idx.Taboo().Entries {
+		if *tc.show {
+			fmt.Printf("%s:\n'''\n%s'''\n", e.Name, e.Text)
+			continue
+		}
+		fmt.Println(e.Name)
+	}
+	return nil
+}
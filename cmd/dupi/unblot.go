@@ -41,7 +41,7 @@ func (ub *unblotCmd) Usage() string {
 func (ub *unblotCmd) Run(args []string) error {
 	ub.flags.Parse(args)
 	root := getIndexRoot()
-	idx, err := dupi.OpenIndex(root)
+	idx, err := dupi.OpenIndex(root, indexOptions()...)
 	if err != nil {
 		log.Fatalf("couldn't open dupi index at '%s': %s", root, err)
 	}
@@ -52,33 +52,59 @@ func (ub *unblotCmd) Run(args []string) error {
 		if _, err := fmt.Sscanf(arg, "%x", &hex); err != nil {
 			return err
 		}
-		blot := &dupi.Blot{Blot: hex}
-		if err := query.Get(blot); err != nil {
+		texts, err := unblot(idx, query, hex, *ub.all)
+		if err != nil {
 			return err
 		}
-
-		m := make(map[string][]*dupi.Doc)
-		for i := range blot.Docs {
-			doc := &blot.Docs[i]
-
-			start, end, err := idx.FindBlot(hex, doc)
-			if err != nil {
-				log.Printf("warning: %s", err)
-				continue
-			}
-			dat := string(doc.Dat[start-doc.Start : end-doc.Start])
-			doc.Dat = nil
-			m[dat] = append(m[dat], doc)
-		}
-		for k, ds := range m {
-			if !*ub.all && len(ds) < 2 {
-				continue
-			}
-			fmt.Printf("text:\n'''\n%s'''\n", k)
-			for _, d := range ds {
+		for _, t := range texts {
+			fmt.Printf("text:\n'''\n%s'''\n", t.Text)
+			for _, d := range t.Docs {
 				fmt.Printf("\t%s %d:%d\n", d.Path, d.Start, d.End)
 			}
 		}
 	}
 	return nil
 }
+
+// unblotText is a text of a blot and the documents
+// in which it appears.
+type unblotText struct {
+	Text string
+	Docs []*dupi.Doc
+}
+
+// unblot returns the texts of blot hex.  Unless all is
+// set, only texts appearing in more than one document
+// are returned.
+func unblot(idx *dupi.Index, query *dupi.Query, hex uint32, all bool) ([]unblotText, error) {
+	blot := &dupi.Blot{Blot: hex}
+	if err := query.Get(blot); err != nil {
+		return nil, err
+	}
+	m := make(map[string][]*dupi.Doc)
+	var order []string
+	for i := range blot.Docs {
+		doc := &blot.Docs[i]
+
+		start, end, err := idx.FindBlot(hex, doc)
+		if err != nil {
+			log.Printf("warning: %s", err)
+			continue
+		}
+		dat := string(doc.Dat[start-doc.Start : end-doc.Start])
+		doc.Dat = nil
+		if m[dat] == nil {
+			order = append(order, dat)
+		}
+		m[dat] = append(m[dat], doc)
+	}
+	var res []unblotText
+	for _, k := range order {
+		ds := m[k]
+		if !all && len(ds) < 2 {
+			continue
+		}
+		res = append(res, unblotText{Text: k, Docs: ds})
+	}
+	return res, nil
+}
@@ -0,0 +1,197 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Compaction writes a complete copy of the index root to
+// root + compactTmp, renames it to root + compactNew once
+// it is synced, and then swaps it with root, moving root to
+// root + compactOld until it is removed.  recoverRoot
+// completes or discards a compaction interrupted at any
+// point.
+const (
+	compactTmp = ".compact.tmp"
+	compactNew = ".compact"
+	compactOld = ".compact.old"
+)
+
+// Compact rewrites the shards of x so that the posts of
+// each blot are contiguous on disk, dropping the posts of
+// deleted documents.
+//
+// Compact waits for all other users of the index to close
+// it, and must not be called while the calling program has
+// any other Index or Indexer open on the same root.  While
+// it waits its shared lock is released, so x is reopened
+// to compact what others added.  Where upgrading a lock is
+// atomic, as with flock over NFS, two programs compacting
+// or repairing the same index at once may wait for each
+// other forever.
+//
+// Once the compacted copy replaces the index, x is
+// reopened on it.  If that fails, x is closed, as by
+// Close, and must not be used other than to call Close
+// again.
+func (x *Index) Compact() (err error) {
+	downgrade, err := x.upgrade()
+	if err != nil {
+		return err
+	}
+	defer downgrade(&err)
+	root := x.config.IndexRoot
+	tmpCfg := *x.config
+	tmpCfg.IndexRoot = root + compactTmp
+	if err := x.writeCompact(&tmpCfg); err != nil {
+		os.RemoveAll(tmpCfg.IndexRoot)
+		return err
+	}
+	if err := os.Rename(tmpCfg.IndexRoot, root+compactNew); err != nil {
+		os.RemoveAll(tmpCfg.IndexRoot)
+		return err
+	}
+	return x.reopen(func() error {
+		return swapCompact(root)
+	})
+}
+
+// PurgeIndex removes the posts of deleted documents from
+// the index at root by compacting it.
+func PurgeIndex(root string) error {
+	idx, err := OpenIndex(root)
+	if err != nil {
+		return err
+	}
+	err = idx.Compact()
+	if cerr := idx.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// writeCompact writes a compacted copy of x to the root of
+// cfg, which must not exist.
+func (x *Index) writeCompact(cfg *Config) error {
+	if err := os.Mkdir(cfg.IndexRoot, 0755); err != nil {
+		return err
+	}
+	rewritten := make(map[string]bool)
+	for i := range x.shards {
+		rewritten[x.config.PostPath(i)] = true
+		rewritten[x.config.PostPath(i)+".iix"] = true
+	}
+	ents, err := os.ReadDir(x.config.IndexRoot)
+	if err != nil {
+		return err
+	}
+	for _, ent := range ents {
+		src := filepath.Join(x.config.IndexRoot, ent.Name())
+		if rewritten[src] || ent.IsDir() {
+			continue
+		}
+		if err := copyFile(filepath.Join(cfg.IndexRoot, ent.Name()), src); err != nil {
+			return err
+		}
+	}
+	keep := func(did uint64) bool {
+		return !x.tombs.Has(did)
+	}
+	for i := range x.shards {
+		if err := x.shards[i].Rewrite(cfg.PostPath(i), keep); err != nil {
+			return fmt.Errorf("error compacting shard %d: %w", i, err)
+		}
+	}
+	return syncDir(cfg.IndexRoot)
+}
+
+// swapCompact replaces root with the complete compacted
+// copy root + compactNew.
+func swapCompact(root string) error {
+	if exists(root) {
+		if err := os.Rename(root, root+compactOld); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(root+compactNew, root); err != nil {
+		return err
+	}
+	if err := syncDir(filepath.Dir(root)); err != nil {
+		return err
+	}
+	return os.RemoveAll(root + compactOld)
+}
+
+// compacted returns whether a compaction of the index at
+// root was interrupted.
+func compacted(root string) (bool, error) {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return false, err
+	}
+	return exists(root+compactTmp) || exists(root+compactNew) || exists(root+compactOld), nil
+}
+
+// recoverRoot completes or discards any compaction of
+// the index at root which was interrupted.  The lock of
+// the index must be held exclusively.
+func recoverRoot(root string) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(root + compactTmp); err != nil {
+		return err
+	}
+	if exists(root + compactNew) {
+		return swapCompact(root)
+	}
+	return os.RemoveAll(root + compactOld)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func copyFile(dst, src string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Sync()
+}
+
+func syncDir(path string) error {
+	d, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
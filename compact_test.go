@@ -0,0 +1,149 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/go-air/dupi/lock"
+)
+
+func TestCompact(t *testing.T) {
+	root := testIndex(t, queryTestDocs)
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blots := queryBlots(t, idx.StartQuery(QueryMaxBlot))
+	want := fmt.Sprint(blots)
+	if err := idx.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(queryBlots(t, idx.StartQuery(QueryMaxBlot))); got != want {
+		t.Errorf("after compaction got blots %s want %s", got, want)
+	}
+
+	// interrupt a compaction after moving the root away.
+	tmpCfg := *idx.config
+	tmpCfg.IndexRoot = idx.Root() + compactTmp
+	if err := idx.writeCompact(&tmpCfg); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmpCfg.IndexRoot, idx.Root()+compactNew); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(idx.Root(), idx.Root()+compactOld); err != nil {
+		t.Fatal(err)
+	}
+	idx, err = OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(queryBlots(t, idx.StartQuery(QueryMaxBlot))); got != want {
+		t.Errorf("after recovery got blots %s want %s", got, want)
+	}
+	for _, sfx := range []string{compactTmp, compactNew, compactOld} {
+		if exists(idx.Root() + sfx) {
+			t.Errorf("recovery left %s", idx.Root()+sfx)
+		}
+	}
+	idx.Close()
+
+	idxr, err := OpenIndexer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewDoc(root, queryTestDocs[2])
+	doc.End = uint64(len(doc.Dat))
+	if err := idxr.Add(doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	idx, err = OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if got := queryBlots(t, idx.StartQuery(QueryMaxBlot)); len(got) <= len(blots) {
+		t.Errorf("appending to a compacted index gave no new blots")
+	}
+}
+
+// TestCompactReopen checks that Compact sees documents
+// added after the index was opened, and that an index
+// which cannot be reopened is closed.
+func TestCompactReopen(t *testing.T) {
+	root := testIndex(t, queryTestDocs[:3])
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	idxr, err := OpenIndexer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := NewDoc(root, queryTestDocs[3])
+	doc.End = uint64(len(doc.Dat))
+	if err := idxr.Add(doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fresh, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := fmt.Sprint(queryBlots(t, fresh.StartQuery(QueryMaxBlot)))
+	fresh.Close()
+	if err := idx.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if got := fmt.Sprint(queryBlots(t, idx.StartQuery(QueryMaxBlot))); got != want {
+		t.Errorf("compaction of stale index got blots %s want %s", got, want)
+	}
+	if ps, err := idx.Verify(); err != nil || len(ps) != 0 {
+		t.Errorf("compacted index has problems %v %v", ps, err)
+	}
+
+	if err := os.Remove(idx.config.FnamesPath()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.RepairCounts(); err == nil {
+		t.Fatal("no error reopening index without file names")
+	}
+	if idx.lock != nil || idx.shards != nil {
+		t.Errorf("index not closed after failing to reopen")
+	}
+	if err := idx.Close(); err != nil {
+		t.Errorf("closing index closed by failure gave %v", err)
+	}
+	lk, err := lock.New(idx.config.LockPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lk.Close()
+	if ok, err := lk.TryLock(); err != nil || !ok {
+		t.Errorf("index closed by failure is still locked: %v", err)
+	}
+}
@@ -16,29 +16,100 @@ package dupi
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
 
 	"github.com/go-air/dupi/blotter"
+	"github.com/go-air/dupi/internal/format"
+	"github.com/go-air/dupi/minhash"
 	"github.com/go-air/dupi/token"
 )
 
+// Index format versions.  Indices with a format version
+// older than FormatVersion3 can be queried, but not added
+// to.
+const (
+	// FormatVersion1 indices have 32 bit document ids.
+	FormatVersion1 = 1
+	// FormatVersion2 indices have 64 bit document ids.
+	FormatVersion2 = 2
+	// FormatVersion3 indices have headers with magic
+	// numbers and versions at the start of their files,
+	// and checksums on their posts and file names.
+	FormatVersion3 = 3
+	// FormatVersion indices may have blots wider than 32
+	// bits, whose upper bits are stored with their posts.
+	FormatVersion = 4
+)
+
+// Blot space sizes.  Each shard of an index holds
+// 1<<BlotBits blots.
+const (
+	DefaultBlotBits = 16
+	MinBlotBits     = 4
+	MaxBlotBits     = 24
+)
+
+// ErrOldFormat is returned when adding to an index with
+// an older format version.
+var ErrOldFormat = errors.New("old index format is read only")
+
+var (
+	// ErrIncompatibleFormat is the error of index files
+	// in a format unknown to this version of dupi.
+	ErrIncompatibleFormat = format.ErrIncompatible
+	// ErrCorrupt is the error of index files which are
+	// truncated or fail their checksums.
+	ErrCorrupt = format.ErrCorrupt
+)
+
+// FileError is the type of ErrIncompatibleFormat and
+// ErrCorrupt errors, giving the path of the file with
+// the problem.
+type FileError = format.Error
+
 type Config struct {
+	// Version is the format version of the index.
+	// Indices created before format versions were
+	// recorded have version 0, which is FormatVersion1.
+	Version     int `json:",omitempty"`
 	IndexRoot   string
 	SeqLen      int
 	NumShards   int
 	NumShatters int
+	// BlotBits gives the number of blots 1<<BlotBits
+	// of each shard.  0 means DefaultBlotBits, which
+	// all indices created before BlotBits had.
+	BlotBits int `json:",omitempty"`
 
 	// How frequently buckets write document
 	// data to disk.  Higher= less memory,
 	// more frequent i/o.
 	// Frequency in terms of number of documents.
 	DocFlushRate int
+	// How frequently indexers checkpoint the index,
+	// in number of documents.  0 means only when an
+	// indexer is opened or closed, or Checkpoint is
+	// called.  Each checkpoint ends the last chunk of
+	// every blot with posts, adding a chunk header and
+	// a read to its posting list until compaction, so
+	// small rates fragment the index.
+	CheckpointRate int `json:",omitempty"`
 
 	TokenConfig token.Config
 	BlotConfig  blotter.Config
+
+	// MinHash is the number of entries of the MinHash
+	// signatures of documents kept for NearDuplicates,
+	// a multiple of minhash.Rows, or 0 to keep none.
+	MinHash int `json:",omitempty"`
+
+	// SimHash is whether to keep SimHash fingerprints of
+	// documents for SimilarDocs.
+	SimHash bool `json:",omitempty"`
 }
 
 func DefaultConfig(root string) (*Config, error) {
@@ -48,9 +119,11 @@ func DefaultConfig(root string) (*Config, error) {
 		return nil, err
 	}
 	cfg.IndexRoot = abs
+	cfg.Version = FormatVersion
 	cfg.DocFlushRate = 16384
 	cfg.NumShards = 2
 	cfg.NumShatters = 2
+	cfg.BlotBits = DefaultBlotBits
 	cfg.SeqLen = 10
 	cfg.TokenConfig = *token.DefaultConfig()
 	cfg.BlotConfig = *blotter.DefaultConfig()
@@ -79,9 +152,99 @@ func (cfg *Config) check() error {
 	if cfg.SeqLen != cfg.BlotConfig.Interleave*cfg.BlotConfig.SeqLen {
 		return fmt.Errorf("inconsistent sequence config")
 	}
+	if cfg.BlotConfig.Winnow < 0 {
+		return fmt.Errorf("invalid winnowing window %d", cfg.BlotConfig.Winnow)
+	}
+	if cfg.MinHash < 0 || cfg.MinHash%minhash.Rows != 0 {
+		return fmt.Errorf("MinHash signature size %d is not a multiple of %d", cfg.MinHash, minhash.Rows)
+	}
+	if cfg.Version > FormatVersion {
+		return format.Incompatible(cfg.Path(), "format version %d is newer than %d",
+			cfg.Version, FormatVersion)
+	}
+	if cfg.checkBytes() != 0 && cfg.FormatVersion() < FormatVersion {
+		return fmt.Errorf("blots of %d bits need format version %d", cfg.BlotConfig.Width, FormatVersion)
+	}
+	if bits := cfg.blotBits(); bits < MinBlotBits || bits > MaxBlotBits {
+		return fmt.Errorf("blot bits %d not between %d and %d", bits, MinBlotBits, MaxBlotBits)
+	}
+	if cfg.NumShards < 1 || cfg.NumBlots() > 1<<32 {
+		return fmt.Errorf("%d shards of %d blots do not fit in 32 bit blots",
+			cfg.NumShards, 1<<cfg.blotBits())
+	}
+	return cfg.checkRegistered()
+}
+
+// checkRegistered checks that the tokenizer and blotter
+// of cfg are registered in this program, and that the
+// blotter gives blots as wide as configured.
+func (cfg *Config) checkRegistered() error {
+	_, err := token.FromConfig(&cfg.TokenConfig)
+	if errors.Is(err, token.ErrUnregistered) {
+		return fmt.Errorf("index %s uses tokenizer %q, which must be registered with token.Register: %w",
+			cfg.IndexRoot, cfg.TokenConfig.Name, err)
+	}
+	if err != nil {
+		return fmt.Errorf("index %s: invalid tokenizer config: %w", cfg.IndexRoot, err)
+	}
+	bler, err := blotter.FromConfig(&cfg.BlotConfig)
+	if errors.Is(err, blotter.ErrUnregistered) {
+		return fmt.Errorf("index %s uses blotter %q, which must be registered with blotter.Register: %w",
+			cfg.IndexRoot, cfg.BlotConfig.Name, err)
+	}
+	if err != nil {
+		return fmt.Errorf("index %s: invalid blotter config: %w", cfg.IndexRoot, err)
+	}
+	if _, ok := bler.(blotter.Wide); !ok && cfg.BlotConfig.Width > 32 {
+		return fmt.Errorf("index %s: blotter %q gives 32 bit blots, not %d",
+			cfg.IndexRoot, cfg.BlotConfig.Name, cfg.BlotConfig.Width)
+	}
 	return nil
 }
 
+// FormatVersion returns the format version of the index
+// of cfg.
+func (cfg *Config) FormatVersion() int {
+	if cfg.Version < FormatVersion1 {
+		return FormatVersion1
+	}
+	return cfg.Version
+}
+
+// blotBits returns the number of bits of shard blots of
+// the index of cfg.
+func (cfg *Config) blotBits() int {
+	if cfg.BlotBits == 0 {
+		return DefaultBlotBits
+	}
+	return cfg.BlotBits
+}
+
+// checkBytes returns the number of bytes of the upper
+// bits of blots stored with each post of the index of cfg.
+func (cfg *Config) checkBytes() int {
+	if cfg.BlotConfig.Width <= 32 {
+		return 0
+	}
+	return (cfg.BlotConfig.Width - 32) / 8
+}
+
+// NumBlots returns the number of blots of the index of
+// cfg, over all shards.  Blots are reduced modulo
+// NumBlots.
+func (cfg *Config) NumBlots() uint64 {
+	return uint64(cfg.NumShards) << uint(cfg.blotBits())
+}
+
+// didSize returns the size in bytes of stored document
+// ids of the index of cfg.
+func (cfg *Config) didSize() int {
+	if cfg.FormatVersion() == FormatVersion1 {
+		return 4
+	}
+	return 8
+}
+
 func (cfg *Config) Path() string {
 	return filepath.Join(cfg.IndexRoot, "cfg.json")
 }
@@ -94,6 +257,30 @@ func (cfg *Config) DmdPath() string {
 	return filepath.Join(cfg.IndexRoot, "dmd")
 }
 
+// MinHashPath returns the path of the MinHash signatures
+// of the documents of the index of cfg.
+func (cfg *Config) MinHashPath() string {
+	return filepath.Join(cfg.IndexRoot, "minhash")
+}
+
+// SimHashPath returns the path of the SimHash fingerprints
+// of the documents of the index of cfg.
+func (cfg *Config) SimHashPath() string {
+	return filepath.Join(cfg.IndexRoot, "simhash")
+}
+
+func (cfg *Config) JournalPath() string {
+	return filepath.Join(cfg.IndexRoot, "journal")
+}
+
+func (cfg *Config) checkpointPath(seq uint64) string {
+	return filepath.Join(cfg.IndexRoot, fmt.Sprintf("ckpt.%d", seq))
+}
+
+func (cfg *Config) TabooPath() string {
+	return filepath.Join(cfg.IndexRoot, "taboo.json")
+}
+
 func (cfg *Config) FnamesPath() string {
 	return filepath.Join(cfg.IndexRoot, "files.fnm")
 }
@@ -128,7 +315,7 @@ func ReadConfig(path string) (*Config, error) {
 	var cfg Config
 	err = json.Unmarshal(d, &cfg)
 	if err != nil {
-		return nil, err
+		return nil, format.Corrupt(path, "%v", err)
 	}
 	err = cfg.check()
 	if err != nil {
@@ -138,7 +325,7 @@ func ReadConfig(path string) (*Config, error) {
 }
 
 func (cfg *Config) Write() error {
-	f, err := os.OpenFile(cfg.Path(), os.O_CREATE|os.O_WRONLY, 0644)
+	f, err := os.OpenFile(cfg.Path(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		return err
 	}
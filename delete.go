@@ -0,0 +1,58 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+// Delete deletes all documents added with path 'path',
+// returning the number of documents deleted.
+//
+// Deleted documents are recorded as tombstones, and are
+// no longer returned by queries, near duplicate or
+// similarity searches or FindBlots started after Delete
+// returns, including those on indices already open.
+// Their posts remain in the index until PurgeIndex is
+// called.
+func (x *Indexer) Delete(path string) (int, error) {
+	fid, ok, err := x.fnames.find(path)
+	if err != nil || !ok {
+		return 0, err
+	}
+	return x.deleteFids(map[uint32]bool{fid: true})
+}
+
+// DeletePrefix is like Delete, but deletes all documents
+// whose path is 'prefix' or is in the directory 'prefix'.
+func (x *Indexer) DeletePrefix(prefix string) (int, error) {
+	fid, ok, err := x.fnames.find(prefix)
+	if err != nil || !ok {
+		return 0, err
+	}
+	fids := make(map[uint32]bool)
+	x.fnames.subtree(fids, fid)
+	return x.deleteFids(fids)
+}
+
+func (x *Indexer) deleteFids(fids map[uint32]bool) (int, error) {
+	var dids []uint64
+	err := x.dmds.Each(func(did uint64, fid uint32, _, _ uint64) error {
+		if fids[fid] {
+			dids = append(dids, did)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return x.tombs.Add(dids...)
+}
@@ -0,0 +1,159 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDelete(t *testing.T) {
+	root := testIndex(t, queryTestDocs)
+	dir := filepath.Dir(root)
+	idxr, err := OpenIndexer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := idxr.Delete(filepath.Join(dir, "doc1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("deleted %d docs, want 1", n)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := idx.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.NumDeleted != 1 {
+		t.Errorf("stats gave %d deleted docs", st.NumDeleted)
+	}
+	if blots := queryBlots(t, idx.StartQuery(QueryMaxBlot)); len(blots) != 0 {
+		t.Errorf("query gave blots %x of deleted doc", blots)
+	}
+	doc := &Doc{Path: filepath.Join(dir, "doc1")}
+	m := map[uint32][]byte{}
+	for _, b := range idx.BlotDoc(nil, NewDoc(doc.Path, queryTestDocs[1])) {
+		m[b%(2<<16)] = []byte{}
+	}
+	doc.End = uint64(len(queryTestDocs[1]))
+	if res, err := idx.FindBlots(m, doc); err != nil || len(res) != 0 {
+		t.Errorf("FindBlots on deleted doc gave %v %v", res, err)
+	}
+	idx.Close()
+
+	if err := PurgeIndex(root); err != nil {
+		t.Fatal(err)
+	}
+	idx, err = OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pst, err := idx.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pst.NumPosts >= st.NumPosts {
+		t.Errorf("purge left %d posts of %d", pst.NumPosts, st.NumPosts)
+	}
+	idx.Close()
+
+	idxr, err = OpenIndexer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc = &Doc{Path: filepath.Join(dir, "doc0"), End: uint64(len(queryTestDocs[0])), Dat: []byte(queryTestDocs[0])}
+	if err := idxr.Add(doc); err != nil {
+		t.Fatal(err)
+	}
+	if n, err = idxr.DeletePrefix(filepath.Join(dir, "nothing")); err != nil || n != 0 {
+		t.Errorf("DeletePrefix of unknown path gave %d %v", n, err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	idx, err = OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blots := queryBlots(t, idx.StartQuery(QueryMaxBlot)); len(blots) == 0 {
+		t.Errorf("no blots after appending to a purged index")
+	}
+	idx.Close()
+
+	idxr, err = OpenIndexer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err = idxr.DeletePrefix(dir); err != nil || n != 5 {
+		t.Errorf("DeletePrefix gave %d %v, want 5", n, err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDeleteOpen checks that a delete takes effect on an
+// index opened before it.
+func TestDeleteOpen(t *testing.T) {
+	root := testIndex(t, queryTestDocs)
+	dir := filepath.Dir(root)
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	for _, s := range []QueryStrategy{QueryMaxBlot, QueryMaxDoc, QueryRandom} {
+		if blots := queryBlots(t, idx.StartQuery(s)); len(blots) == 0 {
+			t.Fatalf("%s query gave no blots before delete", s)
+		}
+	}
+	idxr, err := OpenIndexer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := idxr.Delete(filepath.Join(dir, "doc1")); err != nil || n != 1 {
+		t.Fatalf("Delete gave %d %v", n, err)
+	}
+	// the indexer need not be closed.
+	defer idxr.Close()
+	for _, s := range []QueryStrategy{QueryMaxBlot, QueryMaxDoc, QueryRandom} {
+		if blots := queryBlots(t, idx.StartQuery(s)); len(blots) != 0 {
+			t.Errorf("%s query gave blots %x of deleted doc", s, blots)
+		}
+	}
+	st, err := idx.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.NumDeleted != 1 {
+		t.Errorf("stats gave %d deleted docs", st.NumDeleted)
+	}
+	doc := &Doc{Path: filepath.Join(dir, "doc1"), End: uint64(len(queryTestDocs[1]))}
+	m := map[uint32][]byte{}
+	for _, b := range idx.BlotDoc(nil, NewDoc(doc.Path, queryTestDocs[1])) {
+		m[idx.ReduceBlot(b)] = []byte{}
+	}
+	if res, err := idx.FindBlots(m, doc); err != nil || len(res) != 0 {
+		t.Errorf("FindBlots on deleted doc gave %v %v", res, err)
+	}
+}
@@ -15,7 +15,8 @@
 package dmd
 
 import (
-	"encoding/binary"
+	"bufio"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
@@ -23,48 +24,142 @@ import (
 
 type Adder struct {
 	path      string
-	flushed   uint32
-	flushRate uint32
+	flushed   uint64
+	flushRate uint64
 	buf       []fields
 }
 
 func NewAdder(root string, fr int) (*Adder, error) {
-	ufr := uint32(fr)
+	ufr := uint64(fr)
 	path := filepath.Join(root, "dmd")
 	adder := &Adder{path: path, flushRate: ufr}
-	fi, err := os.Stat(path)
+	f, err := os.Open(path)
 	if os.IsNotExist(err) {
 		adder.buf = make([]fields, 1, fr)
+		adder.buf[0] = header()
 		adder.flushed = 0
 		return adder, nil
 	}
 	if err != nil {
 		return nil, err
 	}
-	adder.flushed = uint32(fi.Size() / rcdSize)
+	defer f.Close()
+	v, err := readVersion(f)
+	if err != nil {
+		return nil, err
+	}
+	if v != Version {
+		return nil, fmt.Errorf("%s has version %d: %w", path, v, ErrOldVersion)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	adder.flushed = uint64(fi.Size() / rcdSize)
 	if adder.flushed == 0 {
 		adder.buf = make([]fields, 1, fr)
+		adder.buf[0] = header()
 	} else {
 		adder.buf = make([]fields, 0, fr)
 	}
 	return adder, nil
 }
 
-func (t *Adder) Add(fid, start, end uint32) (uint32, error) {
-	n := uint32(len(t.buf))
+// header returns the fields of record 0.
+func header() fields {
+	return fields{fid: magic, start: Version}
+}
+
+func (t *Adder) Add(fid uint32, start, end uint64) (uint64, error) {
+	n := uint64(len(t.buf))
 	if n == t.flushRate {
 		err := t.flush()
 		if err != nil {
 			return 0, err
 		}
 	}
-	n = uint32(len(t.buf))
+	n = uint64(len(t.buf))
 	t.buf = append(t.buf, fields{fid, start, end})
 	return n + t.flushed, nil
 }
 
-func (t *Adder) Last() uint32 {
-	return t.flushed + uint32(len(t.buf)) - 1
+// Each calls fn for every document added so far, flushed
+// or not, in increasing order of document id.
+func (t *Adder) Each(fn func(did uint64, fid uint32, start, end uint64) error) error {
+	did := uint64(0)
+	f, err := os.Open(t.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		defer f.Close()
+		r := bufio.NewReader(f)
+		var buf [rcdSize]byte
+		for did < t.flushed {
+			if _, err := io.ReadFull(r, buf[:]); err != nil {
+				return err
+			}
+			fid, start, end := decode(buf[:])
+			if did != 0 {
+				if err := fn(did, fid, start, end); err != nil {
+					return err
+				}
+			}
+			did++
+		}
+	}
+	for i := range t.buf {
+		fields := &t.buf[i]
+		if did != 0 {
+			if err := fn(did, fields.fid, fields.start, fields.end); err != nil {
+				return err
+			}
+		}
+		did++
+	}
+	return nil
+}
+
+func (t *Adder) Last() uint64 {
+	return t.flushed + uint64(len(t.buf)) - 1
+}
+
+// Sync writes all added documents to disk and syncs
+// them.
+func (t *Adder) Sync() error {
+	if err := t.flush(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(t.path, os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// Truncate removes the records of the documents under
+// root with ids n and above, returning how many were
+// removed.
+func Truncate(root string, n uint64) (uint64, error) {
+	path := filepath.Join(root, "dmd")
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	have := uint64(fi.Size() / rcdSize)
+	if have < n {
+		return 0, fmt.Errorf("%s has %d records, fewer than %d", path, have, n)
+	}
+	if err := f.Truncate(int64(n) * rcdSize); err != nil {
+		return 0, err
+	}
+	return have - n, f.Sync()
 }
 
 func (t *Adder) Close() error {
@@ -84,14 +179,18 @@ func (t *Adder) flush() error {
 	if err != nil {
 		return err
 	}
+	w := bufio.NewWriter(f)
+	var buf [rcdSize]byte
 	for i := range t.buf {
-		fields := &t.buf[i]
-		err := binary.Write(f, binary.BigEndian, fields)
-		if err != nil {
+		encode(buf[:], &t.buf[i])
+		if _, err := w.Write(buf[:]); err != nil {
 			return err
 		}
 	}
-	t.flushed += uint32(len(t.buf))
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	t.flushed += uint64(len(t.buf))
 	t.buf = t.buf[:0]
 	return nil
 }
@@ -15,8 +15,11 @@
 package dmd
 
 import (
+	"encoding/binary"
+	"errors"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -76,3 +79,119 @@ func TestAdder(t *testing.T) {
 	}
 
 }
+
+func TestAdderLargeOffsets(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "dmd.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	adder, err := NewAdder(tmp, 16384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	did, err := adder.Add(7, 5<<32, 5<<32+100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := adder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dmd, err := New(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dmd.Close()
+	if dmd.Version() != Version {
+		t.Errorf("version %d", dmd.Version())
+	}
+	fid, start, end, err := dmd.Lookup(did)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fid != 7 || start != 5<<32 || end != 5<<32+100 {
+		t.Errorf("got %d %d %d", fid, start, end)
+	}
+}
+
+func TestVersion1(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "dmd.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	var buf [2 * rcdSizeV1]byte
+	binary.BigEndian.PutUint32(buf[12:], 3)
+	binary.BigEndian.PutUint32(buf[16:], 10)
+	binary.BigEndian.PutUint32(buf[20:], 20)
+	if err := ioutil.WriteFile(filepath.Join(tmp, "dmd"), buf[:], 0644); err != nil {
+		t.Fatal(err)
+	}
+	dmd, err := New(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dmd.Close()
+	if dmd.Version() != 1 {
+		t.Errorf("version %d", dmd.Version())
+	}
+	n, err := dmd.NumDocs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("%d docs", n)
+	}
+	fid, start, end, err := dmd.Lookup(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fid != 3 || start != 10 || end != 20 {
+		t.Errorf("got %d %d %d", fid, start, end)
+	}
+	if _, err := NewAdder(tmp, 16384); !errors.Is(err, ErrOldVersion) {
+		t.Errorf("adding to version 1 gave %v", err)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "dmd.test.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	adder, err := NewAdder(tmp, 16384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := uint64(0); i < 10; i++ {
+		if _, err := adder.Add(1, i*10, i*10+5); err != nil {
+			t.Fatal(err)
+		}
+		if i == 5 {
+			if err := adder.Sync(); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := adder.Close(); err != nil {
+		t.Fatal(err)
+	}
+	n, err := Truncate(tmp, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 4 {
+		t.Errorf("truncated %d records want 4", n)
+	}
+	adder, err = NewAdder(tmp, 16384)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if adder.Last() != 6 {
+		t.Errorf("last doc %d after truncation", adder.Last())
+	}
+	if _, err := Truncate(tmp, 8); err == nil {
+		t.Errorf("truncated to more records than present")
+	}
+}
@@ -16,5 +16,5 @@ package dmd
 
 type fields struct {
 	fid        uint32
-	start, end uint32
+	start, end uint64
 }
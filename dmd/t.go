@@ -13,22 +13,54 @@
 // limitations under the License.
 
 // Package dmd maps document, offset pairs to internal document ids.
+//
+// The dmd file is a sequence of fixed size records, one per
+// document id.  Record 0, which is not a document, is a
+// header giving the version of the record format.  In
+// version 1, records are 12 bytes: a 32 bit file id and 32
+// bit start and end offsets, and the header is all zeros.
+// In version 2, offsets are 64 bits, giving 20 byte records,
+// and the header has file id magic and start Version.
+//
+// Version 1 files may be read but not added to.
 package dmd
 
 import (
 	"encoding/binary"
+	"errors"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+
+	"github.com/go-air/dupi/internal/format"
+	"github.com/go-air/dupi/internal/mmap"
+)
+
+// Version is the version of the record format written
+// by Adder.
+const Version = 2
+
+// ErrOldVersion is returned when adding to a dmd file
+// with a record format older than Version.
+var ErrOldVersion = errors.New("dmd: old record format is read only")
+
+const (
+	magic     = 0x646d64ff // "dmd\xff"
+	rcdSizeV1 = 12
+	rcdSize   = 20
 )
 
+// T reads the dmd file of an index.  A T may be used by
+// many goroutines at once.
 type T struct {
-	path string
-	file *os.File
+	path    string
+	file    *os.File
+	version int
+	rcdSize int64
+	mm      *mmap.File
 }
 
-const rcdSize = 12
-
 func New(root string) (*T, error) {
 	res := &T{path: filepath.Join(root, "dmd")}
 	var err error
@@ -36,34 +68,118 @@ func New(root string) (*T, error) {
 	if err != nil {
 		return nil, err
 	}
+	res.version, err = readVersion(res.file)
+	if err != nil {
+		res.file.Close()
+		return nil, err
+	}
+	res.rcdSize = recordSize(res.version)
 	return res, nil
 }
 
+// Version returns the version of the record format
+// of t.
+func (t *T) Version() int {
+	return t.version
+}
+
 func (t *T) NumDocs() (uint64, error) {
 	fi, err := t.file.Stat()
 	if err != nil {
 		return 0, err
 	}
-	return uint64(fi.Size()) / rcdSize, nil
+	return uint64(fi.Size() / t.rcdSize), nil
 }
 
-func (t *T) Lookup(did uint32) (fid, start, end uint32, err error) {
-	f := t.file
-	_, err = f.Seek(int64(did)*rcdSize, 0)
+// Mmap makes t look up records through a memory map,
+// where they are supported.  Records added after Mmap are
+// read from the file.
+func (t *T) Mmap() error {
+	mm, err := mmap.Map(t.file)
 	if err != nil {
-		return
+		return fmt.Errorf("%s: %w", t.path, err)
+	}
+	t.mm = mm
+	return nil
+}
+
+// Lookup returns the record of the document did.  Lookup
+// may be called concurrently.
+func (t *T) Lookup(did uint64) (fid uint32, start, end uint64, err error) {
+	if t.mm != nil {
+		data := t.mm.Bytes()
+		if off := did * uint64(t.rcdSize); did < uint64(len(data))/uint64(t.rcdSize) {
+			fid, start, end = decode(data[off : off+uint64(t.rcdSize)])
+			return
+		}
 	}
 	var buf [rcdSize]byte
-	_, err = io.ReadFull(f, buf[:])
+	_, err = t.file.ReadAt(buf[:t.rcdSize], int64(did)*t.rcdSize)
 	if err != nil {
 		return
 	}
-	fid = binary.BigEndian.Uint32(buf[0:4])
-	start = binary.BigEndian.Uint32(buf[4:8])
-	end = binary.BigEndian.Uint32(buf[8:rcdSize])
+	fid, start, end = decode(buf[:t.rcdSize])
 	return
 }
 
 func (t *T) Close() error {
+	if t.mm != nil {
+		if err := t.mm.Close(); err != nil {
+			return err
+		}
+		t.mm = nil
+	}
 	return t.file.Close()
 }
+
+// readVersion reads the version of the record format
+// of the dmd file f.  Empty files have the current
+// version.
+func readVersion(f *os.File) (int, error) {
+	var buf [rcdSize]byte
+	n, err := f.ReadAt(buf[:], 0)
+	if n == 0 && err == io.EOF {
+		return Version, nil
+	}
+	if n < rcdSizeV1 {
+		return 0, format.Corrupt(f.Name(), "short header: %v", err)
+	}
+	if binary.BigEndian.Uint32(buf[0:4]) != magic {
+		return 1, nil
+	}
+	if n < rcdSize {
+		return 0, format.Corrupt(f.Name(), "short header: %v", err)
+	}
+	_, v, _ := decode(buf[:])
+	if v != Version {
+		return 0, format.Incompatible(f.Name(), "unknown version %d", v)
+	}
+	return int(v), nil
+}
+
+func recordSize(version int) int64 {
+	if version == 1 {
+		return rcdSizeV1
+	}
+	return rcdSize
+}
+
+// decode decodes a record of either version, as given
+// by the size of buf.
+func decode(buf []byte) (fid uint32, start, end uint64) {
+	fid = binary.BigEndian.Uint32(buf[0:4])
+	if len(buf) == rcdSizeV1 {
+		start = uint64(binary.BigEndian.Uint32(buf[4:8]))
+		end = uint64(binary.BigEndian.Uint32(buf[8:12]))
+		return
+	}
+	start = binary.BigEndian.Uint64(buf[4:12])
+	end = binary.BigEndian.Uint64(buf[12:20])
+	return
+}
+
+func encode(buf []byte, f *fields) {
+	binary.BigEndian.PutUint32(buf[0:4], f.fid)
+	binary.BigEndian.PutUint64(buf[4:12], f.start)
+	binary.BigEndian.PutUint64(buf[12:20], f.end)
+}
@@ -0,0 +1,233 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dmd
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/go-air/dupi/internal/format"
+)
+
+// Tombs is the set of deleted document ids, stored
+// next to the dmd file.  Tombs is safe for use by many
+// goroutines at once.
+type Tombs struct {
+	path    string
+	didSize int
+	mu      sync.RWMutex
+	set     map[uint64]bool
+	// bytes of the file read or written so far
+	size int64
+}
+
+// OpenTombs reads the deleted document ids of the
+// index at root, which are stored with didSize bytes,
+// either 4 or 8.
+func OpenTombs(root string, didSize int) (*Tombs, error) {
+	if didSize != 4 && didSize != 8 {
+		return nil, fmt.Errorf("invalid document id size %d", didSize)
+	}
+	res := &Tombs{
+		path:    filepath.Join(root, "dmd.del"),
+		didSize: didSize,
+		set:     make(map[uint64]bool)}
+	d, err := ioutil.ReadFile(res.path)
+	if os.IsNotExist(err) {
+		return res, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(d)%didSize != 0 {
+		return nil, format.Corrupt(res.path, "truncated record")
+	}
+	res.decode(d)
+	return res, nil
+}
+
+// decode adds the ids of the records in d, which follow
+// those read so far, returning those not already deleted.
+func (t *Tombs) decode(d []byte) []uint64 {
+	var res []uint64
+	for i := 0; i+t.didSize <= len(d); i += t.didSize {
+		var did uint64
+		if t.didSize == 4 {
+			did = uint64(binary.BigEndian.Uint32(d[i:]))
+		} else {
+			did = binary.BigEndian.Uint64(d[i:])
+		}
+		if !t.set[did] {
+			t.set[did] = true
+			res = append(res, did)
+		}
+	}
+	t.size += int64(len(d))
+	return res
+}
+
+// Update reads the deleted document ids added to the
+// tombstones since t was opened or last updated, by
+// another Tombs of the same index, and returns those
+// not already deleted.  Tombstones are only appended to
+// while an index is in use, so Update costs a stat of
+// the tombstones file when there are none.
+func (t *Tombs) Update() ([]uint64, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fi, err := os.Stat(t.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// a record being written is read once it is whole.
+	n := (fi.Size() - t.size) / int64(t.didSize) * int64(t.didSize)
+	if n <= 0 {
+		return nil, nil
+	}
+	f, err := os.Open(t.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	d := make([]byte, n)
+	if _, err := f.ReadAt(d, t.size); err != nil {
+		return nil, err
+	}
+	return t.decode(d), nil
+}
+
+// Has returns whether did is deleted.
+func (t *Tombs) Has(did uint64) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.set[did]
+}
+
+// Len returns the number of deleted document ids.
+func (t *Tombs) Len() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return len(t.set)
+}
+
+// Dids returns the deleted document ids in
+// increasing order.
+func (t *Tombs) Dids() []uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	res := make([]uint64, 0, len(t.set))
+	for did := range t.set {
+		res = append(res, did)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// Add marks dids as deleted, returning the number of
+// dids not already deleted.  The dids are synced to
+// disk before Add returns.
+func (t *Tombs) Add(dids ...uint64) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var buf []byte
+	var tmp [8]byte
+	added := make(map[uint64]bool)
+	for _, did := range dids {
+		if t.set[did] || added[did] {
+			continue
+		}
+		added[did] = true
+		if t.didSize == 4 {
+			binary.BigEndian.PutUint32(tmp[:], uint32(did))
+		} else {
+			binary.BigEndian.PutUint64(tmp[:], did)
+		}
+		buf = append(buf, tmp[:t.didSize]...)
+	}
+	if len(buf) == 0 {
+		return 0, nil
+	}
+	f, err := os.OpenFile(t.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err = f.Write(buf); err != nil {
+		return 0, err
+	}
+	if err = f.Sync(); err != nil {
+		return 0, err
+	}
+	for did := range added {
+		t.set[did] = true
+	}
+	t.size += int64(len(buf))
+	return len(added), nil
+}
+
+// TruncateTombs removes the deleted document ids n and
+// above from the tombstones under root, returning how
+// many were removed.  The remaining ids are rewritten
+// and synced before replacing the tombstones file.
+func TruncateTombs(root string, didSize int, n uint64) (int, error) {
+	t, err := OpenTombs(root, didSize)
+	if err != nil {
+		return 0, err
+	}
+	var buf []byte
+	var tmp [8]byte
+	for _, did := range t.Dids() {
+		if did >= n {
+			break
+		}
+		if didSize == 4 {
+			binary.BigEndian.PutUint32(tmp[:], uint32(did))
+		} else {
+			binary.BigEndian.PutUint64(tmp[:], did)
+		}
+		buf = append(buf, tmp[:didSize]...)
+	}
+	removed := t.Len() - len(buf)/didSize
+	if removed == 0 {
+		return 0, nil
+	}
+	tmpPath := t.path + ".tmp"
+	f, err := os.Create(tmpPath)
+	if err != nil {
+		return 0, err
+	}
+	if _, err = f.Write(buf); err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	if err := os.Rename(tmpPath, t.path); err != nil {
+		return 0, err
+	}
+	return removed, nil
+}
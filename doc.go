@@ -25,8 +25,8 @@ import (
 
 type Doc struct {
 	Path  string
-	Start uint32
-	End   uint32
+	Start uint64
+	End   uint64
 	Dat   []byte `json:"-"`
 }
 
@@ -53,7 +53,7 @@ func (doc *Doc) Load() error {
 		if err != nil {
 			return fmt.Errorf("readall: %w", err)
 		}
-		doc.End = uint32(len(doc.Dat))
+		doc.End = uint64(len(doc.Dat))
 	} else {
 		_, err = f.Seek(int64(doc.Start), io.SeekStart)
 		if err != nil {
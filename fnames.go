@@ -23,6 +23,8 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+
+	"github.com/go-air/dupi/internal/format"
 )
 
 type fname struct {
@@ -104,7 +106,36 @@ func (s *fnames) addPath(path string) (uint32, error) {
 	return parent, nil
 }
 
-func readUvarint32(r *bufio.Reader) (uint32, error) {
+// find returns the node of path, if path was added.
+func (s *fnames) find(path string) (uint32, bool, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return 0, false, err
+	}
+	parts := strings.Split(abs, string(os.PathSeparator))
+	v := uint32(0)
+	for _, p := range parts {
+		if p == "" {
+			continue
+		}
+		child, ok := s.d[v].children[p]
+		if !ok {
+			return 0, false, nil
+		}
+		v = child
+	}
+	return v, true, nil
+}
+
+// subtree adds v and all its descendants to dst.
+func (s *fnames) subtree(dst map[uint32]bool, v uint32) {
+	dst[v] = true
+	for _, child := range s.d[v].children {
+		s.subtree(dst, child)
+	}
+}
+
+func readUvarint32(r io.ByteReader) (uint32, error) {
 	v64, err := binary.ReadUvarint(r)
 	if err != nil {
 		return 0, err
@@ -115,20 +146,38 @@ func readUvarint32(r *bufio.Reader) (uint32, error) {
 	return uint32(v64), nil
 }
 
-func readFnames(r io.Reader) (*fnames, error) {
-	return breadFnames(bufio.NewReader(r))
+// readFnames reads the file names at path from r, written
+// for an index with format version.  Since FormatVersion3,
+// file names have a header and a checksum.
+func readFnames(r io.Reader, path string, version int) (*fnames, error) {
+	if version < FormatVersion3 {
+		return breadFnames(bufio.NewReader(r), path)
+	}
+	fr, err := format.NewReader(r, path, format.FnamesMagic, version, version)
+	if err != nil {
+		return nil, err
+	}
+	s, err := breadFnames(fr, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := fr.Check(); err != nil {
+		return nil, err
+	}
+	return s, nil
 }
 
-func breadFnames(r *bufio.Reader) (*fnames, error) {
-	v, err := readUvarint32(r)
+func breadFnames(r fnamesReader, path string) (*fnames, error) {
+	n, err := readUvarint32(r)
 	if err != nil {
 		return nil, err
 	}
+	// n is not trusted to size s.d: it grows as names
+	// are read, and a bad n ends in a read error.
 	s := &fnames{}
-	s.d = make([]fname, v)
 	var buf []byte
-	for i := range s.d {
-		v, err = readUvarint32(r)
+	for i := uint32(0); i < n; i++ {
+		v, err := readUvarint32(r)
 		if err != nil {
 			return nil, err
 		}
@@ -139,13 +188,18 @@ func breadFnames(r *bufio.Reader) (*fnames, error) {
 		if err != nil {
 			return nil, err
 		}
-		fname := &s.d[i]
-		fname.name = string(buf[:v])
+		name := string(buf[:v])
 		v, err = readUvarint32(r)
 		if err != nil {
 			return nil, err
 		}
-		fname.parent = v
+		if v >= n {
+			return nil, format.Corrupt(path, "file name %d has parent %d of %d", i, v, n)
+		}
+		s.d = append(s.d, fname{name: name, parent: v})
+	}
+	for i := range s.d {
+		fname := &s.d[i]
 		pname := &s.d[fname.parent]
 		if pname.children == nil {
 			pname.children = make(map[string]uint32)
@@ -155,6 +209,11 @@ func breadFnames(r *bufio.Reader) (*fnames, error) {
 	return s, nil
 }
 
+type fnamesReader interface {
+	io.Reader
+	io.ByteReader
+}
+
 func writeUvarint32(w io.Writer, v uint32) error {
 	var buf [binary.MaxVarintLen64]byte
 	n := binary.PutUvarint(buf[:], uint64(v))
@@ -162,8 +221,14 @@ func writeUvarint32(w io.Writer, v uint32) error {
 	return err
 }
 
-func (s *fnames) write(w io.Writer) error {
-	err := writeUvarint32(w, uint32(len(s.d)))
+// write writes s to dst for an index with format version,
+// which is at least FormatVersion3.
+func (s *fnames) write(dst io.Writer, version int) error {
+	w, err := format.NewWriter(dst, format.FnamesMagic, version)
+	if err != nil {
+		return err
+	}
+	err = writeUvarint32(w, uint32(len(s.d)))
 	if err != nil {
 		return err
 	}
@@ -182,5 +247,5 @@ func (s *fnames) write(w io.Writer) error {
 			return err
 		}
 	}
-	return nil
+	return w.Close()
 }
@@ -48,11 +48,11 @@ func TestFnames(t *testing.T) {
 		t.Fatal(err)
 	}
 	io := bytes.NewBuffer(nil)
-	if err := s.write(io); err != nil {
+	if err := s.write(io, FormatVersion); err != nil {
 		t.Fatal(err)
 	}
 	io = bytes.NewBuffer(io.Bytes())
-	ss, err := readFnames(io)
+	ss, err := readFnames(io, "files.fnm", FormatVersion)
 	if err != nil {
 		t.Fatal(err)
 	}
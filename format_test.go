@@ -0,0 +1,293 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/go-air/dupi/internal/format"
+	"github.com/go-air/dupi/internal/shard"
+)
+
+// downgrade rewrites the index at root with an older
+// format version: FormatVersion1, as written before
+// format versions, FormatVersion2 or FormatVersion3.
+func downgrade(t *testing.T, root string, version int) {
+	t.Helper()
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := idx.dmd.NumDocs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dmd := make([]byte, 12*n)
+	for did := uint64(1); did < n; did++ {
+		fid, start, end, err := idx.dmd.Lookup(did)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rcd := dmd[12*did:]
+		binary.BigEndian.PutUint32(rcd[0:], fid)
+		binary.BigEndian.PutUint32(rcd[4:], uint32(start))
+		binary.BigEndian.PutUint32(rcd[8:], uint32(end))
+	}
+	for i := range idx.shards {
+		src := shard.Source{
+			Index: &idx.shards[i],
+			Map:   func(did uint64) (uint64, bool) { return did, true }}
+		if err := shard.Merge(idx.config.PostPath(i)+".old", version, []shard.Source{src}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg := *idx.config
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < cfg.NumShards; i++ {
+		for _, ext := range []string{"", ".iix"} {
+			path := cfg.PostPath(i)
+			if err := os.Rename(path+".old"+ext, path+ext); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	fns, err := ioutil.ReadFile(cfg.FnamesPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version < FormatVersion3 {
+		// older file names have no header or checksum.
+		fns = fns[format.HeaderSize : len(fns)-4]
+	} else {
+		s, err := readFnames(bytes.NewReader(fns), cfg.FnamesPath(), FormatVersion)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf bytes.Buffer
+		if err := s.write(&buf, version); err != nil {
+			t.Fatal(err)
+		}
+		fns = buf.Bytes()
+	}
+	if err := ioutil.WriteFile(cfg.FnamesPath(), fns, 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Version = version
+	if version == FormatVersion1 {
+		if err := ioutil.WriteFile(cfg.DmdPath(), dmd, 0644); err != nil {
+			t.Fatal(err)
+		}
+		cfg.Version = 0
+	}
+	if err := cfg.Write(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func indexBlots(t *testing.T, root string) []uint32 {
+	t.Helper()
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	return sortedBlots(queryBlots(t, idx.StartQuery(QueryMaxBlot)))
+}
+
+func TestOldFormats(t *testing.T) {
+	for _, version := range []int{FormatVersion1, FormatVersion2} {
+		testOldFormat(t, version)
+	}
+}
+
+func testOldFormat(t *testing.T, version int) {
+	root := testIndex(t, queryTestDocs)
+	want := indexBlots(t, root)
+	downgrade(t, root, version)
+	cfg, err := ReadConfigFromRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.FormatVersion() != version {
+		t.Errorf("downgraded index has version %d want %d", cfg.FormatVersion(), version)
+	}
+	if got := indexBlots(t, root); !reflect.DeepEqual(got, want) {
+		t.Errorf("version %d blots got %v want %v", version, got, want)
+	}
+	if _, err := OpenIndexer(root); !errors.Is(err, ErrOldFormat) {
+		t.Errorf("appending to version %d gave %v", version, err)
+	}
+	out := filepath.Join(filepath.Dir(root), "upgraded")
+	if err := MergeIndices(out, root); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = ReadConfigFromRoot(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.FormatVersion() != FormatVersion {
+		t.Errorf("merged index has version %d", cfg.FormatVersion())
+	}
+	if got := indexBlots(t, out); !reflect.DeepEqual(got, want) {
+		t.Errorf("upgraded blots got %v want %v", got, want)
+	}
+	idxr, err := OpenIndexer(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestFormatVersion3 checks that indices of FormatVersion3,
+// which have no check bytes, may still be added to.
+func TestFormatVersion3(t *testing.T) {
+	root := testIndex(t, queryTestDocs)
+	want := indexBlots(t, root)
+	downgrade(t, root, FormatVersion3)
+	idxr, err := OpenIndexer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := queryTestDocs[0]
+	if err := idxr.Add(&Doc{Path: "extra", Dat: []byte(d), End: uint64(len(d))}); err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := ReadConfigFromRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.FormatVersion() != FormatVersion3 {
+		t.Errorf("appended index has version %d", cfg.FormatVersion())
+	}
+	if got := indexBlots(t, root); len(got) < len(want) {
+		t.Errorf("appended index has blots %x, had %x", got, want)
+	}
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	ps, err := idx.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ps) != 0 {
+		t.Errorf("appended index has problems %+v", ps)
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	root := testIndex(t, queryTestDocs)
+	cfg, err := ReadConfigFromRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		path string
+		edit func(d []byte) []byte
+		want error
+	}{
+		{cfg.PostPath(0) + ".iix", func(d []byte) []byte { d[len(d)-1] ^= 1; return d }, ErrCorrupt},
+		{cfg.PostPath(1) + ".iix", func(d []byte) []byte { return d[:len(d)/2] }, ErrCorrupt},
+		{cfg.PostPath(1), func(d []byte) []byte { d[0] = 'x'; return d }, ErrIncompatibleFormat},
+		{cfg.PostPath(0), func(d []byte) []byte { return d[:4] }, ErrCorrupt},
+		{cfg.FnamesPath(), func(d []byte) []byte { d[len(d)/2] ^= 0x40; return d }, ErrCorrupt},
+		// a count of file names larger than the file.
+		{cfg.FnamesPath(), func(d []byte) []byte {
+			_, n := binary.Uvarint(d[format.HeaderSize:])
+			buf := make([]byte, binary.MaxVarintLen64)
+			m := binary.PutUvarint(buf, 1<<32-1)
+			return append(append(d[:format.HeaderSize:format.HeaderSize], buf[:m]...), d[format.HeaderSize+n:]...)
+		}, ErrCorrupt},
+		{cfg.DmdPath(), func(d []byte) []byte { d[11] = 9; return d }, ErrIncompatibleFormat},
+		{cfg.Path(), func(d []byte) []byte {
+			return []byte(strings.Replace(string(d), `"Version": 4`, `"Version": 5`, 1))
+		}, ErrIncompatibleFormat},
+	} {
+		orig, err := ioutil.ReadFile(c.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := c.edit(append([]byte(nil), orig...))
+		if err := ioutil.WriteFile(c.path, d, 0644); err != nil {
+			t.Fatal(err)
+		}
+		_, err = OpenIndex(root)
+		var ferr *FileError
+		if !errors.Is(err, c.want) || !errors.As(err, &ferr) || ferr.Path != c.path {
+			t.Errorf("%s: got %v, want %v", c.path, err, c.want)
+		}
+		if err := ioutil.WriteFile(c.path, orig, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// chunks of posts are checked when read.
+	for _, edit := range []func(d []byte) []byte{
+		func(d []byte) []byte { d[format.HeaderSize+1] ^= 1; return d },
+		// a length of the last chunk past the end of the
+		// file, as a one byte varint.
+		func(d []byte) []byte {
+			last := format.HeaderSize
+			for off := last; off < len(d); {
+				v, n := binary.Varint(d[off:])
+				last, off = off, off+n+int(v)
+			}
+			d[last] = 0x7e
+			return d
+		},
+	} {
+		orig, err := ioutil.ReadFile(cfg.PostPath(0))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := edit(append([]byte(nil), orig...))
+		if err := ioutil.WriteFile(cfg.PostPath(0), d, 0644); err != nil {
+			t.Fatal(err)
+		}
+		idx, err := OpenIndex(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		problems, err := idx.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(problems) != 1 || !errors.Is(problems[0].Err, ErrCorrupt) {
+			t.Errorf("corrupt chunk gave problems %v", problems)
+		}
+		if err := idx.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(cfg.PostPath(0), orig, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
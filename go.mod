@@ -4,5 +4,6 @@ go 1.17
 
 require (
 	github.com/google/gops v0.3.20
-	golang.org/x/sys v0.0.0-20210902050250-f475640dd07b
+	golang.org/x/sys v0.7.0
+	golang.org/x/text v0.13.0
 )
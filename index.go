@@ -20,65 +20,311 @@ import (
 	"log"
 	"math"
 	"os"
+	"sync"
 
 	"github.com/go-air/dupi/blotter"
 	"github.com/go-air/dupi/dmd"
 	"github.com/go-air/dupi/internal/shard"
 	"github.com/go-air/dupi/lock"
+	"github.com/go-air/dupi/minhash"
+	"github.com/go-air/dupi/simhash"
 	"github.com/go-air/dupi/token"
 )
 
+// An Index is an index opened for querying.  An Index is
+// safe for use by many goroutines at once: any number of
+// queries may run on it in parallel, each in its own
+// goroutine.  Close, Compact and RepairCounts must not be
+// called while queries are running.
 type Index struct {
 	config *Config
 	lock   *lock.File
 	dmd    *dmd.T
 	fnames *fnames
 	shards []shard.Index
+	taboo  *Taboo
+	// reduced blots of taboo
+	tabooBlots map[uint32]bool
+	tombs      *dmd.Tombs
+	// dmd records of deleted docs, guarded by delMu.
+	delMu   sync.RWMutex
+	deleted map[dmdKey]bool
+	// whether to memory map files
+	mmap bool
+	// MinHash signatures, if the index has them, and
+	// their LSH index.
+	sigs *minhash.File
+	near *nearIndex
+	// SimHash fingerprints, if the index has them, and
+	// their search tables.
+	fps     *simhash.File
+	similar *similarIndex
+	// docids in the order of QueryMaxDoc queries, once
+	// the first has computed them, guarded by ordMu.
+	ordMu   sync.Mutex
+	ord     []uint64
+	ordDone bool
 }
 
-func OpenIndex(root string) (*Index, error) {
+type dmdKey struct {
+	fid        uint32
+	start, end uint64
+}
+
+// An IndexOption is an option of OpenIndex.
+type IndexOption func(x *Index)
+
+// WithMmap makes OpenIndex read the posting files and
+// document records of the index through memory maps,
+// rather than with a system call or more per read.  Memory
+// maps are only used on Linux; elsewhere WithMmap has no
+// effect.
+func WithMmap() IndexOption {
+	return func(x *Index) {
+		x.mmap = true
+	}
+}
+
+func OpenIndex(root string, opts ...IndexOption) (*Index, error) {
 	var err error
-	cfg := &Config{IndexRoot: root}
-	res := &Index{config: cfg}
-	res.lock, err = lock.New(cfg.LockPath())
+	res := &Index{config: &Config{IndexRoot: root}}
+	for _, opt := range opts {
+		opt(res)
+	}
+	res.lock, err = lockRoot(root)
 	if err != nil {
 		return nil, err
 	}
-	if err := res.lock.LockShared(); err != nil {
+	res.config, err = ReadConfigFromRoot(root)
+	if err != nil {
+		res.lock.Close()
 		return nil, err
 	}
-	cfg, err = ReadConfigFromRoot(root)
-	if err != nil {
+	if err = res.open(); err != nil {
+		res.lock.Close()
 		return nil, err
 	}
-	res.config = cfg
-	res.dmd, err = dmd.New(cfg.IndexRoot)
+	return res, nil
+}
+
+// lockRoot locks the index at root shared, after
+// completing or discarding an interrupted compaction and
+// rolling back an indexer which stopped without closing
+// the index.  The lock is held throughout, and is only
+// upgraded to exclusive when there is something to
+// recover and no one else holds it; otherwise the index is
+// left as is.
+func lockRoot(root string) (*lock.File, error) {
+	lk, err := lock.New((&Config{IndexRoot: root}).LockPath())
 	if err != nil {
 		return nil, err
 	}
+	if err := lk.LockShared(); err != nil {
+		lk.Close()
+		return nil, err
+	}
+	if err := recoverLocked(lk, root); err != nil {
+		lk.Close()
+		return nil, err
+	}
+	return lk, nil
+}
+
+// recoverLocked does the recovery of lockRoot with lk held
+// shared.
+func recoverLocked(lk *lock.File, root string) (err error) {
+	need, err := compacted(root)
+	if err != nil {
+		return err
+	}
+	if !need {
+		cfg, err := ReadConfigFromRoot(root)
+		if err != nil {
+			// left to opening the index to report.
+			return nil
+		}
+		if need, err = needsRecovery(cfg); err != nil || !need {
+			return err
+		}
+	}
+	ok, err := lk.TryUpgrade()
+	if err != nil || !ok {
+		// the index is in use, perhaps by its indexer.
+		return err
+	}
+	defer func() {
+		if lerr := lk.Downgrade(); err == nil {
+			err = lerr
+		}
+	}()
+	if err := recoverRoot(root); err != nil {
+		return err
+	}
+	cfg, err := ReadConfigFromRoot(root)
+	if err != nil {
+		return err
+	}
+	_, err = rollBack(cfg)
+	return err
+}
+
+// open opens the files of x under x.config.IndexRoot.
+// If open fails, the files it opened are closed.
+func (x *Index) open() (err error) {
+	defer func() {
+		if err != nil {
+			x.closeFiles()
+		}
+	}()
+	cfg := x.config
+	x.dmd, err = dmd.New(cfg.IndexRoot)
+	if err != nil {
+		return err
+	}
+	if x.mmap {
+		if err := x.dmd.Mmap(); err != nil {
+			return err
+		}
+	}
 	fnf, err := os.Open(cfg.FnamesPath())
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer fnf.Close()
-	res.fnames, err = readFnames(fnf)
+	x.fnames, err = readFnames(fnf, cfg.FnamesPath(), cfg.FormatVersion())
 	if err != nil {
-		return nil, err
+		return err
+	}
+	x.tombs, err = dmd.OpenTombs(cfg.IndexRoot, cfg.didSize())
+	if err != nil {
+		return err
+	}
+	x.deleted = make(map[dmdKey]bool, x.tombs.Len())
+	if err := x.addDeleted(x.tombs.Dids()); err != nil {
+		return err
 	}
-	res.shards = make([]shard.Index, cfg.NumShards)
-	for i := range res.shards {
-		shard := &res.shards[i]
-		if err := shard.Init(cfg.PostPath(i)); err != nil {
-			return nil, fmt.Errorf("error initializing shard %d: %w", i, err)
+	x.shards = make([]shard.Index, cfg.NumShards)
+	for i := range x.shards {
+		shard := &x.shards[i]
+		if err := shard.Init(cfg.PostPath(i), cfg.FormatVersion(), cfg.blotBits(), cfg.checkBytes()); err != nil {
+			x.shards = x.shards[:i]
+			return fmt.Errorf("error initializing shard %d: %w", i, err)
+		}
+		if x.mmap {
+			if err := shard.Mmap(); err != nil {
+				return err
+			}
 		}
 	}
-	return res, nil
+	if cfg.MinHash != 0 {
+		x.sigs, err = minhash.Open(cfg.MinHashPath(), cfg.MinHash)
+		if err != nil {
+			return err
+		}
+	}
+	x.near = &nearIndex{}
+	if cfg.SimHash {
+		x.fps, err = simhash.Open(cfg.SimHashPath())
+		if err != nil {
+			return err
+		}
+	}
+	x.similar = &similarIndex{}
+	x.ordMu.Lock()
+	x.ord, x.ordDone = nil, false
+	x.ordMu.Unlock()
+	x.taboo, err = readTaboo(cfg)
+	if err != nil {
+		return err
+	}
+	tbs, err := x.taboo.blots(cfg)
+	if err != nil {
+		return err
+	}
+	x.tabooBlots = make(map[uint32]bool, len(tbs))
+	for b := range tbs {
+		x.tabooBlots[x.ReduceBlot(uint32(b))] = true
+	}
+	return nil
 }
 
+// Close closes the files of x and unlocks it.  Close may
+// be called again, and after Compact or RepairCounts
+// failed to reopen x, which closes it.
 func (x *Index) Close() error {
+	err := x.closeFiles()
+	if x.lock != nil {
+		if lerr := x.lock.Close(); err == nil {
+			err = lerr
+		}
+		x.lock = nil
+	}
+	return err
+}
+
+// upgrade locks x exclusively, for rewriting its files,
+// and reopens them: the lock is released while it is
+// upgraded, so others may have added to or compacted the
+// index since x opened it.  Unless upgrade fails, the
+// caller must defer the returned downgrade with its named
+// error, to lock x shared again if it is still open.
+func (x *Index) upgrade() (downgrade func(err *error), err error) {
+	if err := x.lock.Upgrade(); err != nil {
+		return nil, err
+	}
+	downgrade = func(err *error) {
+		if x.lock == nil {
+			// closed by reopen.
+			return
+		}
+		if lerr := x.lock.Downgrade(); *err == nil {
+			*err = lerr
+		}
+	}
+	if err := x.reopen(nil); err != nil {
+		return nil, err
+	}
+	return downgrade, nil
+}
+
+// reopen closes the files of x, calls rewrite, if it is
+// not nil and they closed, and opens them again.  If they
+// cannot be opened, x is closed, as by Close, and must not
+// be used other than to call Close again.
+func (x *Index) reopen(rewrite func() error) error {
+	err := x.closeFiles()
+	if err == nil && rewrite != nil {
+		err = rewrite()
+	}
+	if oerr := x.open(); oerr != nil {
+		x.Close()
+		oerr = fmt.Errorf("index %s could not be reopened and is closed: %w", x.config.IndexRoot, oerr)
+		if err == nil {
+			err = oerr
+		} else {
+			log.Printf("dupi: %v", oerr)
+		}
+	}
+	return err
+}
+
+// closeFiles closes the files opened by x.open, which
+// may have failed part way.
+func (x *Index) closeFiles() error {
 	var err error
-	defer func() { err = x.lock.Close() }()
-	err = x.dmd.Close()
+	if x.dmd != nil {
+		err = x.dmd.Close()
+	}
+	if x.sigs != nil {
+		if serr := x.sigs.Close(); err == nil {
+			err = serr
+		}
+	}
+	if x.fps != nil {
+		if ferr := x.fps.Close(); err == nil {
+			err = ferr
+		}
+	}
 	for i := range x.shards {
 		s := &x.shards[i]
 		serr := s.Close()
@@ -90,6 +336,7 @@ func (x *Index) Close() error {
 			}
 		}
 	}
+	x.dmd, x.sigs, x.fps, x.shards = nil, nil, nil, nil
 	return err
 }
 
@@ -97,16 +344,32 @@ func (x *Index) Root() string {
 	return x.config.IndexRoot
 }
 
+// Taboo returns the taboo list of x as of
+// when x was opened.
+func (x *Index) Taboo() *Taboo {
+	return x.taboo
+}
+
+// IsTaboo returns whether blot is a blot of
+// text in the taboo list of x.
+func (x *Index) IsTaboo(blot uint32) bool {
+	return x.tabooBlots[blot]
+}
+
 func (x *Index) Stats() (*Stats, error) {
 	var err error
 	st := &Stats{}
 	st.Root = x.config.IndexRoot
-	st.NumBlots = 1 << 16 * uint64(len(x.shards))
+	if err := x.updateDeleted(); err != nil {
+		return nil, err
+	}
+	st.NumBlots = x.NumBlots()
 	st.NumDocs, err = x.dmd.NumDocs()
 	if err != nil {
 		return nil, err
 	}
 	st.NumPaths = uint64(len(x.fnames.d))
+	st.NumDeleted = uint64(x.tombs.Len())
 
 	for i := range x.shards {
 		shrd := &x.shards[i]
@@ -151,10 +414,19 @@ func (x *Index) SeqLen() int {
 	return x.config.SeqLen
 }
 
+// BlotDoc appends to dst the lower 32 bits of the blots
+// the indexer posts for doc, before reduction to the blot
+// space of x.
 func (x *Index) BlotDoc(dst []uint32, doc *Doc) []uint32 {
-	tokfn := x.TokenFunc()
-	blotter := x.Blotter()
-	toks := tokfn(nil, doc.Dat, doc.Start)
+	x.docBlots(x.docWords(doc), false, func(sb selBlot) {
+		dst = append(dst, uint32(sb.blot))
+	})
+	return dst
+}
+
+// docWords returns the word tokens of doc.
+func (x *Index) docWords(doc *Doc) []token.T {
+	toks := x.TokenFunc()(nil, doc.Dat, doc.Start)
 	j := 0
 	for _, tok := range toks {
 		if tok.Tag != token.Word {
@@ -163,131 +435,333 @@ func (x *Index) BlotDoc(dst []uint32, doc *Doc) []uint32 {
 		toks[j] = tok
 		j++
 	}
-	seqLen := x.SeqLen()
-	for i, tok := range toks[:j] {
-		blot := blotter.Blot(tok.Lit)
-		if i < seqLen {
-			continue
+	return toks[:j]
+}
+
+// docBlots blots the words of a document, calling f with
+// every blot the indexer posts for the document, as
+// shatter.do.  If all is set, f is called for all the blots
+// winnowing would choose from.
+func (x *Index) docBlots(words []token.T, all bool, f func(sb selBlot)) {
+	sel := newBlotSel(x.Blotter(), x.SeqLen(), x.config.BlotConfig.Winnow)
+	if all {
+		sel = newBlotSelAll(x.Blotter(), x.SeqLen(), x.config.BlotConfig.Winnow)
+	}
+	for i := range words {
+		for _, sb := range sel.add(words[i].Key()) {
+			f(sb)
 		}
-		dst = append(dst, blot)
 	}
-	return dst
+	for _, sb := range sel.end() {
+		f(sb)
+	}
+}
+
+// NumBlots returns the number of blots of x, over all
+// shards.
+func (x *Index) NumBlots() uint64 {
+	return x.config.NumBlots()
 }
 
-func (x *Index) SplitBlot(b uint32) (shard uint32, sblot uint16) {
+// ReduceBlot reduces the blot b to the blot space of x.
+func (x *Index) ReduceBlot(b uint32) uint32 {
+	return uint32(uint64(b) % x.NumBlots())
+}
+
+func (x *Index) SplitBlot(b uint32) (shard uint32, sblot uint32) {
 	nsh := uint32(x.NumShards())
-	b = b % (nsh * (1 << 16))
+	b = x.ReduceBlot(b)
 	shard = b % nsh
-	sblot = uint16(b / nsh)
+	sblot = b / nsh
 	return
 }
 
-func (x *Index) JoinBlot(shard uint32, sblot uint16) uint32 {
+func (x *Index) JoinBlot(shard uint32, sblot uint32) uint32 {
 	nsh := uint32(x.NumShards())
 	blot := nsh * uint32(sblot)
 	blot += shard
 	return blot
 }
 
-func (x *Index) FindBlots(m map[uint32][]byte, doc *Doc) (map[uint32][]byte, error) {
+// window is the blot of a window of text in a
+// document, together with the text's position and the
+// index of its last word.  If skipEnd is not 0, the text
+// from skipStart to skipEnd, a word left out by a
+// skip-gram blotter and the space before it, is not part
+// of the window.  check holds the upper bits of blots
+// wider than 32 bits.
+type window struct {
+	blot               uint32
+	check              uint32
+	word               int
+	start, end         uint64
+	skipStart, skipEnd uint64
+}
+
+// docWindows re-shatters doc, appending to dst a window
+// for every blot the indexer would post for doc.  Blots
+// are reduced to the blot space of the index.
+func (x *Index) docWindows(dst []window, doc *Doc) ([]window, error) {
+	return x.windows(dst, doc, false)
+}
+
+// allWindows is like docWindows, but for indices which
+// winnow appends the windows of all the blots winnowing
+// chooses from.
+func (x *Index) allWindows(dst []window, doc *Doc) ([]window, error) {
+	return x.windows(dst, doc, true)
+}
+
+func (x *Index) windows(dst []window, doc *Doc, all bool) ([]window, error) {
 	if doc.Dat == nil {
 		err := doc.Load()
 		if err != nil {
-			return nil, err
-		}
-	}
-	toks := x.TokenFunc()(nil, doc.Dat, doc.Start)
-	j := 0
-	for _, tok := range toks {
-		if tok.Tag != token.Word {
-			continue
+			return dst, err
 		}
-		toks[j] = tok
-		j++
 	}
-	blotter := x.Blotter()
+	toks := x.docWords(doc)
 	seqLen := x.SeqLen()
-	nShard := uint32(x.NumShards())
-	res := make(map[uint32][]byte)
-	for i, tok := range toks[:j] {
-		blot := blotter.Blot(tok.Lit)
-		if i < seqLen {
-			continue
-		}
-		blot %= nShard * (1 << 16)
-		if m[blot] == nil {
-			continue
+	mask := checkMask(&x.config.BlotConfig)
+	x.docBlots(toks, all, func(sb selBlot) {
+		// the text of a window starts one word before
+		// the words blotted.  With winnowing, which
+		// also posts the blot of the first seqLen words,
+		// it is just the words blotted, so that texts
+		// of seqLen+w-1 words are found in any context.
+		// The windows of skip-gram blotters have seqLen+1
+		// words, and their text is the words blotted.
+		i := sb.word
+		start := i - seqLen
+		if x.config.BlotConfig.Winnow > 1 && sb.skip == 0 || sb.skip == seqLen {
+			start++
 		}
-		start := toks[i-seqLen].Pos
-		end := tok.Pos + uint32(len(tok.Lit))
-		txt := doc.Dat[start:end]
-		if bytes.Equal(m[blot], txt) {
-			res[blot] = txt
-		} else {
-			//fmt.Printf("not equal: %s %s\n", string(m[blot]), string(txt))
+		tok := &toks[i]
+		w := window{
+			blot:  x.ReduceBlot(uint32(sb.blot)),
+			check: uint32(sb.blot>>32) & mask,
+			word:  i,
+			start: toks[start].Pos,
+			end:   tok.Pos + uint64(len(tok.Lit))}
+		if sb.skip != 0 && sb.skip != seqLen {
+			prev, skipped := &toks[i-sb.skip-1], &toks[i-sb.skip]
+			w.skipStart = prev.Pos + uint64(len(prev.Lit))
+			w.skipEnd = skipped.Pos + uint64(len(skipped.Lit))
 		}
+		dst = append(dst, w)
+	})
+	return dst, nil
+}
+
+// BlotPos is a blot posted for a document together with
+// the position of its text.  If SkipEnd is not 0, the text
+// from SkipStart to SkipEnd was left out of the blot by a
+// skip-gram blotter.  For blots wider than 32 bits, Check
+// holds the upper bits, with which Query.GetChecked
+// discards documents whose blots only collide in the
+// lower ones.
+type BlotPos struct {
+	Blot               uint32
+	Check              uint32 `json:",omitempty"`
+	Start, End         uint64
+	SkipStart, SkipEnd uint64 `json:",omitempty"`
+}
+
+// BlotPositions returns the blots the indexer posts for
+// doc, reduced to the blot space of x, with the positions
+// of their text.
+func (x *Index) BlotPositions(doc *Doc) ([]BlotPos, error) {
+	wins, err := x.docWindows(nil, doc)
+	if err != nil {
+		return nil, err
+	}
+	res := make([]BlotPos, len(wins))
+	for i := range wins {
+		w := &wins[i]
+		res[i] = BlotPos{Blot: w.blot, Check: w.check, Start: w.start, End: w.end,
+			SkipStart: w.skipStart, SkipEnd: w.skipEnd}
 	}
 	return res, nil
 }
 
-func (x *Index) FindBlot(theBlot uint32, doc *Doc) (start, end uint32, err error) {
-	if doc.Dat == nil {
-		err = doc.Load()
-		if err != nil {
-			return
+// Text returns the text of the blot of p in doc, which
+// must be loaded, without any text left out of the blot.
+func (p *BlotPos) Text(doc *Doc) []byte {
+	return doc.text(&window{start: p.Start, end: p.End, skipStart: p.SkipStart, skipEnd: p.SkipEnd})
+}
+
+// text returns the text of w in doc, which must
+// be loaded.
+func (doc *Doc) text(w *window) []byte {
+	if w.skipEnd == 0 {
+		return doc.Dat[w.start-doc.Start : w.end-doc.Start]
+	}
+	res := make([]byte, 0, w.end-w.start-(w.skipEnd-w.skipStart))
+	res = append(res, doc.Dat[w.start-doc.Start:w.skipStart-doc.Start]...)
+	return append(res, doc.Dat[w.skipEnd-doc.Start:w.end-doc.Start]...)
+}
+
+// sameText returns whether the texts a and b of windows
+// are the same, up to the normalization of words by the
+// tokenizer of x.
+func (x *Index) sameText(a, b []byte) bool {
+	if bytes.Equal(a, b) {
+		return true
+	}
+	tf := x.TokenFunc()
+	ta, tb := tf(nil, a, 0), tf(nil, b, 0)
+	normed := false
+	i, j := 0, 0
+	for {
+		for i < len(ta) && ta[i].Tag != token.Word {
+			i++
 		}
+		for j < len(tb) && tb[j].Tag != token.Word {
+			j++
+		}
+		if i == len(ta) || j == len(tb) {
+			return normed && i == len(ta) && j == len(tb)
+		}
+		if !bytes.Equal(ta[i].Key(), tb[j].Key()) {
+			return false
+		}
+		normed = normed || ta[i].Norm != nil || tb[j].Norm != nil
+		i++
+		j++
 	}
-	toks := x.TokenFunc()(nil, doc.Dat, doc.Start)
-	j := 0
-	for _, tok := range toks {
-		if tok.Tag != token.Word {
+}
+
+// updateDeleted reads the documents deleted since x was
+// opened or last updated, so that deletions by an
+// Indexer take effect on an open Index.
+func (x *Index) updateDeleted() error {
+	dids, err := x.tombs.Update()
+	if err != nil || len(dids) == 0 {
+		return err
+	}
+	return x.addDeleted(dids)
+}
+
+// addDeleted adds the dmd records of dids to x.deleted.
+func (x *Index) addDeleted(dids []uint64) error {
+	n, err := x.dmd.NumDocs()
+	if err != nil {
+		return err
+	}
+	x.delMu.Lock()
+	defer x.delMu.Unlock()
+	for _, did := range dids {
+		if did >= n {
+			// added after x was opened, so unknown
+			// to it.
 			continue
 		}
-		toks[j] = tok
-		j++
+		fid, start, end, err := x.dmd.Lookup(did)
+		if err != nil {
+			return fmt.Errorf("deleted doc %d: %w", did, err)
+		}
+		x.deleted[dmdKey{fid, start, end}] = true
 	}
-	blotter := x.Blotter()
-	seqLen := x.SeqLen()
-	nShard := uint32(x.NumShards())
-	for i, tok := range toks[:j] {
-		blot := blotter.Blot(tok.Lit)
-		if i < seqLen {
+	return nil
+}
+
+// isDeleted returns whether doc was deleted.
+func (x *Index) isDeleted(doc *Doc) bool {
+	x.delMu.RLock()
+	defer x.delMu.RUnlock()
+	if len(x.deleted) == 0 {
+		return false
+	}
+	fid, ok, err := x.fnames.find(doc.Path)
+	if err != nil || !ok {
+		return false
+	}
+	return x.deleted[dmdKey{fid, doc.Start, doc.End}]
+}
+
+func (x *Index) FindBlots(m map[uint32][]byte, doc *Doc) (map[uint32][]byte, error) {
+	if err := x.updateDeleted(); err != nil {
+		return nil, err
+	}
+	if x.isDeleted(doc) {
+		return map[uint32][]byte{}, nil
+	}
+	wins, err := x.docWindows(nil, doc)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[uint32][]byte)
+	for i := range wins {
+		w := &wins[i]
+		if m[w.blot] == nil {
 			continue
 		}
-		blot %= nShard * (1 << 16)
-		if blot != theBlot {
+		txt := doc.text(w)
+		if x.sameText(m[w.blot], txt) {
+			res[w.blot] = txt
+		}
+	}
+	return res, nil
+}
+
+func (x *Index) FindBlot(theBlot uint32, doc *Doc) (start, end uint64, err error) {
+	var wins []window
+	wins, err = x.docWindows(nil, doc)
+	if err != nil {
+		return
+	}
+	theBlot = x.ReduceBlot(theBlot)
+	for i := range wins {
+		w := &wins[i]
+		if w.blot != theBlot {
 			continue
 		}
-		start = toks[i-seqLen].Pos
-		end = tok.Pos + uint32(len(tok.Lit))
+		start, end = w.start, w.end
 		return
 	}
 	err = fmt.Errorf("blot %x not found", theBlot)
 	return
 }
 
+// StartQuery starts a query which visits blots
+// according to the strategy s.  QueryRandom
+// queries started with StartQuery use seed 0.
 func (x *Index) StartQuery(s QueryStrategy) *Query {
+	return x.StartQuerySeed(s, 0)
+}
+
+// StartQuerySeed is like StartQuery, but uses seed
+// to determine the order of QueryRandom queries.
+func (x *Index) StartQuerySeed(s QueryStrategy, seed int64) *Query {
 	q := &Query{
 		index:    x,
-		strategy: QueryMaxBlot,
-		state:    x.qstate(s)}
+		strategy: s,
+		err:      x.updateDeleted(),
+		state:    x.qstate(s, seed)}
 	return q
 }
 
-func (x *Index) qstate(s QueryStrategy) *qstate {
+func (x *Index) qstate(s QueryStrategy, seed int64) *qstate {
 	qstate := &qstate{}
 	qstate.i = uint32(0)
 	qstate.n = uint32(len(x.shards))
-	qstate.shardStates = make([]*shard.ReadState, qstate.n)
-	for i := range x.shards {
-		shard := &x.shards[i]
-		qstate.shardStates[i] = shard.ReadStateAt(0)
+	switch s {
+	case QueryMaxDoc:
+		// docs are computed on the first call to Next.
+		qstate.seen = make([]uint64, (x.NumBlots()+63)/64)
+	case QueryRandom:
+		qstate.perm = newBlotPerm(x.NumBlots(), seed)
+	default:
+		qstate.shardStates = make([]*shard.ReadState, qstate.n)
+		for i := range x.shards {
+			shard := &x.shards[i]
+			qstate.shardStates[i] = shard.ReadStateAt(0)
+		}
+		qstate.setMax()
 	}
-	qstate.setMax()
 	return qstate
 }
 
-func (x *Index) docid2Doc(did uint32, doc *Doc) error {
+func (x *Index) docid2Doc(did uint64, doc *Doc) error {
 	fid, start, end, err := x.dmd.Lookup(did)
 	if err != nil {
 		return fmt.Errorf("docid2Doc(%d) dmd gave %w", did, err)
@@ -15,6 +15,7 @@
 package dupi
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
@@ -41,7 +42,7 @@ func TestIndexDocid2Doc(t *testing.T) {
 	doc := &Doc{
 		Path:  "/test/msg",
 		Start: 0,
-		End:   uint32(len(msg)),
+		End:   uint64(len(msg)),
 		Dat:   []byte(msg)}
 	if err := idxr.Add(doc); err != nil {
 		t.Fatal(err)
@@ -49,7 +50,7 @@ func TestIndexDocid2Doc(t *testing.T) {
 	doc = &Doc{
 		Path:  "/test/msg2",
 		Start: 0,
-		End:   uint32(len(msg2)),
+		End:   uint64(len(msg2)),
 		Dat:   []byte(msg2)}
 	if err := idxr.Add(doc); err != nil {
 		t.Fatal(err)
@@ -74,7 +75,166 @@ func TestIndexDocid2Doc(t *testing.T) {
 	if rdoc.Start != doc.Start {
 		t.Errorf("start got %d want %d", rdoc.Start, doc.Start)
 	}
-	if rdoc.End != uint32(len(msg)) {
+	if rdoc.End != uint64(len(msg)) {
 		t.Errorf("end got %d want %d", rdoc.End, len(msg))
 	}
 }
+
+func TestIndexNormalized(t *testing.T) {
+	docs := []string{
+		"Le café de la crème brûlée est très bon et pas cher du tout ici.",
+		"Le cafe de la creme brulee est tres bon et pas cher du tout ici."}
+	tmp, err := ioutil.TempDir(".", "dupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cfg, err := NewConfig(filepath.Join(tmp, "dupi"), 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.TokenConfig.NFKC = true
+	cfg.TokenConfig.StripMarks = true
+	idxr, err := IndexerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range docs {
+		path := filepath.Join(tmp, fmt.Sprintf("doc%d", i))
+		if err := ioutil.WriteFile(path, []byte(d), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := idxr.Add(&Doc{Path: path, End: uint64(len(d)), Dat: []byte(d)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := OpenIndex(cfg.IndexRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	shape := make([]Blot, 1)
+	if _, err := idx.StartQuery(QueryMaxBlot).Next(shape); err != nil {
+		t.Fatal(err)
+	}
+	blot := &shape[0]
+	if len(blot.Docs) != 2 {
+		t.Fatalf("normalized docs don't share blots: %v", blot.Docs)
+	}
+	a, b := &blot.Docs[0], &blot.Docs[1]
+	start, end, err := idx.FindBlot(blot.Blot, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := map[uint32][]byte{blot.Blot: a.Dat[start-a.Start : end-a.Start]}
+	found, err := idx.FindBlots(m, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found[blot.Blot] == nil {
+		t.Errorf("text of blot %x in %s not matched in %s", blot.Blot, a.Path, b.Path)
+	}
+}
+
+func TestIndexBlotBits(t *testing.T) {
+	for _, bits := range []int{MinBlotBits, 18} {
+		root := testIndexConfig(t, queryTestDocs, func(cfg *Config) {
+			cfg.BlotBits = bits
+		})
+		idx, err := OpenIndex(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		st, err := idx.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.NumBlots != 2<<uint(bits) {
+			t.Errorf("%d bits: got %d blots", bits, st.NumBlots)
+		}
+		want := sortedBlots(queryBlots(t, idx.StartQuery(QueryMaxBlot)))
+		if len(want) == 0 {
+			t.Errorf("%d bits: no duplicated blots", bits)
+		}
+		for _, s := range []QueryStrategy{QueryMaxDoc, QueryRandom} {
+			got := sortedBlots(queryBlots(t, idx.StartQuery(s)))
+			if fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("%d bits %s: got blots %x want %x", bits, s, got, want)
+			}
+		}
+		for _, b := range want {
+			if uint64(b) >= st.NumBlots {
+				t.Errorf("%d bits: blot %x out of range", bits, b)
+			}
+			blot := &Blot{Blot: b + uint32(st.NumBlots)}
+			if err := idx.StartQuery(QueryMaxBlot).Get(blot); err != nil {
+				t.Fatal(err)
+			}
+			if len(blot.Docs) < 2 {
+				t.Errorf("%d bits: unreduced blot %x has docs %v", bits, blot.Blot, blot.Docs)
+			}
+		}
+		idx.Close()
+	}
+	cfg, err := NewConfig("dupi", 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.BlotBits = MaxBlotBits + 1
+	if err := cfg.check(); err == nil {
+		t.Errorf("no error for %d blot bits", cfg.BlotBits)
+	}
+}
+
+// queryDocs returns the blots and documents found by a
+// query of idx.
+func queryDocs(t *testing.T, idx *Index) string {
+	t.Helper()
+	res, err := extractDocs(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestIndexMmap(t *testing.T) {
+	root := testIndex(t, queryTestDocs)
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := queryDocs(t, idx)
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	idx, err = OpenIndex(root, WithMmap())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if got := queryDocs(t, idx); got != want {
+		t.Errorf("mapped index got\n%s\nwant\n%s", got, want)
+	}
+	// posts and documents appended after mapping are read
+	// from the files.  The documents are added again, as
+	// file names are read when the index is opened.
+	idxr, err := OpenIndexer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range queryTestDocs {
+		path := filepath.Join(filepath.Dir(root), fmt.Sprintf("doc%d", i))
+		if err := idxr.Add(&Doc{Path: path, End: uint64(len(d)), Dat: []byte(d)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got := queryDocs(t, idx); got == want {
+		t.Errorf("mapped index did not see appended documents")
+	}
+}
@@ -15,13 +15,18 @@
 package dupi
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 
 	"github.com/go-air/dupi/dmd"
 	"github.com/go-air/dupi/internal/shard"
 	"github.com/go-air/dupi/lock"
+	"github.com/go-air/dupi/minhash"
 	"github.com/go-air/dupi/post"
+	"github.com/go-air/dupi/simhash"
 	"github.com/go-air/dupi/token"
 )
 
@@ -32,6 +37,17 @@ type Indexer struct {
 
 	didoff uint32
 	dmds   *dmd.Adder
+	tombs  *dmd.Tombs
+	// MinHash signatures, if the index keeps them.
+	sigs *minhash.Adder
+	// SimHash fingerprints, if the index keeps them.
+	fps *simhash.Adder
+
+	journal *journal
+	// sequence number of the last checkpoint
+	seq uint64
+	// tracks the documents posted to shards
+	mono *mono
 
 	// shatter *shatter
 	shatter chan *shatterReq
@@ -41,6 +57,9 @@ type Indexer struct {
 
 func IndexerFromConfig(cfg *Config) (*Indexer, error) {
 	var err error
+	if err = cfg.check(); err != nil {
+		return nil, err
+	}
 	res := &Indexer{config: cfg}
 	res.lock, err = lock.New(cfg.LockPath())
 	if err != nil {
@@ -55,6 +74,9 @@ func IndexerFromConfig(cfg *Config) (*Indexer, error) {
 	if err := os.Mkdir(res.Root(), 0755); err != nil {
 		return nil, err
 	}
+	if err := cfg.Write(); err != nil {
+		return nil, err
+	}
 	tokfn, err := token.FromConfig(&cfg.TokenConfig)
 	if err != nil {
 		return nil, err
@@ -63,32 +85,50 @@ func IndexerFromConfig(cfg *Config) (*Indexer, error) {
 	if err != nil {
 		return nil, err
 	}
+	res.tombs, err = dmd.OpenTombs(cfg.IndexRoot, cfg.didSize())
+	if err != nil {
+		return nil, err
+	}
+	if err := res.openSigs(); err != nil {
+		return nil, err
+	}
 	postChans := make([]chan []post.T, len(res.shards))
 	for i := range res.shards {
 		shard := &res.shards[i]
 		broot := cfg.PostPath(i)
-		if err := shard.InitCreate(uint32(i), broot, uint32(cfg.DocFlushRate)); err != nil {
+		if err := shard.InitCreate(uint32(i), broot, uint32(cfg.DocFlushRate), cfg.FormatVersion(), cfg.blotBits(), cfg.checkBytes()); err != nil {
 			return nil, err
 		}
 		postChans[i] = shard.PostChan()
 		go shard.Serve()
 	}
-	res.shatter, err = startShatter(cfg.NumShatters,
-		len(res.shards), cfg.SeqLen, res.dmds.Last(), tokfn,
-		&cfg.BlotConfig, postChans)
+	taboo, err := res.tabooBlots()
 	if err != nil {
 		return nil, err
 	}
-
+	res.shatter, res.mono, err = startShatter(cfg.NumShatters,
+		len(res.shards), cfg.NumBlots(), cfg.SeqLen, res.dmds.Last(), tokfn,
+		&cfg.BlotConfig, taboo, res.sigs, res.fps, postChans)
+	if err != nil {
+		return nil, err
+	}
+	if err := res.checkpoint(ckptKind); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
 func OpenIndexer(root string) (*Indexer, error) {
+	lk, err := lockRoot(root)
+	if err != nil {
+		return nil, err
+	}
 	cfg, err := ReadConfigFromRoot(root)
 	if err != nil {
+		lk.Close()
 		return nil, err
 	}
-	idx, err := openFromConfig(cfg)
+	idx, err := openFromConfig(cfg, lk)
 	if err != nil {
 		return nil, err
 	}
@@ -112,25 +152,46 @@ func CreateIndexer(root string, nbuckets, seqLen int) (*Indexer, error) {
 
 // opens an index from a config.  cfg
 // actually is read from the index as a first
-// step, and this completes opening.
-func openFromConfig(cfg *Config) (*Indexer, error) {
+// step, and this completes opening with lk,
+// the lock of the index held shared.
+func openFromConfig(cfg *Config, lk *lock.File) (*Indexer, error) {
 	var err error
-	res := &Indexer{config: cfg}
-	// lock file
-	res.lock, err = lock.New(cfg.LockPath())
-	if err != nil {
-		return nil, err
+	if cfg.FormatVersion() < FormatVersion3 {
+		lk.Close()
+		return nil, fmt.Errorf("index %s has format version %d and can only be opened read only, "+
+			"merge it into a new index to add to it: %w", cfg.IndexRoot, cfg.FormatVersion(), ErrOldFormat)
 	}
-	err = res.lock.LockShared()
+	res := &Indexer{config: cfg, lock: lk}
+	ck, err := readJournal(cfg.JournalPath())
 	if err != nil {
+		res.lock.Close()
 		return nil, err
 	}
+	if ck != nil && ck.kind != closeKind {
+		res.lock.Close()
+		return nil, fmt.Errorf("index %s was not closed and is in use: %w", cfg.IndexRoot, ErrNeedsRecovery)
+	}
+	if ck != nil {
+		res.seq = ck.seq
+	}
 	// internal setup
 	res.shards = make([]shard.Indexer, cfg.NumShards)
 	res.dmds, err = dmd.NewAdder(cfg.IndexRoot, cfg.DocFlushRate)
+	if errors.Is(err, dmd.ErrOldVersion) {
+		res.lock.Close()
+		return nil, fmt.Errorf("index %s has an old document format and can only be opened read only, "+
+			"merge it into a new index to add to it: %w (%v)", cfg.IndexRoot, ErrOldFormat, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	res.tombs, err = dmd.OpenTombs(cfg.IndexRoot, cfg.didSize())
 	if err != nil {
 		return nil, err
 	}
+	if err := res.openSigs(); err != nil {
+		return nil, err
+	}
 
 	if err = res.readfiles(); err != nil {
 		return nil, err
@@ -144,30 +205,150 @@ func openFromConfig(cfg *Config) (*Indexer, error) {
 	for i := range res.shards {
 		shard := &res.shards[i]
 		broot := cfg.PostPath(i)
-		if err := shard.InitAppend(uint32(i), broot, uint32(cfg.DocFlushRate)); err != nil {
+		if err := shard.InitAppend(uint32(i), broot, uint32(cfg.DocFlushRate), cfg.FormatVersion(), cfg.blotBits(), cfg.checkBytes()); err != nil {
 			return nil, err
 		}
 		postChans[i] = shard.PostChan()
 		go shard.Serve()
 	}
-	res.shatter, err = startShatter(cfg.NumShatters,
-		len(res.shards), cfg.SeqLen, res.dmds.Last(), tokenfn,
-		&cfg.BlotConfig, postChans)
+	taboo, err := res.tabooBlots()
+	if err != nil {
+		return nil, err
+	}
+	res.shatter, res.mono, err = startShatter(cfg.NumShatters,
+		len(res.shards), cfg.NumBlots(), cfg.SeqLen, res.dmds.Last(), tokenfn,
+		&cfg.BlotConfig, taboo, res.sigs, res.fps, postChans)
 	if err != nil {
 		return nil, err
 	}
+	if err := res.checkpoint(ckptKind); err != nil {
+		return nil, err
+	}
 	return res, nil
 }
 
-// write the files that apeared in added documents.
-func (x *Indexer) writeFiles() error {
-	docPath := x.config.FnamesPath()
+// write the files that apeared in added documents to
+// docPath.
+func (x *Indexer) writeFiles(docPath string) error {
 	f, e := os.OpenFile(docPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if e != nil {
 		return e
 	}
 	defer f.Close()
-	return x.fnames.write(f)
+	if e := x.fnames.write(f, x.config.FormatVersion()); e != nil {
+		return e
+	}
+	return f.Sync()
+}
+
+// Checkpoint makes a checkpoint of the index, to which
+// it is rolled back if the program stops before the next
+// checkpoint.  x also checkpoints every CheckpointRate
+// documents and when it is closed.
+func (x *Indexer) Checkpoint() error {
+	return x.checkpoint(ckptKind)
+}
+
+// checkpoint makes a checkpoint of kind, as described
+// at ckptKind, of all documents added so far.
+func (x *Indexer) checkpoint(kind uint32) error {
+	last := x.dmds.Last()
+	x.mono.wait(last)
+	ck := &checkpoint{
+		kind:     kind,
+		seq:      x.seq + 1,
+		docs:     last + 1,
+		posSizes: make([]int64, len(x.shards))}
+	dir := x.config.checkpointPath(ck.seq)
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	errs := make(chan error, len(x.shards))
+	for i := range x.shards {
+		go func(i int) {
+			var err error
+			ck.posSizes[i], err = x.shards[i].Checkpoint(dir)
+			errs <- err
+		}(i)
+	}
+	var err error
+	for range x.shards {
+		if ierr := <-errs; err == nil {
+			err = ierr
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := x.dmds.Sync(); err != nil {
+		return err
+	}
+	if x.sigs != nil {
+		if err := x.sigs.Sync(); err != nil {
+			return err
+		}
+	}
+	if x.fps != nil {
+		if err := x.fps.Sync(); err != nil {
+			return err
+		}
+	}
+	if err := x.writeFiles(filepath.Join(dir, filepath.Base(x.config.FnamesPath()))); err != nil {
+		return err
+	}
+	if err := syncDir(dir); err != nil {
+		return err
+	}
+	if x.journal == nil {
+		x.journal, err = startJournal(x.config, ck)
+	} else {
+		err = x.journal.commit(ck)
+	}
+	if err != nil {
+		return err
+	}
+	x.seq = ck.seq
+	return applyCheckpoint(x.config.IndexRoot, dir)
+}
+
+// openSigs opens the MinHash signatures and SimHash
+// fingerprints of the index of x, if it keeps them.
+func (x *Indexer) openSigs() error {
+	cfg := x.config
+	n := x.dmds.Last() + 1
+	var err error
+	if cfg.MinHash != 0 {
+		x.sigs, err = minhash.NewAdder(cfg.MinHashPath(), cfg.MinHash)
+		if err != nil {
+			return err
+		}
+		if x.sigs.Len() != n {
+			return fmt.Errorf("%s has %d signatures for %d documents", cfg.MinHashPath(), x.sigs.Len(), n)
+		}
+	}
+	if cfg.SimHash {
+		x.fps, err = simhash.NewAdder(cfg.SimHashPath())
+		if err != nil {
+			return err
+		}
+		if x.fps.Len() != n {
+			return fmt.Errorf("%s has %d fingerprints for %d documents", cfg.SimHashPath(), x.fps.Len(), n)
+		}
+	}
+	return nil
+}
+
+// tabooBlots returns the blots of the taboo list
+// of x, which are not posted.
+func (x *Indexer) tabooBlots() (map[uint64]bool, error) {
+	t, err := readTaboo(x.config)
+	if err != nil {
+		return nil, err
+	}
+	return t.blots(x.config)
 }
 
 // Root returns the path to the root of the index 'x'.
@@ -186,15 +367,15 @@ func (x *Indexer) readfiles() error {
 	}
 	defer f.Close()
 	//r := bufio.NewReader(f)
-	fnames, err := readFnames(f)
+	fnames, err := readFnames(f, docPath, x.config.FormatVersion())
 	x.fnames = fnames
 	return err
 }
 
-// Close attempts to flush all data associated
-// with the index to disk.
+// Close checkpoints the index and closes x.
 func (x *Indexer) Close() error {
 	defer x.lock.Close()
+	err := x.checkpoint(closeKind)
 	for i := 0; i < x.config.NumShatters; i++ {
 		x.shatter <- &shatterReq{shutdown: true}
 		// no more shatters running, each waits
@@ -204,25 +385,24 @@ func (x *Indexer) Close() error {
 	for i := 0; i < x.config.NumShards; i++ {
 		close(x.shards[i].PostChan())
 	}
-	if err := x.config.Write(); err != nil {
-		return err
+	if derr := x.dmds.Close(); err == nil {
+		err = derr
 	}
-	if err := x.writeFiles(); err != nil {
-		return err
+	if x.sigs != nil {
+		if serr := x.sigs.Close(); err == nil {
+			err = serr
+		}
 	}
-	if err := x.dmds.Close(); err != nil {
-		return err
+	if x.fps != nil {
+		if ferr := x.fps.Close(); err == nil {
+			err = ferr
+		}
 	}
-	errs := make(chan error, len(x.shards))
-	for i := range x.shards {
-		b := &x.shards[i]
-		go func(b *shard.Indexer) {
-			errs <- b.Close()
-		}(b)
+	if jerr := x.journal.Close(); err == nil {
+		err = jerr
 	}
-	var err error
 	for i := range x.shards {
-		ierr := <-errs
+		ierr := x.shards[i].Close()
 		if err != nil && ierr != nil {
 			log.Printf("dupy.Index.Close: dropping error %s from bucket %d", ierr, i)
 		} else if ierr != nil {
@@ -240,10 +420,13 @@ func (x *Indexer) Add(doc *Doc) error {
 	}
 	doc.Path = ""
 	x.shatter <- &shatterReq{docid: did, offset: doc.Start, d: doc.Dat}
+	if rate := x.config.CheckpointRate; rate > 0 && did%uint64(rate) == 0 {
+		return x.checkpoint(ckptKind)
+	}
 	return nil
 }
 
-func (x *Indexer) doc2Id(doc *Doc) (uint32, error) {
+func (x *Indexer) doc2Id(doc *Doc) (uint64, error) {
 	n, err := x.fnames.addPath(doc.Path)
 	if err != nil {
 		return 0, err
@@ -0,0 +1,238 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package docrec reads and appends files of fixed size
+// records, one per document id, like the dmd file.  Record
+// 0, which is not a document, is a header starting with a
+// magic number and a version, followed by parameters of
+// the records.
+package docrec
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-air/dupi/internal/format"
+)
+
+// checkHeader checks that the header rcd of the file at
+// path is hdr.
+func checkHeader(path string, rcd, hdr []byte) error {
+	switch {
+	case !bytes.Equal(rcd[:4], hdr[:4]):
+		return format.Corrupt(path, "bad magic %08x", binary.BigEndian.Uint32(rcd))
+	case !bytes.Equal(rcd[4:8], hdr[4:8]):
+		return format.Incompatible(path, "unknown version %d", binary.BigEndian.Uint32(rcd[4:]))
+	case !bytes.Equal(rcd, hdr):
+		return format.Corrupt(path, "header %x, want %x", rcd, hdr)
+	}
+	return nil
+}
+
+// Adder appends records to a file.
+type Adder struct {
+	path string
+	f    *os.File
+	w    *bufio.Writer
+	size int
+	// the number of records, including buffered ones.
+	n uint64
+	// the first error of Add.
+	err error
+}
+
+// NewAdder opens the file at path, whose header is hdr,
+// for appending, creating it if it does not exist.  The
+// records of the file have the size of hdr, which must be
+// at least 8 bytes.
+func NewAdder(path string, hdr []byte) (*Adder, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	a := &Adder{path: path, f: f, size: len(hdr)}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	a.n = uint64(fi.Size()) / uint64(a.size)
+	if _, err := f.Seek(int64(a.n)*int64(a.size), io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	a.w = bufio.NewWriter(f)
+	if a.n == 0 {
+		return a, a.write(hdr)
+	}
+	rcd := make([]byte, a.size)
+	if _, err := f.ReadAt(rcd, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := checkHeader(path, rcd, hdr); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// Len returns the number of records added, including
+// the header.
+func (a *Adder) Len() uint64 {
+	return a.n
+}
+
+// Add adds rcd as the record of document did, which must
+// be the next document.  Errors are kept and returned by
+// Sync and Close.
+func (a *Adder) Add(did uint64, rcd []byte) {
+	if a.err != nil {
+		return
+	}
+	if did != a.n {
+		a.err = fmt.Errorf("%s: adding record of document %d after %d", a.path, did, a.n-1)
+		return
+	}
+	a.err = a.write(rcd)
+}
+
+func (a *Adder) write(rcd []byte) error {
+	if _, err := a.w.Write(rcd); err != nil {
+		return err
+	}
+	a.n++
+	return nil
+}
+
+// Sync writes all added records to disk and syncs them.
+func (a *Adder) Sync() error {
+	if a.err != nil {
+		return a.err
+	}
+	if err := a.w.Flush(); err != nil {
+		return err
+	}
+	return a.f.Sync()
+}
+
+func (a *Adder) Close() error {
+	err := a.Sync()
+	if cerr := a.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Truncate removes the records of the documents with ids
+// n and above from the file at path, with records of size
+// bytes.
+func Truncate(path string, size int, n uint64) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	want := int64(n) * int64(size)
+	if fi.Size() < want {
+		return fmt.Errorf("%s has %d records, fewer than %d", path, fi.Size()/int64(size), n)
+	}
+	if err := f.Truncate(want); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// File reads a file of records.  A File may be used by
+// many goroutines at once.
+type File struct {
+	path string
+	f    *os.File
+	size int
+}
+
+// Open opens the file at path, whose header is hdr.
+func Open(path string, hdr []byte) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	res := &File{path: path, f: f, size: len(hdr)}
+	rcd, err := res.Record(nil, 0)
+	if err == nil {
+		err = checkHeader(path, rcd, hdr)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return res, nil
+}
+
+// Len returns the number of records of f, including the
+// header.
+func (f *File) Len() (uint64, error) {
+	fi, err := f.f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(fi.Size()) / uint64(f.size), nil
+}
+
+// Record appends to dst the record of document did.
+func (f *File) Record(dst []byte, did uint64) ([]byte, error) {
+	n := len(dst)
+	for i := 0; i < f.size; i++ {
+		dst = append(dst, 0)
+	}
+	if _, err := f.f.ReadAt(dst[n:], int64(did)*int64(f.size)); err != nil {
+		if err == io.EOF {
+			return dst[:n], format.Corrupt(f.path, "no record for document %d", did)
+		}
+		return dst[:n], err
+	}
+	return dst, nil
+}
+
+// Each calls fn with every document id of f and its
+// record, which fn must not retain.
+func (f *File) Each(fn func(did uint64, rcd []byte) error) error {
+	r := bufio.NewReader(io.NewSectionReader(f.f, 0, 1<<62))
+	rcd := make([]byte, f.size)
+	for did := uint64(0); ; did++ {
+		if _, err := io.ReadFull(r, rcd); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if did == 0 {
+			continue
+		}
+		if err := fn(did, rcd); err != nil {
+			return err
+		}
+	}
+}
+
+func (f *File) Close() error {
+	return f.f.Close()
+}
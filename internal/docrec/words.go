@@ -0,0 +1,113 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package docrec
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// WordHeader returns the header of a file of records of
+// k big endian uint32 words: magic, version, k if k > 2,
+// and then zeros.
+func WordHeader(magic, version uint32, k int) []byte {
+	hdr := make([]uint32, k)
+	copy(hdr, []uint32{magic, version, uint32(k)})
+	return encode(make([]byte, 4*k), hdr)
+}
+
+// WordAdder appends records of k words to a file.
+type WordAdder struct {
+	*Adder
+	buf []byte
+}
+
+// NewWordAdder opens the file at path, with records of k
+// words and the header given by magic and version, for
+// appending, creating it if it does not exist.
+func NewWordAdder(path string, magic, version uint32, k int) (*WordAdder, error) {
+	if k < 2 {
+		return nil, fmt.Errorf("%s: records of %d words", path, k)
+	}
+	a, err := NewAdder(path, WordHeader(magic, version, k))
+	if err != nil {
+		return nil, err
+	}
+	return &WordAdder{Adder: a, buf: make([]byte, 4*k)}, nil
+}
+
+// Add adds words as the record of document did, as
+// Adder.Add.
+func (a *WordAdder) Add(did uint64, words []uint32) {
+	a.Adder.Add(did, encode(a.buf, words))
+}
+
+// WordFile reads a file of records of k words.  A WordFile
+// may be used by many goroutines at once.
+type WordFile struct {
+	*File
+	k int
+}
+
+// OpenWords opens the file at path, with records of k
+// words and the header given by magic and version.
+func OpenWords(path string, magic, version uint32, k int) (*WordFile, error) {
+	if k < 2 {
+		return nil, fmt.Errorf("%s: records of %d words", path, k)
+	}
+	f, err := Open(path, WordHeader(magic, version, k))
+	if err != nil {
+		return nil, err
+	}
+	return &WordFile{File: f, k: k}, nil
+}
+
+// RecordWords appends to dst the words of the record of
+// document did.
+func (f *WordFile) RecordWords(dst []uint32, did uint64) ([]uint32, error) {
+	buf, err := f.Record(make([]byte, 0, 4*f.k), did)
+	if err != nil {
+		return dst, err
+	}
+	n := len(dst)
+	for i := 0; i < f.k; i++ {
+		dst = append(dst, 0)
+	}
+	decode(dst[n:], buf)
+	return dst, nil
+}
+
+// EachWords calls fn with every document id of f and the
+// words of its record, which fn must not retain.
+func (f *WordFile) EachWords(fn func(did uint64, words []uint32) error) error {
+	words := make([]uint32, f.k)
+	return f.Each(func(did uint64, rcd []byte) error {
+		return fn(did, decode(words, rcd))
+	})
+}
+
+func encode(dst []byte, words []uint32) []byte {
+	for i, v := range words {
+		binary.BigEndian.PutUint32(dst[4*i:], v)
+	}
+	return dst
+}
+
+func decode(dst []uint32, buf []byte) []uint32 {
+	for i := range dst {
+		dst[i] = binary.BigEndian.Uint32(buf[4*i:])
+	}
+	return dst
+}
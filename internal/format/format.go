@@ -0,0 +1,214 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package format holds what is shared by the files of an
+// index: their magic numbers, headers, checksums and
+// errors.
+//
+// Files with a header start with a 4 byte magic number
+// identifying the kind of file and a 4 byte version, both
+// big endian.  Files written by Writer end with the
+// CRC32C (Castagnoli) checksum of everything before it.
+package format
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+)
+
+// Magic numbers of files with headers.
+const (
+	PostMagic   = 0x64706f73 // "dpos"
+	IixMagic    = 0x64696978 // "diix"
+	FnamesMagic = 0x64666e6d // "dfnm"
+)
+
+// HeaderSize is the size of a file header.
+const HeaderSize = 8
+
+var (
+	// ErrIncompatible is the error of files written in
+	// a format this version of dupi cannot read.
+	ErrIncompatible = errors.New("incompatible format")
+	// ErrCorrupt is the error of files which are
+	// truncated or fail their checksums.
+	ErrCorrupt = errors.New("corrupt file")
+)
+
+// Error is an ErrIncompatible or ErrCorrupt error in the
+// file Path.
+type Error struct {
+	Path   string
+	Err    error
+	Detail string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Path, e.Err, e.Detail)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Corrupt returns an ErrCorrupt error in the file path.
+func Corrupt(path, format string, args ...interface{}) error {
+	return &Error{Path: path, Err: ErrCorrupt, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Incompatible returns an ErrIncompatible error in the
+// file path.
+func Incompatible(path, format string, args ...interface{}) error {
+	return &Error{Path: path, Err: ErrIncompatible, Detail: fmt.Sprintf(format, args...)}
+}
+
+// Table is the CRC32C table used for all checksums.
+var Table = crc32.MakeTable(crc32.Castagnoli)
+
+// Header returns the header of a file with magic and
+// version.
+func Header(magic uint32, version int) []byte {
+	var buf [HeaderSize]byte
+	binary.BigEndian.PutUint32(buf[0:], magic)
+	binary.BigEndian.PutUint32(buf[4:], uint32(version))
+	return buf[:]
+}
+
+// CheckHeader checks that hdr is the header of a file
+// path with magic and a version between min and max,
+// returning the version.
+func CheckHeader(path string, hdr []byte, magic uint32, min, max int) (int, error) {
+	if len(hdr) < HeaderSize {
+		return 0, Corrupt(path, "short header")
+	}
+	if m := binary.BigEndian.Uint32(hdr[0:]); m != magic {
+		return 0, Incompatible(path, "magic %08x, want %08x", m, uint32(magic))
+	}
+	v := binary.BigEndian.Uint32(hdr[4:])
+	if v < uint32(min) || v > uint32(max) {
+		return 0, Incompatible(path, "version %d, want %d to %d", v, min, max)
+	}
+	return int(v), nil
+}
+
+// Writer writes a file with a header, ending it with the
+// checksum of what was written when closed.
+type Writer struct {
+	w   *bufio.Writer
+	crc uint32
+}
+
+// NewWriter returns a Writer writing to w a file with
+// magic and version.
+func NewWriter(w io.Writer, magic uint32, version int) (*Writer, error) {
+	res := &Writer{w: bufio.NewWriter(w)}
+	if _, err := res.Write(Header(magic, version)); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (w *Writer) Write(d []byte) (int, error) {
+	w.crc = crc32.Update(w.crc, Table, d)
+	return w.w.Write(d)
+}
+
+// Close writes the checksum and flushes w.  It does not
+// close the underlying writer.
+func (w *Writer) Close() error {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], w.crc)
+	if _, err := w.w.Write(buf[:]); err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// Reader reads a file written by Writer, checking its
+// header and checksum.
+type Reader struct {
+	path    string
+	r       *bufio.Reader
+	crc     uint32
+	version int
+	b       [1]byte
+}
+
+// NewReader returns a Reader of the file path read from
+// r, which must have magic and a version between min and
+// max.
+func NewReader(r io.Reader, path string, magic uint32, min, max int) (*Reader, error) {
+	res := &Reader{path: path, r: bufio.NewReader(r)}
+	var hdr [HeaderSize]byte
+	if _, err := io.ReadFull(res, hdr[:]); err != nil {
+		return nil, res.readErr(err)
+	}
+	var err error
+	res.version, err = CheckHeader(path, hdr[:], magic, min, max)
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Version returns the version in the header of r.
+func (r *Reader) Version() int {
+	return r.version
+}
+
+func (r *Reader) Read(d []byte) (int, error) {
+	n, err := r.r.Read(d)
+	r.crc = crc32.Update(r.crc, Table, d[:n])
+	return n, r.readErr(err)
+}
+
+func (r *Reader) ReadByte() (byte, error) {
+	c, err := r.r.ReadByte()
+	if err != nil {
+		return 0, r.readErr(err)
+	}
+	r.b[0] = c
+	r.crc = crc32.Update(r.crc, Table, r.b[:])
+	return c, nil
+}
+
+// readErr turns errors from reading before the checksum
+// into ErrCorrupt errors, as the checksum must follow.
+func (r *Reader) readErr(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return Corrupt(r.path, "truncated")
+	}
+	return err
+}
+
+// Check reads the checksum, checking that it matches
+// what was read and that it ends the file.
+func (r *Reader) Check() error {
+	want := r.crc
+	var buf [4]byte
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		return r.readErr(err)
+	}
+	if got := binary.BigEndian.Uint32(buf[:]); got != want {
+		return Corrupt(r.path, "checksum %08x, want %08x", got, want)
+	}
+	if _, err := r.r.ReadByte(); err != io.EOF {
+		return Corrupt(r.path, "data after checksum")
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mmap reads files through read only memory maps
+// where they are supported, which is on Linux.
+package mmap
+
+import "os"
+
+// File reads a file whose prefix, as long as the file
+// was when it was mapped, is memory mapped.  Reads past
+// the prefix read the file, so that files may be read
+// while they are appended to.  Where memory maps are not
+// supported, no prefix is mapped.
+type File struct {
+	f    *os.File
+	data []byte
+}
+
+// Map maps f, which must be open for reading.  f must
+// stay open, and not be truncated, until the File is
+// closed.
+func Map(f *os.File) (*File, error) {
+	res := &File{f: f}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !Supported || fi.Size() == 0 || int64(int(fi.Size())) != fi.Size() {
+		return res, nil
+	}
+	res.data, err = mapFile(f, int(fi.Size()))
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// Bytes returns the mapped prefix of the file, which
+// must not be modified.
+func (m *File) Bytes() []byte {
+	return m.data
+}
+
+func (m *File) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 || off >= int64(len(m.data)) {
+		return m.f.ReadAt(p, off)
+	}
+	n := copy(p, m.data[off:])
+	if n == len(p) {
+		return n, nil
+	}
+	k, err := m.f.ReadAt(p[n:], off+int64(n))
+	return n + k, err
+}
+
+// Stat returns the FileInfo of the file, whose size
+// includes any data appended after it was mapped.
+func (m *File) Stat() (os.FileInfo, error) {
+	return m.f.Stat()
+}
+
+// Close unmaps the file, but does not close it.
+func (m *File) Close() error {
+	if m.data == nil {
+		return nil
+	}
+	data := m.data
+	m.data = nil
+	return unmapFile(data)
+}
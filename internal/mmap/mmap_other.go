@@ -0,0 +1,34 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux
+// +build !linux
+
+package mmap
+
+import (
+	"errors"
+	"os"
+)
+
+// Supported is whether files are memory mapped.
+const Supported = false
+
+func mapFile(f *os.File, size int) ([]byte, error) {
+	return nil, errors.New("mmap: not supported")
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
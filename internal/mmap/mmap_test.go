@@ -0,0 +1,61 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mmap
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	f, err := ioutil.TempFile(".", "mmap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.WriteString("0123456789"); err != nil {
+		t.Fatal(err)
+	}
+	m, err := Map(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	if Supported && string(m.Bytes()) != "0123456789" {
+		t.Errorf("mapped %q", m.Bytes())
+	}
+	if _, err := f.WriteString("abcdef"); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		off  int64
+		n    int
+		want string
+	}{
+		{0, 4, "0123"},
+		{7, 6, "789abc"},
+		{12, 4, "cdef"},
+	} {
+		buf := make([]byte, c.n)
+		if _, err := m.ReadAt(buf, c.off); err != nil {
+			t.Fatal(err)
+		}
+		if string(buf) != c.want {
+			t.Errorf("at %d got %q want %q", c.off, buf, c.want)
+		}
+	}
+}
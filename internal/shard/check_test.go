@@ -0,0 +1,199 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shard
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type checkPost struct {
+	did   uint64
+	check uint32
+}
+
+func TestCheckBytes(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "shard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "b0.pos")
+	var old Indexer
+	if err := old.InitCreate(0, path, 1024, Version3, 4, 2); err == nil {
+		t.Errorf("created shard of version %d with check bytes", Version3)
+	}
+	var idxr Indexer
+	if err := idxr.InitCreate(0, path, 1024, Version, 4, 2); err != nil {
+		t.Fatal(err)
+	}
+	var want []checkPost
+	docs := 0
+	for did := uint64(1); did < 2000; did += 3 {
+		// some documents have the blot with several
+		// checks, and repeated checks are posted once.
+		docs++
+		for _, ck := range []uint32{uint32(did * 7), uint32(did * 7), uint32(did * 11), uint32(did * 13)}[:1+did%4] {
+			if err := idxr.ind[5].AddPost(did, ck&0xffff, idxr.postFile); err != nil {
+				t.Fatal(err)
+			}
+			if n := len(want); n == 0 || want[n-1] != (checkPost{did, ck & 0xffff}) {
+				want = append(want, checkPost{did, ck & 0xffff})
+			}
+		}
+	}
+	if _, err := idxr.Checkpoint(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var idx Index
+	if err := idx.Init(path, Version, 4, 2); err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	// the count is of documents, not posts.
+	if idx.Count(5) != uint32(docs) {
+		t.Errorf("count got %d want %d", idx.Count(5), docs)
+	}
+	rs := idx.ReadStateFor(5)
+	for i := 0; ; i++ {
+		did, ck, err := rs.NextCheck()
+		if err == io.EOF {
+			if i != len(want) {
+				t.Errorf("got %d posts want %d", i, len(want))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(want) || (checkPost{did, ck}) != want[i] {
+			t.Fatalf("post %d: got %d %x", i, did, ck)
+		}
+	}
+	// Next gives each document once.
+	rs = idx.ReadStateFor(5)
+	for i := uint64(1); ; i += 3 {
+		did, err := rs.Next()
+		if err == io.EOF {
+			if i != 2002 {
+				t.Errorf("documents ended before %d", i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if did != i {
+			t.Fatalf("got document %d want %d", did, i)
+		}
+	}
+	if err := idx.Verify(2000, func(p *Problem) { t.Errorf("problem %+v", p) }); err != nil {
+		t.Fatal(err)
+	}
+	cpath := filepath.Join(tmp, "c0.pos")
+	if err := idx.Rewrite(cpath, func(uint64) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	var dst Index
+	if err := dst.Init(cpath, Version, 4, 2); err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if dst.Count(5) != idx.Count(5) {
+		t.Errorf("rewritten count %d want %d", dst.Count(5), idx.Count(5))
+	}
+	if err := dst.Verify(2000, func(p *Problem) { t.Errorf("rewritten problem %+v", p) }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckBytesAppend(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "shard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "b0.pos")
+	add := func(idxr *Indexer, did uint64, ck uint32) {
+		t.Helper()
+		if err := idxr.ind[5].AddPost(did, ck, idxr.postFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var idxr Indexer
+	if err := idxr.InitCreate(0, path, 1024, Version, 4, 2); err != nil {
+		t.Fatal(err)
+	}
+	add(&idxr, 1, 0x11)
+	add(&idxr, 2, 0x21)
+	add(&idxr, 2, 0x22)
+	if _, err := idxr.Checkpoint(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	idxr = Indexer{}
+	if err := idxr.InitAppend(0, path, 1024, Version, 4, 2); err != nil {
+		t.Fatal(err)
+	}
+	// the last post is known after appending, and is
+	// not repeated.
+	add(&idxr, 2, 0x22)
+	add(&idxr, 2, 0x23)
+	add(&idxr, 3, 0x31)
+	if _, err := idxr.Checkpoint(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	var idx Index
+	if err := idx.Init(path, Version, 4, 2); err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if idx.Count(5) != 3 {
+		t.Errorf("count got %d want 3", idx.Count(5))
+	}
+	want := []checkPost{{1, 0x11}, {2, 0x21}, {2, 0x22}, {2, 0x23}, {3, 0x31}}
+	rs := idx.ReadStateFor(5)
+	for i := 0; ; i++ {
+		did, ck, err := rs.NextCheck()
+		if err == io.EOF {
+			if i != len(want) {
+				t.Errorf("got %d posts want %d", i, len(want))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(want) || (checkPost{did, ck}) != want[i] {
+			t.Fatalf("post %d: got %d %x", i, did, ck)
+		}
+	}
+	if err := idx.Verify(4, func(p *Problem) { t.Errorf("problem %+v", p) }); err != nil {
+		t.Fatal(err)
+	}
+}
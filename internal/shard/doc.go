@@ -14,3 +14,84 @@
 
 // Package shard implements sharded posting indices.
 package shard
+
+import (
+	"fmt"
+
+	"github.com/go-air/dupi/internal/format"
+)
+
+// Format versions of shards.  The iix entry of each blot
+// ends with the last docid posted for the blot, which is
+// 32 bits in Version1 and 64 bits in later versions.
+//
+// Since Version3, the posting file and the iix start with
+// a header giving their kind and version, the iix ends
+// with a checksum, and each chunk of posts has a checksum
+// before its link.  Earlier versions have no headers or
+// checksums.
+//
+// In Version, each docid delta in a chunk may be followed
+// by check bytes: the upper bits of the blot of the post,
+// big endian.  The number of check bytes is given by the
+// index config, and is 0 in earlier versions.
+const (
+	Version1 = 1
+	Version2 = 2
+	Version3 = 3
+	Version  = 4
+)
+
+// docidSize returns the size of the last docid in iix
+// entries of shards with format version.
+func docidSize(version int) int {
+	if version <= Version1 {
+		return 4
+	}
+	return 8
+}
+
+// headerSize returns the size of the header of posting
+// and iix files of shards with format version.
+func headerSize(version int) int64 {
+	if version < Version3 {
+		return 0
+	}
+	return format.HeaderSize
+}
+
+// chunkTrailer returns the size of what follows the
+// deltas of a chunk of posts of shards with format
+// version: a checksum, since Version3, and the link.
+func chunkTrailer(version int) int {
+	if version < Version3 {
+		return 8
+	}
+	return 12
+}
+
+// putCheck appends the n check bytes of check to dst.
+func putCheck(dst []byte, check uint32, n int) []byte {
+	for i := n - 1; i >= 0; i-- {
+		dst = append(dst, byte(check>>(8*uint(i))))
+	}
+	return dst
+}
+
+// getCheck decodes n check bytes from buf.
+func getCheck(buf []byte, n int) uint32 {
+	var res uint32
+	for _, b := range buf[:n] {
+		res = res<<8 | uint32(b)
+	}
+	return res
+}
+
+// checkVersion checks that shards with format version
+// may have check bytes if check is not 0.
+func checkVersion(path string, version, check int) error {
+	if check < 0 || check > 4 || check != 0 && version < Version {
+		return fmt.Errorf("%s: shards of format version %d cannot have %d check bytes", path, version, check)
+	}
+	return nil
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shard
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+
+	"github.com/go-air/dupi/internal/format"
+)
+
+// iixReader reads the iix of a shard.
+type iixReader struct {
+	f  *os.File
+	r  io.ByteReader
+	fr *format.Reader
+}
+
+// openIix opens the iix at path of a shard with format
+// version, checking its header.
+func openIix(path string, version int) (*iixReader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	res := &iixReader{f: f}
+	if version < Version3 {
+		res.r = bufio.NewReader(f)
+		return res, nil
+	}
+	res.fr, err = format.NewReader(f, path, format.IixMagic, version, version)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	res.r = res.fr
+	return res, nil
+}
+
+func (r *iixReader) ReadByte() (byte, error) {
+	return r.r.ReadByte()
+}
+
+// end checks that the iix, which has entries for n
+// blots, ends after the entries read.
+func (r *iixReader) end(n int) error {
+	if r.fr != nil {
+		return r.fr.Check()
+	}
+	if _, err := r.r.ReadByte(); err != io.EOF {
+		return fmt.Errorf("%s: more than %d blots", r.f.Name(), n)
+	}
+	return nil
+}
+
+func (r *iixReader) Close() error {
+	return r.f.Close()
+}
+
+// iixWriter writes the iix of a shard.
+type iixWriter struct {
+	f  *os.File
+	bw *bufio.Writer
+	fw *format.Writer
+	w  io.Writer
+}
+
+// createIix creates the iix at path of a shard with
+// format version.
+func createIix(path string, version int) (*iixWriter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	res := &iixWriter{f: f}
+	if version < Version3 {
+		res.bw = bufio.NewWriter(f)
+		res.w = res.bw
+		return res, nil
+	}
+	res.fw, err = format.NewWriter(f, format.IixMagic, version)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	res.w = res.fw
+	return res, nil
+}
+
+func (w *iixWriter) Write(d []byte) (int, error) {
+	return w.w.Write(d)
+}
+
+// finish ends the iix and syncs it.
+func (w *iixWriter) finish() error {
+	var err error
+	if w.fw != nil {
+		err = w.fw.Close()
+	} else {
+		err = w.bw.Flush()
+	}
+	if err != nil {
+		return err
+	}
+	return w.f.Sync()
+}
+
+func (w *iixWriter) Close() error {
+	return w.f.Close()
+}
+
+// checkPostHeader checks the header of the posting file f
+// at path of a shard with format version.
+func checkPostHeader(f *os.File, path string, version int) error {
+	if version < Version3 {
+		return nil
+	}
+	var hdr [format.HeaderSize]byte
+	if _, err := f.ReadAt(hdr[:], 0); err != nil {
+		if err == io.EOF {
+			return format.Corrupt(path, "short header")
+		}
+		return err
+	}
+	_, err := format.CheckHeader(path, hdr[:], format.PostMagic, version, version)
+	return err
+}
+
+// sealChunk completes the chunk of posts whose deltas are
+// in chunk, appending its checksum, since Version3, and an
+// empty link.  sealChunk returns the chunk and its length
+// encoded in vbuf, which precedes it in the posting file.
+// The checksum covers the length and the deltas.
+func sealChunk(chunk, vbuf []byte, version int) ([]byte, []byte) {
+	n := binary.PutVarint(vbuf, int64(len(chunk)+chunkTrailer(version)))
+	if version >= Version3 {
+		crc := crc32.Update(crc32.Checksum(vbuf[:n], format.Table), format.Table, chunk)
+		var sum [4]byte
+		binary.BigEndian.PutUint32(sum[:], crc)
+		chunk = append(chunk, sum[:]...)
+	}
+	var nolink [8]byte
+	binary.BigEndian.PutUint64(nolink[:], ^uint64(0))
+	return append(chunk, nolink[:]...), vbuf[:n]
+}
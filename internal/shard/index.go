@@ -15,116 +15,177 @@
 package shard
 
 import (
-	"bufio"
 	"encoding/binary"
 	"fmt"
 	"io"
 	"os"
 	"sort"
+
+	"github.com/go-air/dupi/internal/mmap"
 )
 
 type Index struct {
 	id       uint32
 	path     string
-	heads    [1 << 16]int64
-	counts   [1 << 16]uint32
-	perm     [1 << 16]uint16
+	tab      *table
 	postFile *os.File
+	// size is the size of postFile when opened.
+	size    int64
+	version int
+	check   int
+	// rdr reads postFile, through mm if it is mapped.
+	rdr io.ReaderAt
+	mm  *mmap.File
 }
 
-func (x *Index) Init(path string) error {
+// Init opens the shard with posts at path, format
+// version and 1<<bits blots, whose posts have check bytes.
+func (x *Index) Init(path string, version, bits, check int) error {
+	if err := checkVersion(path, version, check); err != nil {
+		return err
+	}
 	x.path = path
+	x.version = version
+	x.check = check
+	tab, err := newTable(bits)
+	if err != nil {
+		return err
+	}
+	x.tab = tab
 	if err := x.readIix(); err != nil {
+		x.tab.close()
 		return err
 	}
 	f, err := os.Open(x.path)
 	if err != nil {
+		x.tab.close()
+		return err
+	}
+	if err := checkPostHeader(f, x.path, x.version); err != nil {
+		f.Close()
+		x.tab.close()
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		x.tab.close()
 		return err
 	}
+	x.size = fi.Size()
 	x.postFile = f
+	x.rdr = f
+	return nil
+}
+
+// Mmap makes x read its posting file through a memory
+// map, where they are supported.  Posts appended after
+// Mmap are read from the file.
+func (x *Index) Mmap() error {
+	mm, err := mmap.Map(x.postFile)
+	if err != nil {
+		return fmt.Errorf("%s: %w", x.path, err)
+	}
+	x.mm = mm
+	x.rdr = mm
 	return nil
 }
 
 func (x *Index) Close() error {
-	return x.postFile.Close()
-}
-
-func (x *Index) ReadStateFor(blot uint16) *ReadState {
-	/*
-		        broken still
-			refCount := x.counts[blot]
-			i := sort.Search(1<<16, func(i int) bool {
-				return x.counts[x.perm[i]] > refCount
-			})
-			if i == len(x.perm) {
-				panic("internal error in perm/count")
-			}
-			if x.perm[i] != blot {
-				fmt.Printf("blot %x i %d perm[%d] %x count %d ref %d %#v\n", blot, i, i, x.perm[i], x.counts[x.perm[i]], refCount, x.perm[i-1:i+2])
-				panic("internal error2 in perm/count")
-			}
-	*/
-	return x.ReadStateForBlotAt(blot, 0) //uint16(i))
-}
-
-func (x *Index) ReadStateForBlotAt(blot, at uint16) *ReadState {
+	if x.mm != nil {
+		if err := x.mm.Close(); err != nil {
+			return err
+		}
+		x.mm = nil
+	}
+	err := x.postFile.Close()
+	if terr := x.tab.close(); err == nil {
+		err = terr
+	}
+	return err
+}
+
+// ReadStateFor returns a read state for blot, at its
+// rank in the order of blots by decreasing count.
+func (x *Index) ReadStateFor(blot uint32) *ReadState {
+	return x.ReadStateForBlotAt(blot, x.tab.rank(blot))
+}
+
+func (x *Index) ReadStateForBlotAt(blot, at uint32) *ReadState {
 	res := &ReadState{}
-	res.Posts = newPosts(x.heads[blot])
+	res.Posts = x.posts(blot)
 	res.Shard = x.id
 	res.Blot = blot
 	res.At = at
-	res.Total = x.counts[blot]
-	res.rdr = x.postFile
+	res.Total = x.tab.count(blot)
+	res.rdr = x.rdr
 	return res
 }
 
-func (x *Index) BlotAt(i uint16) (blot uint16) {
-	blot = x.perm[i]
+// posts returns the posts of blot.
+func (x *Index) posts(blot uint32) *Posts {
+	return newPosts(x.tab.head(blot), x.size, x.version, x.check, x.path)
+}
+
+// CheckBytes returns the number of check bytes of the
+// posts of x.
+func (x *Index) CheckBytes() int {
+	return x.check
+}
+
+func (x *Index) BlotAt(i uint32) (blot uint32) {
+	blot = x.tab.perm(i)
 	return
 }
 
-func (x *Index) ReadStateAt(i uint16) *ReadState {
+// Rank returns the position of blot in the order of
+// blots by decreasing count, so that BlotAt(Rank(blot)) is
+// blot.
+func (x *Index) Rank(blot uint32) uint32 {
+	return x.tab.rank(blot)
+}
+
+func (x *Index) ReadStateAt(i uint32) *ReadState {
 	return x.ReadStateForBlotAt(x.BlotAt(i), i)
 }
 
 func (x *Index) Count(blot uint32) uint32 {
-	return x.counts[blot]
+	return x.tab.count(blot)
 }
 
 func (x *Index) NumPosts() uint64 {
 	var ttl uint64
-	for _, ct := range x.counts {
-		ttl += uint64(ct)
+	for i := uint32(0); i < x.tab.n; i++ {
+		ttl += uint64(x.tab.count(i))
 	}
 	return ttl
 }
 
 func (x *Index) NumBlots() uint64 {
-	return 1 << 16
+	return uint64(x.tab.n)
 }
 
 func (x *Index) SosDiffs(avg float64) float64 {
 	var ttl float64
-	for _, ct := range x.counts {
-		d := avg - float64(ct)
+	for i := uint32(0); i < x.tab.n; i++ {
+		d := avg - float64(x.tab.count(i))
 		ttl += d * d
 	}
 	return ttl
 }
 
 func (x *Index) readIix() error {
-	f, err := os.Open(fmt.Sprintf("%s.iix", x.path))
+	r, err := openIix(fmt.Sprintf("%s.iix", x.path), x.version)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	r := bufio.NewReader(f)
-	for i := range x.heads {
+	defer r.Close()
+	for i := uint32(0); i < x.tab.n; i++ {
 		hd, err := binary.ReadVarint(r)
 		if err != nil {
 			return err
 		}
-		x.heads[i] = hd
+		x.tab.setHead(i, hd)
 		ct, err := binary.ReadVarint(r)
 		if ct&0xffffffff != ct {
 			return fmt.Errorf("not a 32 bit count")
@@ -132,17 +193,18 @@ func (x *Index) readIix() error {
 		if err != nil {
 			return err
 		}
-		x.counts[i] = uint32(ct)
-		x.perm[i] = uint16(i)
+		x.tab.setCount(i, uint32(ct))
+		x.tab.setPerm(i, i)
 		// unused for reading
-		var buf [4]byte
-		_, err = io.ReadFull(r, buf[:])
+		_, err = readDocid(r, x.version)
 		if err != nil {
 			return err
 		}
 	}
-	sort.Slice(x.perm[:], func(i, j int) bool {
-		return x.counts[x.perm[i]] > x.counts[x.perm[j]]
-	})
+	if err := r.end(int(x.tab.n)); err != nil {
+		return err
+	}
+	sort.Sort((*byCount)(x.tab))
+	x.tab.setRanks()
 	return nil
 }
@@ -15,12 +15,13 @@
 package shard
 
 import (
-	"bufio"
-	"encoding/binary"
 	"fmt"
 	"log"
+	"math"
 	"os"
+	"path/filepath"
 
+	"github.com/go-air/dupi/internal/format"
 	"github.com/go-air/dupi/post"
 )
 
@@ -28,38 +29,64 @@ type Indexer struct {
 	id      uint32
 	root    string
 	postChn chan []post.T
-	ind     [1 << 16]poster
+	ind     []poster
 
 	postFile *os.File
+	version  int
+	check    int
 }
 
-func (x *Indexer) initCommon(id uint32, root string, flushRate uint32) {
+func (x *Indexer) initCommon(id uint32, root string, flushRate uint32, version, bits, check int) {
 	x.root = root
 	x.id = id
+	x.version = version
+	x.check = check
+	x.ind = make([]poster, 1<<uint(bits))
+	for i := range x.ind {
+		x.ind[i].check = check
+	}
 	x.postChn = make(chan []post.T)
 }
 
-func (x *Indexer) InitCreate(id uint32, root string, flushRate uint32) error {
-	x.initCommon(id, root, flushRate)
+// InitCreate creates the shard with posts at root, format
+// version and 1<<bits blots, whose posts have check bytes.
+func (x *Indexer) InitCreate(id uint32, root string, flushRate uint32, version, bits, check int) error {
+	if err := checkVersion(root, version, check); err != nil {
+		return err
+	}
+	x.initCommon(id, root, flushRate, version, bits, check)
 	// maybe this is unnecessary, just write on close...
-	iixName := fmt.Sprintf("%s.iix", x.root)
-	f, err := os.OpenFile(iixName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	w, err := createIix(fmt.Sprintf("%s.iix", x.root), x.version)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
+	defer w.Close()
 	for i := range x.ind {
 		z := &x.ind[i]
-		if err := z.initCreate(f, uint16(i)); err != nil {
+		if err := z.initCreate(w, uint32(i), x.version); err != nil {
 			return err
 		}
 	}
+	if err := w.finish(); err != nil {
+		return err
+	}
 	x.postFile, err = os.OpenFile(x.root, os.O_CREATE|os.O_TRUNC|os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	if x.version >= Version3 {
+		_, err = x.postFile.Write(format.Header(format.PostMagic, x.version))
+	}
 	return err
 }
 
-func (x *Indexer) InitAppend(id uint32, root string, flushRate uint32) error {
-	x.initCommon(id, root, flushRate)
+// InitAppend opens the shard with posts at root to add
+// posts to it, as InitCreate.
+func (x *Indexer) InitAppend(id uint32, root string, flushRate uint32, version, bits, check int) error {
+	if err := checkVersion(root, version, check); err != nil {
+		return err
+	}
+	x.initCommon(id, root, flushRate, version, bits, check)
 	return x.read()
 }
 
@@ -76,9 +103,12 @@ func (x *Indexer) read() error {
 	if err != nil {
 		return err
 	}
+	if err = checkPostHeader(x.postFile, x.root, x.version); err != nil {
+		return err
+	}
 	for i := range x.ind {
 		p := &x.ind[i]
-		err = p.readToLink(x.postFile)
+		err = p.readToLink(x.postFile, math.MaxInt64)
 		if err != nil {
 			return fmt.Errorf("error reading to link for blot %x: %w", i, err)
 		}
@@ -87,74 +117,67 @@ func (x *Indexer) read() error {
 }
 
 func (x *Indexer) readIix() error {
-	iixName := fmt.Sprintf("%s.iix", x.root)
-	f, err := os.OpenFile(iixName, os.O_RDONLY, 0644)
+	r, err := openIix(fmt.Sprintf("%s.iix", x.root), x.version)
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-	br := bufio.NewReader(f)
+	defer r.Close()
 	for i := range x.ind {
 		z := &x.ind[i]
-		err = z.initAppend(br, uint16(i))
+		err = z.initAppend(r, uint32(i), x.version)
 		if err != nil {
 			return err
 		}
 	}
-	return nil
+	return r.end(len(x.ind))
 }
 
-//
-// Flush everything and close
-//
-// list of doc info
-// delta, delta, delta fileid, start-lastend, end-start
-// this assumes the postChn is closed.
-func (x *Indexer) Close() error {
-	if err := x.flushInd(); err != nil {
-		return err
+// Flush writes the buffered posts of x to its posting
+// file and syncs it, returning the size of the file.
+// Flush assumes no posts are being added.
+func (x *Indexer) Flush() (int64, error) {
+	for i := range x.ind {
+		if err := x.ind[i].flushTo(x.postFile); err != nil {
+			return 0, err
+		}
+	}
+	if err := x.postFile.Sync(); err != nil {
+		return 0, err
 	}
-	return x.flushIix()
+	fi, err := x.postFile.Stat()
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
 }
 
-func (x *Indexer) flushIix() error {
-	iix, err := os.OpenFile(fmt.Sprintf("%s.iix", x.root),
-		os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+// Checkpoint flushes x and writes its iix to the directory
+// dir, with the same base name as the iix of x, returning
+// the size of the posting file.  The written iix refers
+// only to posts in the posting file up to that size.
+func (x *Indexer) Checkpoint(dir string) (int64, error) {
+	size, err := x.Flush()
 	if err != nil {
-		return err
+		return 0, err
+	}
+	w, err := createIix(filepath.Join(dir, filepath.Base(x.root)+".iix"), x.version)
+	if err != nil {
+		return 0, err
 	}
-	defer iix.Close()
+	defer w.Close()
 	for i := range x.ind {
-		up := &x.ind[i]
-		_, err = up.writeVarint64(iix, up.head)
-		if err != nil {
-			return err
-		}
-		_, err = up.writeVarint64(iix, int64(up.total))
-		if err != nil {
-			return err
-		}
-		var buf [4]byte
-		binary.BigEndian.PutUint32(buf[:], up.current)
-		_, err = iix.Write(buf[:])
-		if err != nil {
-			return err
+		if err := x.ind[i].writeIix(w, x.version); err != nil {
+			return 0, err
 		}
 	}
-	return nil
+	return size, w.finish()
 }
 
-func (x *Indexer) flushInd() error {
-	var err error
-	defer x.postFile.Close()
-	for i := range x.ind {
-		up := &x.ind[i]
-		err = up.flushTo(x.postFile)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
+// Close closes the posting file of x.  Posts which are
+// not in a checkpoint are lost.  Close assumes the post
+// channel is closed.
+func (x *Indexer) Close() error {
+	return x.postFile.Close()
 }
 
 func (x *Indexer) Serve() {
@@ -166,8 +189,8 @@ func (x *Indexer) Serve() {
 		}
 		for _, p := range ps {
 			docid, hash := p.Docid(), p.Blot()
-			hash &= 0xffff
-			err := x.ind[hash].AddPost(docid, x.postFile)
+			hash &= uint32(len(x.ind) - 1)
+			err := x.ind[hash].AddPost(docid, p.Check(), x.postFile)
 			if err != nil {
 				log.Printf("couldn't add post: %s", err)
 			}
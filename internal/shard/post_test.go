@@ -16,6 +16,7 @@ package shard
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"io"
 	"io/ioutil"
@@ -36,12 +37,12 @@ func TestPosts(t *testing.T) {
 		os.Remove(d.Name())
 	}()
 	poster := &poster{}
-	if err := poster.initCreate(iix, 0x7); err != nil {
+	if err := poster.initCreate(iix, 0x7, Version); err != nil {
 		t.Fatal(err)
 	}
 	ps := gen(1311)
 	for _, p := range ps {
-		if err := poster.AddPost(p, d); err != nil {
+		if err := poster.AddPost(p, 0, d); err != nil {
 			t.Fatal(err)
 		}
 	}
@@ -74,8 +75,12 @@ func TestPosts(t *testing.T) {
 		t.Fatal(err)
 	}
 	_ = ct
-	pr := newPosts(hd)
-	qs := make([]uint32, 0, len(ps))
+	fi, err := d.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pr := newPosts(hd, fi.Size(), Version, 0, d.Name())
+	qs := make([]uint64, 0, len(ps))
 	for {
 		did, err := pr.next(d)
 		if err != nil {
@@ -113,14 +118,42 @@ func postFiles() (*os.File, *os.File, error) {
 	return iix, d, nil
 }
 
-func gen(n int) []uint32 {
-	res := make([]uint32, n)
-	cur := rand.Uint32() >> 4
-	var delta uint32
+// gen generates n increasing docids, which need more
+// than 32 bits.
+func gen(n int) []uint64 {
+	res := make([]uint64, n)
+	cur := 1<<32 + uint64(rand.Uint32()>>4)
+	var delta uint64
 	for i := 0; i < n; i++ {
-		delta = uint32(rand.Intn(117)) + 1
+		delta = uint64(rand.Intn(117)) + 1
 		cur += delta
 		res[i] = cur
 	}
 	return res
 }
+
+func TestIixVersions(t *testing.T) {
+	for _, version := range []int{Version1, Version} {
+		var buf bytes.Buffer
+		p := &poster{}
+		p.initCommon(3, version)
+		p.head, p.total, p.current = 77, 5, 1<<40+9
+		if err := p.writeIix(&buf, version); err != nil {
+			t.Fatal(err)
+		}
+		q := &poster{}
+		if err := q.initAppend(&buf, 3, version); err != nil {
+			t.Fatal(err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("version %d: %d bytes unread", version, buf.Len())
+		}
+		want := p.current
+		if version == Version1 {
+			want &= 0xffffffff
+		}
+		if q.head != p.head || q.total != p.total || q.current != want {
+			t.Errorf("version %d: got %s current %d", version, q, q.current)
+		}
+	}
+}
@@ -25,40 +25,50 @@ type poster struct {
 	link int64
 	head int64
 
-	current uint32
+	current uint64
 	total   uint32
 	posts   []byte
 	buf     []byte
-	blot    uint16
+	blot    uint32
+	version int
+	// the number of check bytes of posts, and the check
+	// of the last post, if it is known.
+	check     int
+	lastCheck uint32
+	hasCheck  bool
 }
 
 const (
 	flushRate = 512
 )
 
-func (p *poster) initCommon(blot uint16) {
+func (p *poster) initCommon(blot uint32, version int) {
 	p.link = -1
 	p.head = -1
-	p.posts = make([]byte, flushRate+2*binary.MaxVarintLen64+8)
+	p.blot = blot
+	p.version = version
+}
+
+// initBuf allocates the buffers of p.  Buffers are
+// allocated on first use, since shards with many blots
+// post to few of them at a time.
+func (p *poster) initBuf() {
+	if p.buf != nil {
+		return
+	}
+	p.posts = make([]byte, flushRate+2*binary.MaxVarintLen64+16)
 	p.buf = p.posts[:binary.MaxVarintLen64]
 	p.posts = p.posts[binary.MaxVarintLen64:]
 	p.posts = p.posts[:0]
-	p.blot = blot
 }
 
-func (p *poster) initCreate(w io.Writer, blot uint16) error {
-	p.initCommon(blot)
-	_, err := p.writeVarint64(w, p.head)
-	if err != nil {
-		return err
-	}
-	var buf [4]byte
-	_, err = w.Write(buf[:])
-	return err
+func (p *poster) initCreate(w io.Writer, blot uint32, version int) error {
+	p.initCommon(blot, version)
+	return p.writeIix(w, version)
 }
 
-func (p *poster) initAppend(r io.ByteReader, blot uint16) error {
-	p.initCommon(blot)
+func (p *poster) initAppend(r io.ByteReader, blot uint32, version int) error {
+	p.initCommon(blot, version)
 	var err error
 	p.head, err = binary.ReadVarint(r)
 	if err != nil {
@@ -70,28 +80,32 @@ func (p *poster) initAppend(r io.ByteReader, blot uint16) error {
 		return fmt.Errorf("invalid total: %d", ttl)
 	}
 	p.total = uint32(ttl)
-	var buf [4]byte
-	for i := range buf {
-		buf[i], err = r.ReadByte()
-		if err != nil {
-			return err
-		}
-	}
-	p.current = binary.BigEndian.Uint32(buf[:])
+	p.current, err = readDocid(r, version)
 
 	return err
 }
 
-func (p *poster) AddPost(v uint32, f *os.File) error {
-	if p.current == v && p.total != 0 {
+// AddPost adds a post of the document v with check,
+// unless it repeats the last post.
+func (p *poster) AddPost(v uint64, check uint32, f *os.File) error {
+	if p.current == v && p.total != 0 && (p.check == 0 || p.hasCheck && p.lastCheck == check) {
 		return nil
 	}
 
+	p.initBuf()
+	if p.total == 0 || v != p.current {
+		// the count is of documents, which may post
+		// several checks.
+		p.total++
+	}
 	delta := v - p.current
 	p.current = v
-	p.total++
-	n := binary.PutUvarint(p.buf, uint64(delta))
+	n := binary.PutUvarint(p.buf, delta)
 	p.posts = append(p.posts, p.buf[:n]...)
+	if p.check != 0 {
+		p.posts = putCheck(p.posts, check, p.check)
+		p.lastCheck, p.hasCheck = check, true
+	}
 	if len(p.posts) >= flushRate {
 		return p.flushTo(f)
 	}
@@ -104,14 +118,18 @@ func (p *poster) String() string {
 
 }
 
-func (p *poster) readToLink(f *os.File) error {
+// readToLink follows the chunks of p in f to the last
+// one, setting p.link to the position of its link.  Links
+// to chunks at or after end are cut, so that the last chunk
+// is the last one before end.
+func (p *poster) readToLink(f *os.File, end int64) error {
 	if p.head == -1 {
 		return nil
 	}
 	if p.link != -1 {
 		panic(p.link)
 	}
-	buf := make([]byte, flushRate+binary.MaxVarintLen64+8+10)
+	buf := make([]byte, flushRate+binary.MaxVarintLen64+12+10)
 	head := p.head
 	for {
 		v, n, err := readVarintAt(f, head)
@@ -121,7 +139,7 @@ func (p *poster) readToLink(f *os.File) error {
 		if v > int64(len(buf)) {
 			buf = make([]byte, v*2)
 		}
-		if v <= 8 {
+		if v <= int64(chunkTrailer(p.version)) {
 			return fmt.Errorf("%s: invalid length of flushed post buffer: %d", p, v)
 		}
 		_, err = f.ReadAt(buf[:v], head+n)
@@ -129,9 +147,23 @@ func (p *poster) readToLink(f *os.File) error {
 			return err
 		}
 		link := int64(binary.BigEndian.Uint64(buf[v-8 : v]))
+		if (link == -1 || link >= end) && p.check != 0 {
+			// the chunk ends with the check of its last
+			// post.
+			n := int(v) - chunkTrailer(p.version)
+			if n < p.check+1 {
+				return fmt.Errorf("%s: chunk at %d too short for its check bytes", p, head)
+			}
+			p.lastCheck, p.hasCheck = getCheck(buf[n-p.check:n], p.check), true
+		}
 		if link == -1 {
 			p.link = head + n + v - 8
 			return nil
+		} else if link >= end {
+			p.link = head + n + v - 8
+			binary.BigEndian.PutUint64(buf[:8], ^uint64(0))
+			_, err = f.WriteAt(buf[:8], p.link)
+			return err
 		} else {
 			head = link
 		}
@@ -139,11 +171,53 @@ func (p *poster) readToLink(f *os.File) error {
 }
 
 func (p *poster) writeVarint64(w io.Writer, v int64) (int, error) {
-	n := binary.PutVarint(p.buf, v)
-	_, err := w.Write(p.buf[:n])
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(buf[:], v)
+	_, err := w.Write(buf[:n])
 	return n, err
 }
 
+// writeIix writes the iix entry of p: its head, total
+// and current docid.
+func (p *poster) writeIix(w io.Writer, version int) error {
+	_, err := p.writeVarint64(w, p.head)
+	if err != nil {
+		return err
+	}
+	_, err = p.writeVarint64(w, int64(p.total))
+	if err != nil {
+		return err
+	}
+	var buf [8]byte
+	n := docidSize(version)
+	if n == 4 {
+		binary.BigEndian.PutUint32(buf[:], uint32(p.current))
+	} else {
+		binary.BigEndian.PutUint64(buf[:], p.current)
+	}
+	_, err = w.Write(buf[:n])
+	return err
+}
+
+// readDocid reads the current docid of an iix entry.
+func readDocid(r io.ByteReader, version int) (uint64, error) {
+	var (
+		buf [8]byte
+		err error
+	)
+	n := docidSize(version)
+	for i := range buf[:n] {
+		buf[i], err = r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+	}
+	if n == 4 {
+		return uint64(binary.BigEndian.Uint32(buf[:4])), nil
+	}
+	return binary.BigEndian.Uint64(buf[:]), nil
+}
+
 func (p *poster) flushTo(f *os.File) error {
 	if len(p.posts) == 0 {
 		return nil
@@ -167,13 +241,9 @@ func (p *poster) flushTo(f *os.File) error {
 	if err != nil {
 		return err
 	}
-	var z [8]byte
-	var nolink = int64(-1)
-	binary.BigEndian.PutUint64(z[:], uint64(nolink))
-	p.posts = append(p.posts, z[:]...)
-
-	lenSz, err := p.writeVarint64(f, int64(len(p.posts)))
-	if err != nil {
+	var lenBuf []byte
+	p.posts, lenBuf = sealChunk(p.posts, p.buf, p.version)
+	if _, err := f.Write(lenBuf); err != nil {
 		return err
 	}
 	zz, err := f.Write(p.posts)
@@ -181,9 +251,9 @@ func (p *poster) flushTo(f *os.File) error {
 		return err
 	}
 	if zz != len(p.posts) {
-		panic(z)
+		panic(zz)
 	}
-	p.link = end + int64(lenSz) + int64(len(p.posts)) - 8
+	p.link = end + int64(len(lenBuf)) + int64(len(p.posts)) - 8
 	p.posts = p.posts[:0]
 	return nil
 }
@@ -18,20 +18,39 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
+	"os"
+
+	"github.com/go-air/dupi/internal/format"
+	"github.com/go-air/dupi/internal/mmap"
 )
 
 type Posts struct {
 	i       int
 	nextpos int64
-	current uint32
-	docids  []uint32
+	current uint64
+	docids  []uint64
+	// the check of each docid, if check is not 0.
+	checks []uint32
+	check  int
+	// the number of posts read by nextCheck and the
+	// last docid.
+	read    int
+	last    uint64
 	buf     []byte
 	vbuf    []byte
+	version int
+	path    string
+	// the size of the posting file, as last known.
+	size int64
 }
 
-func newPosts(head int64) *Posts {
-	res := &Posts{}
+// newPosts returns the posts starting at head in the
+// posting file at path of size bytes, of a shard with
+// format version and check bytes per post.
+func newPosts(head, size int64, version, check int, path string) *Posts {
+	res := &Posts{version: version, check: check, path: path, size: size}
 	res.init(head)
 	return res
 }
@@ -39,7 +58,10 @@ func newPosts(head int64) *Posts {
 func (p *Posts) init(head int64) {
 	p.i = 0
 	p.nextpos = head
-	p.docids = make([]uint32, 0, flushRate)
+	p.docids = make([]uint64, 0, flushRate)
+	if p.check != 0 {
+		p.checks = make([]uint32, 0, flushRate)
+	}
 	p.buf = make([]byte, flushRate+2*binary.MaxVarintLen64+8)
 	p.vbuf = p.buf[:binary.MaxVarintLen64]
 	p.buf = p.buf[binary.MaxVarintLen64:]
@@ -71,56 +93,162 @@ func readVarintAt(r io.ReaderAt, p int64) (int64, int64, error) {
 	return v, n, nil
 }
 
-func (p *Posts) next(r io.ReaderAt) (uint32, error) {
+// next returns the next docid of p.  A document with
+// several checks for the blot of p is returned once.
+func (p *Posts) next(r io.ReaderAt) (uint64, error) {
+	for {
+		n, last := p.read, p.last
+		did, _, err := p.nextCheck(r)
+		if err != nil {
+			return 0, err
+		}
+		if n == 0 || did != last {
+			return did, nil
+		}
+	}
+}
+
+// nextCheck returns the next post of p: its docid and
+// check, which is 0 if p has no check bytes.
+func (p *Posts) nextCheck(r io.ReaderAt) (uint64, uint32, error) {
 	if p.i == len(p.docids) {
 		if p.nextpos == -1 {
-			return 0, io.EOF
+			return 0, 0, io.EOF
 		}
 		err := p.readNext(r)
 		if err != nil {
-			return 0, err
+			return 0, 0, err
 		}
 	}
-	res := p.docids[p.i]
+	did := p.docids[p.i]
+	var check uint32
+	if p.check != 0 {
+		check = p.checks[p.i]
+	}
 	p.i++
-	return res, nil
+	p.read++
+	p.last = did
+	return did, check, nil
 }
 
 func (p *Posts) readNext(r io.ReaderAt) error {
-	v, n, err := readVarintAt(r, p.nextpos)
-	if err != nil {
-		return fmt.Errorf("1 %w\n", err)
-	}
-	_, err = r.ReadAt(p.buf[:v], p.nextpos+n)
+	v, buf, err := p.readChunk(r)
 	if err != nil {
-		return fmt.Errorf("2 %w\n", err)
+		return err
 	}
 	var (
 		t, i int
 		d    uint64
-		buf  = p.buf[:v]
+		end  = len(buf) - chunkTrailer(p.version)
 	)
+	if p.version >= Version3 {
+		m := binary.PutVarint(p.vbuf, v)
+		crc := crc32.Update(crc32.Checksum(p.vbuf[:m], format.Table), format.Table, buf[:end])
+		if got := binary.BigEndian.Uint32(buf[end:]); got != crc {
+			return format.Corrupt(p.path, "chunk at %d has checksum %08x, want %08x", p.nextpos, got, crc)
+		}
+	}
 	p.docids = p.docids[:0]
+	p.checks = p.checks[:0]
 
 	for {
 		d, t = binary.Uvarint(buf[i:])
 		if t <= 0 {
-			return fmt.Errorf("error decoding uvarint delta: %#v", buf[i:])
+			return format.Corrupt(p.path, "chunk at %d: error decoding delta at %d", p.nextpos, i)
 		}
 		i += t
-		if (d & 0xffffffff) != d {
-			return fmt.Errorf("32bit uvarint overflow: %x", d)
-		}
-		p.current += uint32(d)
+		p.current += d
 		p.docids = append(p.docids, p.current)
-		if i > len(buf)-8 {
-			return fmt.Errorf("misaligned")
+		if p.check != 0 {
+			if i+p.check > end {
+				return format.Corrupt(p.path, "chunk at %d: check bytes overlap its link", p.nextpos)
+			}
+			p.checks = append(p.checks, getCheck(buf[i:], p.check))
+			i += p.check
+		}
+		if i > end {
+			return format.Corrupt(p.path, "chunk at %d: deltas overlap its link", p.nextpos)
 		}
-		if i == len(buf)-8 {
+		if i == end {
 			break
 		}
 	}
-	p.nextpos = int64(binary.BigEndian.Uint64(buf[i:]))
+	p.nextpos = int64(binary.BigEndian.Uint64(buf[len(buf)-8:]))
 	p.i = 0
 	return nil
 }
+
+// readChunk reads the chunk at p.nextpos from r,
+// returning its length and its contents after the length.
+// Chunks in the mapped part of a memory mapped posting file
+// are not copied.
+func (p *Posts) readChunk(r io.ReaderAt) (int64, []byte, error) {
+	if m, ok := r.(*mmap.File); ok {
+		data := m.Bytes()
+		if p.nextpos < int64(len(data)) {
+			v, n := binary.Varint(data[p.nextpos:])
+			start := p.nextpos + int64(n)
+			if n > 0 && v > int64(chunkTrailer(p.version)) && start+v <= int64(len(data)) {
+				return v, data[start : start+v], nil
+			}
+			// bad chunks and chunks past the end of the
+			// map are read as usual.
+		}
+	}
+	if ok, err := p.within(r, p.nextpos, 1); err != nil {
+		return 0, nil, err
+	} else if !ok {
+		return 0, nil, format.Corrupt(p.path, "chunk at %d is past the end %d of the posting file", p.nextpos, p.size)
+	}
+	v, n, err := readVarintAt(r, p.nextpos)
+	if err != nil {
+		return 0, nil, p.readErr(fmt.Errorf("reading length of chunk at %d: %w", p.nextpos, err))
+	}
+	if v <= int64(chunkTrailer(p.version)) {
+		return 0, nil, format.Corrupt(p.path, "chunk at %d has invalid length %d", p.nextpos, v)
+	}
+	if ok, err := p.within(r, p.nextpos+n, v); err != nil {
+		return 0, nil, err
+	} else if !ok {
+		return 0, nil, format.Corrupt(p.path, "chunk at %d has length %d past the end %d of the posting file",
+			p.nextpos, v, p.size)
+	}
+	if v > int64(len(p.buf)) {
+		// compacted chunks hold a whole posting list.
+		p.buf = make([]byte, v)
+	}
+	_, err = r.ReadAt(p.buf[:v], p.nextpos+n)
+	if err != nil {
+		return 0, nil, p.readErr(fmt.Errorf("reading chunk at %d: %w", p.nextpos, err))
+	}
+	return v, p.buf[:v], nil
+}
+
+// within returns whether the n bytes at off are in the
+// posting file read by r.  As posts may be appended to the
+// file after p was created, the file is statted again if
+// they are past its last known size.
+func (p *Posts) within(r io.ReaderAt, off, n int64) (bool, error) {
+	if n <= p.size-off {
+		return true, nil
+	}
+	st, ok := r.(interface{ Stat() (os.FileInfo, error) })
+	if !ok {
+		return false, nil
+	}
+	fi, err := st.Stat()
+	if err != nil {
+		return false, err
+	}
+	p.size = fi.Size()
+	return n <= p.size-off, nil
+}
+
+// readErr returns err, as an ErrCorrupt error if it is
+// from reading past the end of the posting file.
+func (p *Posts) readErr(err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return format.Corrupt(p.path, "%v", err)
+	}
+	return err
+}
@@ -18,16 +18,30 @@ import "io"
 
 type ReadState struct {
 	Shard uint32
-	Blot  uint16
-	At    uint16
+	Blot  uint32
+	At    uint32
 	Total uint32
 	Posts *Posts
 	Error error
 	rdr   io.ReaderAt
 }
 
-func (s *ReadState) Next() (uint32, error) {
-	var docid uint32
+// Next returns the next document with the blot of s.
+func (s *ReadState) Next() (uint64, error) {
+	var docid uint64
 	docid, s.Error = s.Posts.next(s.rdr)
 	return docid, s.Error
 }
+
+// NextCheck returns the next post of the blot of s: its
+// document and the upper bits of its blot, which are 0 if
+// the shard has no check bytes.  A document may have
+// several posts with different checks.
+func (s *ReadState) NextCheck() (uint64, uint32, error) {
+	var (
+		docid uint64
+		check uint32
+	)
+	docid, check, s.Error = s.Posts.nextCheck(s.rdr)
+	return docid, check, s.Error
+}
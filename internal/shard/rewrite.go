@@ -0,0 +1,153 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shard
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/go-air/dupi/internal/format"
+)
+
+// Rewrite writes the posting lists of x to a new shard
+// whose posts are at path, keeping only the docids for
+// which keep returns true.
+//
+// The posts of each blot are written as a single chunk,
+// so that reading a blot's posts takes one read.  The
+// new shard may be appended to as usual and has the format
+// version of x.
+func (x *Index) Rewrite(path string, keep func(docid uint64) bool) error {
+	src := Source{
+		Index: x,
+		Map: func(did uint64) (uint64, bool) {
+			return did, keep(did)
+		}}
+	return Merge(path, x.version, []Source{src})
+}
+
+// Source is a shard to merge, with a function mapping its
+// docids to docids of the merged shard.  Map returns false
+// for docids which are dropped.
+type Source struct {
+	Index *Index
+	Map   func(docid uint64) (uint64, bool)
+}
+
+// Merge writes the posting lists of srcs to a new shard
+// whose posts are at path with format version, as Rewrite
+// does.  The docids of each blot are taken from srcs in
+// order, so Map must be increasing and the docids mapped
+// by a source must be greater than those mapped by the
+// sources before it.  The sources must all have the same
+// number of blots and of check bytes.
+func Merge(path string, version int, srcs []Source) error {
+	if len(srcs) == 0 {
+		return fmt.Errorf("%s: no shards to merge", path)
+	}
+	nBlots, check := srcs[0].Index.tab.n, srcs[0].Index.check
+	for _, src := range srcs[1:] {
+		if src.Index.tab.n != nBlots {
+			return fmt.Errorf("%s: cannot merge shards with %d and %d blots",
+				path, nBlots, src.Index.tab.n)
+		}
+		if src.Index.check != check {
+			return fmt.Errorf("%s: cannot merge shards with %d and %d check bytes",
+				path, check, src.Index.check)
+		}
+	}
+	if err := checkVersion(path, version, check); err != nil {
+		return err
+	}
+	pos, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer pos.Close()
+	iw, err := createIix(fmt.Sprintf("%s.iix", path), version)
+	if err != nil {
+		return err
+	}
+	defer iw.Close()
+	pw := bufio.NewWriter(pos)
+	var (
+		p     poster
+		chunk []byte
+		off   = headerSize(version)
+		vbuf  [binary.MaxVarintLen64]byte
+	)
+	if off != 0 {
+		if _, err := pw.Write(format.Header(format.PostMagic, version)); err != nil {
+			return err
+		}
+	}
+	p.initCommon(0, version)
+	for i := uint32(0); i < nBlots; i++ {
+		p.blot = i
+		p.head, p.current, p.total, p.lastCheck = -1, 0, 0, 0
+		chunk = chunk[:0]
+		for _, src := range srcs {
+			posts := src.Index.posts(i)
+			for {
+				did, ck, err := posts.nextCheck(src.Index.rdr)
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return fmt.Errorf("%s blot %x: %w", src.Index.path, i, err)
+				}
+				did, ok := src.Map(did)
+				if !ok || (p.total != 0 && did == p.current && ck == p.lastCheck) {
+					continue
+				}
+				if p.total != 0 && did < p.current {
+					return fmt.Errorf("%s blot %x: docid %d out of order", src.Index.path, i, did)
+				}
+				n := binary.PutUvarint(vbuf[:], did-p.current)
+				chunk = append(chunk, vbuf[:n]...)
+				chunk = putCheck(chunk, ck, check)
+				if p.total == 0 || did != p.current {
+					p.total++
+				}
+				p.current, p.lastCheck = did, ck
+			}
+		}
+		if p.total != 0 {
+			p.head = off
+			var lenBuf []byte
+			chunk, lenBuf = sealChunk(chunk, vbuf[:], version)
+			if _, err := pw.Write(lenBuf); err != nil {
+				return err
+			}
+			if _, err := pw.Write(chunk); err != nil {
+				return err
+			}
+			off += int64(len(lenBuf) + len(chunk))
+		}
+		if err := p.writeIix(iw, version); err != nil {
+			return err
+		}
+	}
+	if err := pw.Flush(); err != nil {
+		return err
+	}
+	if err := iw.finish(); err != nil {
+		return err
+	}
+	return pos.Sync()
+}
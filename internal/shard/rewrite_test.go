@@ -0,0 +1,98 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shard
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRewrite(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "shard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "b0.pos")
+	var idxr Indexer
+	if err := idxr.InitCreate(0, path, 1024, Version, 10, 0); err != nil {
+		t.Fatal(err)
+	}
+	ps := gen(4000)
+	for _, p := range ps {
+		if err := idxr.ind[7].AddPost(p, 0, idxr.postFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := idxr.Checkpoint(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var wrong Index
+	if err := wrong.Init(path, Version, 9, 0); err == nil {
+		t.Errorf("opened shard with 1<<10 blots with 1<<9")
+		wrong.Close()
+	}
+	var src Index
+	if err := src.Init(path, Version, 10, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	cpath := filepath.Join(tmp, "c0.pos")
+	if err := src.Rewrite(cpath, func(did uint64) bool { return did%2 == 0 }); err != nil {
+		t.Fatal(err)
+	}
+	var dst Index
+	if err := dst.Init(cpath, Version, 10, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+	if dst.NumBlots() != 1<<10 {
+		t.Errorf("rewritten shard has %d blots", dst.NumBlots())
+	}
+	var want []uint64
+	for _, p := range ps {
+		if p%2 == 0 {
+			want = append(want, p)
+		}
+	}
+	if dst.Count(7) != uint32(len(want)) {
+		t.Errorf("count got %d want %d", dst.Count(7), len(want))
+	}
+	rs := dst.ReadStateForBlotAt(7, 0)
+	for i := 0; ; i++ {
+		did, err := rs.Next()
+		if err == io.EOF {
+			if i != len(want) {
+				t.Errorf("got %d docids want %d", i, len(want))
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i >= len(want) || did != want[i] {
+			t.Fatalf("docid %d: got %d", i, did)
+		}
+		if i == 0 && rs.Posts.nextpos != -1 {
+			t.Errorf("rewritten posts not contiguous")
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shard
+
+import "encoding/binary"
+
+// table holds, for every blot of a shard, the head of
+// its posting list and its count, together with the blots
+// ordered by decreasing count and the rank of each blot in
+// that order.  Tables for large blot
+// spaces are big, so they are memory mapped rather than
+// kept on the heap.
+type table struct {
+	mem []byte
+	n   uint32
+}
+
+// each entry of a table is a head (8 bytes), a count
+// (4 bytes), a perm entry (4 bytes) and a rank (4 bytes).
+const entrySize = 20
+
+func newTable(bits int) (*table, error) {
+	n := uint32(1) << uint(bits)
+	mem, err := mapTable(int(n) * entrySize)
+	if err != nil {
+		return nil, err
+	}
+	return &table{mem: mem, n: n}, nil
+}
+
+func (t *table) close() error {
+	if t.mem == nil {
+		return nil
+	}
+	mem := t.mem
+	t.mem = nil
+	return unmapTable(mem)
+}
+
+func (t *table) entry(i uint32) []byte {
+	return t.mem[int(i)*entrySize : (int(i)+1)*entrySize]
+}
+
+func (t *table) head(blot uint32) int64 {
+	return int64(binary.LittleEndian.Uint64(t.entry(blot)))
+}
+
+func (t *table) setHead(blot uint32, hd int64) {
+	binary.LittleEndian.PutUint64(t.entry(blot), uint64(hd))
+}
+
+func (t *table) count(blot uint32) uint32 {
+	return binary.LittleEndian.Uint32(t.entry(blot)[8:])
+}
+
+func (t *table) setCount(blot, ct uint32) {
+	binary.LittleEndian.PutUint32(t.entry(blot)[8:], ct)
+}
+
+// perm gives the i'th blot in order of decreasing count.
+func (t *table) perm(i uint32) uint32 {
+	return binary.LittleEndian.Uint32(t.entry(i)[12:])
+}
+
+func (t *table) setPerm(i, blot uint32) {
+	binary.LittleEndian.PutUint32(t.entry(i)[12:], blot)
+}
+
+// rank gives the position of blot in perm.
+func (t *table) rank(blot uint32) uint32 {
+	return binary.LittleEndian.Uint32(t.entry(blot)[16:])
+}
+
+// setRanks sets the ranks of all blots from perm.
+func (t *table) setRanks() {
+	for i := uint32(0); i < t.n; i++ {
+		binary.LittleEndian.PutUint32(t.entry(t.perm(i))[16:], i)
+	}
+}
+
+// byCount sorts the perm of a table by decreasing count.
+type byCount table
+
+func (t *byCount) Len() int { return int(t.n) }
+
+func (t *byCount) Less(i, j int) bool {
+	tab := (*table)(t)
+	return tab.count(tab.perm(uint32(i))) > tab.count(tab.perm(uint32(j)))
+}
+
+func (t *byCount) Swap(i, j int) {
+	tab := (*table)(t)
+	pi, pj := tab.perm(uint32(i)), tab.perm(uint32(j))
+	tab.setPerm(uint32(i), pj)
+	tab.setPerm(uint32(j), pi)
+}
@@ -0,0 +1,69 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shard
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRank(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "shard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "b0.pos")
+	var idxr Indexer
+	if err := idxr.InitCreate(0, path, 1024, Version, 6, 0); err != nil {
+		t.Fatal(err)
+	}
+	// blot b gets (b*7)%13 posts.
+	for did := uint64(1); did < 13; did++ {
+		for b := range idxr.ind {
+			if uint64(b*7%13) < did {
+				continue
+			}
+			if err := idxr.ind[b].AddPost(did, 0, idxr.postFile); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if _, err := idxr.Checkpoint(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var idx Index
+	if err := idx.Init(path, Version, 6, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	for b := uint32(0); b < uint32(idx.NumBlots()); b++ {
+		r := idx.Rank(b)
+		if idx.BlotAt(r) != b {
+			t.Errorf("blot %d has rank %d, at which is %d", b, r, idx.BlotAt(r))
+		}
+		if r > 0 && idx.Count(idx.BlotAt(r-1)) < idx.Count(b) {
+			t.Errorf("blot %d with count %d at %d follows count %d", b, idx.Count(b), r, idx.Count(idx.BlotAt(r-1)))
+		}
+		if rs := idx.ReadStateFor(b); rs.At != r || rs.Blot != b {
+			t.Errorf("read state for %d is for %d at %d, want at %d", b, rs.Blot, rs.At, r)
+		}
+	}
+}
@@ -0,0 +1,58 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shard
+
+import (
+	"fmt"
+	"os"
+)
+
+// Truncate rolls the shard with posts at path back to a
+// checkpoint at which the posting file had size bytes.
+// The iix of the shard must be that of the checkpoint.
+// Chunks written after the checkpoint are removed, and
+// the links to them from chunks before it are cut.
+func Truncate(path string, size int64, version, bits int) error {
+	var x Indexer
+	x.initCommon(0, path, 0, version, bits, 0)
+	if err := x.readIix(); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() < size {
+		return fmt.Errorf("%s: size %d is less than checkpoint size %d", path, fi.Size(), size)
+	}
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	for i := range x.ind {
+		p := &x.ind[i]
+		if p.head >= size {
+			return fmt.Errorf("%s: blot %x starts at %d, after checkpoint size %d", path, i, p.head, size)
+		}
+		if err := p.readToLink(f, size); err != nil {
+			return fmt.Errorf("%s: blot %x: %w", path, i, err)
+		}
+	}
+	return f.Sync()
+}
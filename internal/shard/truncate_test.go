@@ -0,0 +1,95 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shard
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTruncate(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "shard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "b0.pos")
+	var idxr Indexer
+	if err := idxr.InitCreate(0, path, 1024, Version, 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	ps := gen(4000)
+	for _, p := range ps[:3000] {
+		if err := idxr.ind[3].AddPost(p, 0, idxr.postFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	size, err := idxr.Checkpoint(tmp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// posts after the checkpoint, which a crash loses.
+	for _, p := range ps[3000:] {
+		if err := idxr.ind[3].AddPost(p, 0, idxr.postFile); err != nil {
+			t.Fatal(err)
+		}
+		if err := idxr.ind[5].AddPost(p, 0, idxr.postFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := idxr.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := Truncate(path, size, Version, 4); err != nil {
+		t.Fatal(err)
+	}
+	var idx Index
+	if err := idx.Init(path, Version, 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if idx.Count(5) != 0 {
+		t.Errorf("blot 5 has %d posts after truncation", idx.Count(5))
+	}
+	rs := idx.ReadStateForBlotAt(3, 0)
+	for i := 0; ; i++ {
+		did, err := rs.Next()
+		if err == io.EOF {
+			if i != 3000 {
+				t.Errorf("got %d docids want 3000", i)
+			}
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i >= 3000 || did != ps[i] {
+			t.Fatalf("docid %d: got %d", i, did)
+		}
+	}
+	var app Indexer
+	if err := app.InitAppend(0, path, 1024, Version, 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
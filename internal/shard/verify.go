@@ -0,0 +1,147 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shard
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrCount is the error of problems where the count of a
+// blot in the iix differs from the length of its posting
+// list.
+var ErrCount = errors.New("iix count differs from posting list")
+
+// Problem is a problem with the posting list of a blot
+// found by Verify.
+type Problem struct {
+	Blot uint32
+	Err  error
+	// For count problems, the number of documents of the
+	// posting list and its last docid.
+	Count uint32
+	Last  uint64
+}
+
+// Verify walks the posting list of every blot of x,
+// checking that it can be read, that its docids are
+// strictly increasing and less than numDocs, and that its
+// number of documents is the count of the blot in the iix.
+// With check bytes, a docid may repeat with a different
+// check.  Verify calls
+// problem for every blot with a problem.
+func (x *Index) Verify(numDocs uint64, problem func(p *Problem)) error {
+	fi, err := x.postFile.Stat()
+	if err != nil {
+		return err
+	}
+	for blot := uint32(0); blot < x.tab.n; blot++ {
+		if p := x.verifyBlot(blot, numDocs, fi.Size()); p != nil {
+			problem(p)
+		}
+	}
+	return nil
+}
+
+func (x *Index) verifyBlot(blot uint32, numDocs uint64, size int64) *Problem {
+	var (
+		posts     = x.posts(blot)
+		ct        uint32
+		last      uint64
+		lastCheck uint32
+		chunk     = int64(-1)
+	)
+	for {
+		if posts.i == len(posts.docids) && posts.nextpos != -1 {
+			// links always point forward, to chunks
+			// appended later.
+			switch {
+			case posts.nextpos >= size:
+				return &Problem{Blot: blot, Err: fmt.Errorf("chunk at %d is past the end %d of the posting file",
+					posts.nextpos, size)}
+			case posts.nextpos <= chunk:
+				return &Problem{Blot: blot, Err: fmt.Errorf("chunk at %d links back to %d",
+					chunk, posts.nextpos)}
+			}
+			chunk = posts.nextpos
+		}
+		did, check, err := posts.nextCheck(x.rdr)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return &Problem{Blot: blot, Err: err}
+		}
+		if ct != 0 && (did < last || did == last && (x.check == 0 || check == lastCheck)) {
+			return &Problem{Blot: blot, Err: fmt.Errorf("docid %d follows %d", did, last)}
+		}
+		if did >= numDocs {
+			return &Problem{Blot: blot, Err: fmt.Errorf("docid %d is not less than the number of documents %d",
+				did, numDocs)}
+		}
+		if ct == 0 || did != last {
+			ct++
+		}
+		last, lastCheck = did, check
+	}
+	if want := x.tab.count(blot); ct != want {
+		return &Problem{
+			Blot:  blot,
+			Err:   fmt.Errorf("%w: count %d, list has %d documents", ErrCount, want, ct),
+			Count: ct,
+			Last:  last}
+	}
+	return nil
+}
+
+// RepairCounts rewrites the iix of the shard with posts at
+// path, format version and 1<<bits blots, setting the count
+// and last docid of every blot with a count problem in
+// problems to those of its posting list.
+func RepairCounts(path string, version, bits int, problems []Problem) error {
+	var x Indexer
+	x.initCommon(0, path, 0, version, bits, 0)
+	if err := x.readIix(); err != nil {
+		return err
+	}
+	for i := range problems {
+		p := &problems[i]
+		if !errors.Is(p.Err, ErrCount) {
+			continue
+		}
+		if p.Blot >= uint32(len(x.ind)) {
+			return fmt.Errorf("%s: no blot %x", path, p.Blot)
+		}
+		x.ind[p.Blot].total = p.Count
+		x.ind[p.Blot].current = p.Last
+	}
+	iixName := fmt.Sprintf("%s.iix", path)
+	w, err := createIix(iixName+".tmp", version)
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	for i := range x.ind {
+		if err := x.ind[i].writeIix(w, version); err != nil {
+			return err
+		}
+	}
+	if err := w.finish(); err != nil {
+		return err
+	}
+	return os.Rename(iixName+".tmp", iixName)
+}
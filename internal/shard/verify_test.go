@@ -0,0 +1,104 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package shard
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func verifyShard(t *testing.T, path string, numDocs uint64) []Problem {
+	t.Helper()
+	var idx Index
+	if err := idx.Init(path, Version, 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	var res []Problem
+	if err := idx.Verify(numDocs, func(p *Problem) { res = append(res, *p) }); err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func TestVerify(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "shard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "b0.pos")
+	var idxr Indexer
+	if err := idxr.InitCreate(0, path, 1024, Version, 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	ps := gen(2000)
+	for _, p := range ps {
+		if err := idxr.ind[3].AddPost(p, 0, idxr.postFile); err != nil {
+			t.Fatal(err)
+		}
+		if err := idxr.ind[9].AddPost(p, 0, idxr.postFile); err != nil {
+			t.Fatal(err)
+		}
+	}
+	idxr.ind[9].total += 2
+	if _, err := idxr.Checkpoint(tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	numDocs := ps[len(ps)-1] + 1
+	problems := verifyShard(t, path, numDocs)
+	if len(problems) != 1 || problems[0].Blot != 9 || !errors.Is(problems[0].Err, ErrCount) {
+		t.Fatalf("got problems %v", problems)
+	}
+	if problems[0].Count != 2000 || problems[0].Last != ps[len(ps)-1] {
+		t.Errorf("count problem %+v", problems[0])
+	}
+	if got := verifyShard(t, path, numDocs-1); len(got) != 2 {
+		t.Errorf("docids out of range gave %v", got)
+	}
+	if err := RepairCounts(path, Version, 4, problems); err != nil {
+		t.Fatal(err)
+	}
+	if got := verifyShard(t, path, numDocs); len(got) != 0 {
+		t.Errorf("repaired shard has problems %v", got)
+	}
+	// a link to a later chunk which points back.
+	var idx Index
+	if err := idx.Init(path, Version, 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	head := idx.tab.head(3)
+	idx.Close()
+	var app Indexer
+	if err := app.InitAppend(0, path, 1024, Version, 4, 0); err != nil {
+		t.Fatal(err)
+	}
+	var link [8]byte
+	binary.BigEndian.PutUint64(link[:], uint64(head))
+	if _, err := app.postFile.WriteAt(link[:], app.ind[3].link); err != nil {
+		t.Fatal(err)
+	}
+	app.Close()
+	if got := verifyShard(t, path, numDocs); len(got) != 1 || got[0].Blot != 3 {
+		t.Errorf("cyclic list gave %v", got)
+	}
+}
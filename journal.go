@@ -0,0 +1,360 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/go-air/dupi/dmd"
+	"github.com/go-air/dupi/internal/format"
+	"github.com/go-air/dupi/internal/shard"
+	"github.com/go-air/dupi/lock"
+	"github.com/go-air/dupi/minhash"
+	"github.com/go-air/dupi/simhash"
+)
+
+// An Indexer checkpoints the index it adds to every
+// CheckpointRate documents and when it is closed.  A
+// checkpoint
+//
+//  1. flushes all posts, document records, MinHash
+//     signatures and SimHash fingerprints to disk;
+//  2. writes the iix of every shard and the file names
+//     to the directory ckpt.<seq> in the index root;
+//  3. appends a record of the checkpoint, with the number
+//     of documents and the size of each posting file, to
+//     the journal and syncs it;
+//  4. moves the files of ckpt.<seq> into the index root.
+//
+// A checkpoint is complete once its record is in the
+// journal.  Posting files, the dmd and the signatures
+// are only appended to, so Recover can roll an index back
+// to its last checkpoint by completing step 4 and
+// truncating them.
+const (
+	// ckptKind records a checkpoint of an index which
+	// is still being added to.
+	ckptKind = 1
+	// closeKind records the checkpoint made when an
+	// indexer closes.
+	closeKind = 2
+
+	journalMagic = 0x646a6e6c
+)
+
+// checkpoint is a record in the journal.
+type checkpoint struct {
+	kind uint32
+	seq  uint64
+	// number of dmd records, including the header.
+	docs     uint64
+	posSizes []int64
+}
+
+func (ck *checkpoint) encode() []byte {
+	buf := make([]byte, 28+8*len(ck.posSizes)+4)
+	binary.BigEndian.PutUint32(buf[0:], journalMagic)
+	binary.BigEndian.PutUint32(buf[4:], ck.kind)
+	binary.BigEndian.PutUint64(buf[8:], ck.seq)
+	binary.BigEndian.PutUint64(buf[16:], ck.docs)
+	binary.BigEndian.PutUint32(buf[24:], uint32(len(ck.posSizes)))
+	for i, sz := range ck.posSizes {
+		binary.BigEndian.PutUint64(buf[28+8*i:], uint64(sz))
+	}
+	n := len(buf) - 4
+	binary.BigEndian.PutUint32(buf[n:], crc32.Checksum(buf[:n], format.Table))
+	return buf
+}
+
+// readCheckpoint reads a checkpoint from r.  A record
+// which is torn or fails its checksum gives
+// io.ErrUnexpectedEOF.
+func readCheckpoint(r io.Reader) (*checkpoint, error) {
+	var hdr [28]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, err
+	}
+	if binary.BigEndian.Uint32(hdr[0:]) != journalMagic {
+		return nil, io.ErrUnexpectedEOF
+	}
+	n := binary.BigEndian.Uint32(hdr[24:])
+	if n > 1<<20 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, 28+8*int(n)+4)
+	copy(buf, hdr[:])
+	if _, err := io.ReadFull(r, buf[28:]); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	m := len(buf) - 4
+	if crc32.Checksum(buf[:m], format.Table) != binary.BigEndian.Uint32(buf[m:]) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	ck := &checkpoint{
+		kind:     binary.BigEndian.Uint32(buf[4:]),
+		seq:      binary.BigEndian.Uint64(buf[8:]),
+		docs:     binary.BigEndian.Uint64(buf[16:]),
+		posSizes: make([]int64, n)}
+	for i := range ck.posSizes {
+		ck.posSizes[i] = int64(binary.BigEndian.Uint64(buf[28+8*i:]))
+	}
+	return ck, nil
+}
+
+// readJournal returns the last complete checkpoint in
+// the journal at path, or nil if there is no journal.
+// Indices created before journals have none.
+func readJournal(path string) (*checkpoint, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r := bufio.NewReader(f)
+	var last *checkpoint
+	for {
+		ck, err := readCheckpoint(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			// a torn record was never committed.
+			if last == nil {
+				return nil, fmt.Errorf("%s: no complete checkpoint", path)
+			}
+			return last, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		last = ck
+	}
+}
+
+// journal is the journal of an index being added to.
+type journal struct {
+	f *os.File
+}
+
+// startJournal replaces the journal of cfg with one
+// containing only ck.
+func startJournal(cfg *Config, ck *checkpoint) (*journal, error) {
+	tmp := cfg.JournalPath() + ".tmp"
+	if err := writeSynced(tmp, ck.encode()); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(tmp, cfg.JournalPath()); err != nil {
+		return nil, err
+	}
+	if err := syncDir(cfg.IndexRoot); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(cfg.JournalPath(), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &journal{f: f}, nil
+}
+
+// commit appends ck to j and syncs it.
+func (j *journal) commit(ck *checkpoint) error {
+	if _, err := j.f.Write(ck.encode()); err != nil {
+		return err
+	}
+	return j.f.Sync()
+}
+
+func (j *journal) Close() error {
+	return j.f.Close()
+}
+
+func writeSynced(path string, d []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Write(d); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// applyCheckpoint moves the files written by the
+// checkpoint at dir into root and removes dir.
+func applyCheckpoint(root, dir string) error {
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, ent := range ents {
+		if err := os.Rename(filepath.Join(dir, ent.Name()), filepath.Join(root, ent.Name())); err != nil {
+			return err
+		}
+	}
+	if err := syncDir(root); err != nil {
+		return err
+	}
+	return os.RemoveAll(dir)
+}
+
+// checkpointDirs returns the sequence numbers of the
+// checkpoint directories under root.
+func checkpointDirs(root string) ([]uint64, error) {
+	ents, err := os.ReadDir(root)
+	if err != nil {
+		return nil, err
+	}
+	var res []uint64
+	for _, ent := range ents {
+		name := ent.Name()
+		if !ent.IsDir() || !strings.HasPrefix(name, "ckpt.") {
+			continue
+		}
+		seq, err := strconv.ParseUint(name[len("ckpt."):], 10, 64)
+		if err != nil {
+			continue
+		}
+		res = append(res, seq)
+	}
+	return res, nil
+}
+
+// ErrNeedsRecovery is returned when adding to an index
+// which was not closed and could not be recovered,
+// because another program is using it.
+var ErrNeedsRecovery = errors.New("index needs recovery")
+
+// Recover rolls the index at root back to its last
+// checkpoint if the program adding to it stopped without
+// closing it.  Recover returns the number of documents
+// added after the checkpoint which were lost.  Documents
+// whose records never reached disk are not counted.
+//
+// Recover does nothing if another program is using the
+// index.  OpenIndex and OpenIndexer also recover the index.
+func Recover(root string) (lost uint64, err error) {
+	cfg, err := ReadConfigFromRoot(root)
+	if err != nil {
+		return 0, err
+	}
+	need, err := needsRecovery(cfg)
+	if err != nil || !need {
+		return 0, err
+	}
+	lk, err := lock.New(cfg.LockPath())
+	if err != nil {
+		return 0, err
+	}
+	defer lk.Close()
+	ok, err := lk.TryLock()
+	if err != nil || !ok {
+		// the index is in use, perhaps by its indexer.
+		return 0, err
+	}
+	return rollBack(cfg)
+}
+
+// needsRecovery returns whether the index of cfg was not
+// closed by its last indexer, or has checkpoint
+// directories left over.
+func needsRecovery(cfg *Config) (bool, error) {
+	ck, err := readJournal(cfg.JournalPath())
+	if err != nil || ck == nil {
+		return false, err
+	}
+	dirs, err := checkpointDirs(cfg.IndexRoot)
+	if err != nil {
+		return false, err
+	}
+	return ck.kind != closeKind || len(dirs) != 0, nil
+}
+
+// rollBack does the work of Recover once the lock of the
+// index of cfg is held exclusively.
+func rollBack(cfg *Config) (lost uint64, err error) {
+	// the indexer may have closed before we locked.
+	ck, err := readJournal(cfg.JournalPath())
+	if err != nil || ck == nil {
+		return 0, err
+	}
+	dirs, err := checkpointDirs(cfg.IndexRoot)
+	if err != nil {
+		return 0, err
+	}
+	for _, seq := range dirs {
+		dir := cfg.checkpointPath(seq)
+		if seq == ck.seq {
+			err = applyCheckpoint(cfg.IndexRoot, dir)
+		} else {
+			err = os.RemoveAll(dir)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if ck.kind == closeKind {
+		return 0, nil
+	}
+	if len(ck.posSizes) != cfg.NumShards {
+		return 0, fmt.Errorf("%s: checkpoint has %d shards, not %d",
+			cfg.JournalPath(), len(ck.posSizes), cfg.NumShards)
+	}
+	for i, size := range ck.posSizes {
+		if err := shard.Truncate(cfg.PostPath(i), size, cfg.FormatVersion(), cfg.blotBits()); err != nil {
+			return 0, fmt.Errorf("error recovering shard %d: %w", i, err)
+		}
+	}
+	lost, err = dmd.Truncate(cfg.IndexRoot, ck.docs)
+	if err != nil {
+		return 0, err
+	}
+	// deletes are not checkpointed, so those of lost
+	// documents would apply to the ids of later ones.
+	if _, err := dmd.TruncateTombs(cfg.IndexRoot, cfg.didSize(), ck.docs); err != nil {
+		return 0, err
+	}
+	if cfg.MinHash != 0 {
+		if err := minhash.Truncate(cfg.MinHashPath(), cfg.MinHash, ck.docs); err != nil {
+			return 0, err
+		}
+	}
+	if cfg.SimHash {
+		if err := simhash.Truncate(cfg.SimHashPath(), ck.docs); err != nil {
+			return 0, err
+		}
+	}
+	ck.kind = closeKind
+	ck.seq++
+	j, err := startJournal(cfg, ck)
+	if err != nil {
+		return 0, err
+	}
+	if err := j.Close(); err != nil {
+		return 0, err
+	}
+	log.Printf("dupi: recovered %s to its last checkpoint, losing %d documents", cfg.IndexRoot, lost)
+	return lost, nil
+}
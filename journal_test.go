@@ -0,0 +1,302 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-air/dupi/lock"
+)
+
+var journalTestDocs = []string{
+	"the quick brown fox jumps over the lazy dog while the cat sleeps on the mat all day long again.",
+	"nothing to see here except words which are not duplicated anywhere else in this small corpus.",
+}
+
+// crash abandons x as if its program stopped after the
+// posts of the documents added to x were written, but
+// before they were checkpointed.
+func crash(t *testing.T, x *Indexer) {
+	t.Helper()
+	x.mono.wait(x.dmds.Last())
+	for i := range x.shards {
+		if _, err := x.shards[i].Flush(); err != nil {
+			t.Fatal(err)
+		}
+		x.shards[i].Close()
+	}
+	if err := x.dmds.Sync(); err != nil {
+		t.Fatal(err)
+	}
+	if x.sigs != nil {
+		if err := x.sigs.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	x.journal.Close()
+	x.lock.Close()
+}
+
+func addDocs(t *testing.T, x *Indexer, docs []string) []string {
+	t.Helper()
+	var paths []string
+	for _, d := range docs {
+		f, err := ioutil.TempFile(filepath.Dir(x.Root()), "doc")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(d)
+		f.Close()
+		if err := x.Add(&Doc{Path: f.Name(), End: uint64(len(d)), Dat: []byte(d)}); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, f.Name())
+	}
+	return paths
+}
+
+func TestRecover(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "dupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.Join(tmp, "dupi")
+	cfg, err := NewConfig(root, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.CheckpointRate = len(queryTestDocs)
+	cfg.MinHash = 16
+	idxr, err := IndexerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// checkpoints after the last of queryTestDocs.
+	addDocs(t, idxr, queryTestDocs)
+	addDocs(t, idxr, journalTestDocs)
+	crash(t, idxr)
+
+	lk, err := lock.New(root + ".lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lk.LockShared(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenIndexer(root); !errors.Is(err, ErrNeedsRecovery) {
+		t.Errorf("opening crashed index in use gave %v", err)
+	}
+	lk.Close()
+
+	lost, err := Recover(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lost != uint64(len(journalTestDocs)) {
+		t.Errorf("lost %d docs want %d", lost, len(journalTestDocs))
+	}
+	if lost, err = Recover(root); err != nil || lost != 0 {
+		t.Errorf("recovering recovered index lost %d: %v", lost, err)
+	}
+	want := indexBlots(t, testIndex(t, queryTestDocs))
+	if got := indexBlots(t, root); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("recovered blots got %x want %x", got, want)
+	}
+
+	idxr, err = OpenIndexer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addDocs(t, idxr, journalTestDocs)
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	all := append(append([]string(nil), queryTestDocs...), journalTestDocs...)
+	want = indexBlots(t, testIndex(t, all))
+	if got := indexBlots(t, root); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("blots after recovery got %x want %x", got, want)
+	}
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if ps, err := idx.Verify(); err != nil || len(ps) != 0 {
+		t.Errorf("recovered index has problems %v %v", ps, err)
+	}
+}
+
+func TestRecoverDeleted(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "dupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.Join(tmp, "dupi")
+	cfg, err := NewConfig(root, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.CheckpointRate = len(queryTestDocs)
+	idxr, err := IndexerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addDocs(t, idxr, queryTestDocs)
+	paths := addDocs(t, idxr, journalTestDocs)
+	if n, err := idxr.Delete(paths[0]); err != nil || n != 1 {
+		t.Fatalf("deleted %d docs: %v", n, err)
+	}
+	crash(t, idxr)
+	if _, err := Recover(root); err != nil {
+		t.Fatal(err)
+	}
+
+	idxr, err = OpenIndexer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addDocs(t, idxr, journalTestDocs)
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	st, err := idx.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.NumDeleted != 0 {
+		t.Errorf("recovered index has %d deleted docs", st.NumDeleted)
+	}
+	all := append(append([]string(nil), queryTestDocs...), journalTestDocs...)
+	want := indexBlots(t, testIndex(t, all))
+	if got := indexBlots(t, root); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("blots after recovery got %x want %x", got, want)
+	}
+}
+
+func TestOpenIndexRecover(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "dupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.Join(tmp, "dupi")
+	cfg, err := NewConfig(root, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.CheckpointRate = len(queryTestDocs)
+	idxr, err := IndexerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addDocs(t, idxr, queryTestDocs)
+	addDocs(t, idxr, journalTestDocs)
+	crash(t, idxr)
+	numDocs := func(idx *Index) uint64 {
+		t.Helper()
+		st, err := idx.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return st.NumDocs
+	}
+
+	// another user of the index keeps it as it is.
+	lk, err := lock.New(root + ".lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lk.LockShared(); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	all := numDocs(idx)
+	idx.Close()
+	lk.Close()
+
+	idx, err = OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if n, want := numDocs(idx), all-uint64(len(journalTestDocs)); n != want {
+		t.Errorf("recovered index has %d docs want %d", n, want)
+	}
+	// the open index holds its lock shared.
+	if lost, err := Recover(root); err != nil || lost != 0 {
+		t.Errorf("recovering open index lost %d: %v", lost, err)
+	}
+	lk, err = lock.New(root + ".lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lk.Close()
+	if ok, err := lk.TryLock(); err != nil || ok {
+		t.Errorf("locked open index exclusively: %v", err)
+	}
+}
+
+func TestJournalTorn(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "dupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cfg := &Config{IndexRoot: tmp}
+	j, err := startJournal(cfg, &checkpoint{kind: ckptKind, seq: 1, docs: 3, posSizes: []int64{5, 7}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := j.commit(&checkpoint{kind: closeKind, seq: 2, docs: 4, posSizes: []int64{9, 11}}); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	ck, err := readJournal(cfg.JournalPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ck.seq != 2 || ck.kind != closeKind || ck.posSizes[1] != 11 {
+		t.Errorf("last checkpoint %+v", ck)
+	}
+	fi, err := os.Stat(cfg.JournalPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(cfg.JournalPath(), fi.Size()-3); err != nil {
+		t.Fatal(err)
+	}
+	ck, err = readJournal(cfg.JournalPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ck.seq != 1 || ck.kind != ckptKind || ck.docs != 3 {
+		t.Errorf("torn journal gave %+v", ck)
+	}
+}
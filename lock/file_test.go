@@ -18,6 +18,7 @@ import (
 	"io/ioutil"
 	"os"
 	"testing"
+	"time"
 )
 
 func TestLock(t *testing.T) {
@@ -36,3 +37,138 @@ func TestLock(t *testing.T) {
 		os.RemoveAll(name)
 	}()
 }
+
+func TestTryLock(t *testing.T) {
+	f, e := ioutil.TempFile(".", "test.lock")
+	if e != nil {
+		t.Fatal(e)
+	}
+	name := f.Name()
+	f.Close()
+	defer os.RemoveAll(name)
+	a, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.LockShared(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	ok, err := b.TryLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("locked exclusively while shared lock held")
+	}
+	a.Close()
+	ok, err = b.TryLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Errorf("could not lock after shared lock released")
+	}
+}
+
+func TestTryUpgrade(t *testing.T) {
+	f, e := ioutil.TempFile(".", "test.lock")
+	if e != nil {
+		t.Fatal(e)
+	}
+	name := f.Name()
+	f.Close()
+	defer os.RemoveAll(name)
+	a, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.LockShared(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.LockShared(); err != nil {
+		t.Fatal(err)
+	}
+	ok, err := b.TryUpgrade()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Errorf("upgraded while another shared lock held")
+	}
+	// b is still locked shared.
+	if ok, err := a.TryUpgrade(); err != nil || ok {
+		t.Errorf("upgraded while shared lock kept after failed upgrade: %v", err)
+	}
+	a.Close()
+	if ok, err = b.TryUpgrade(); err != nil || !ok {
+		t.Fatalf("could not upgrade after other shared lock released: %v", err)
+	}
+	c, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if ok, err := c.TryLock(); err != nil || ok {
+		t.Errorf("locked while upgraded lock held: %v", err)
+	}
+	if err := b.Downgrade(); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.LockShared(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	f, e := ioutil.TempFile(".", "test.lock")
+	if e != nil {
+		t.Fatal(e)
+	}
+	name := f.Name()
+	f.Close()
+	defer os.RemoveAll(name)
+	a, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := a.LockShared(); err != nil {
+		t.Fatal(err)
+	}
+	b, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.LockShared(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() { done <- b.Upgrade() }()
+	select {
+	case err := <-done:
+		t.Fatalf("upgraded while another shared lock held: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	a.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if ok, err := c.TryLock(); err != nil || ok {
+		t.Errorf("locked while upgraded lock held: %v", err)
+	}
+}
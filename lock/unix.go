@@ -25,10 +25,54 @@ func (f *File) Lock() error {
 	return unix.Flock(int(f.handle.Fd()), unix.LOCK_EX)
 }
 
+// Unlock unlocks f.  It must not lock f shared instead:
+// that would wait for others upgrading their shared lock,
+// who wait for f to unlock.
 func (f *File) Unlock() error {
-	return unix.Flock(int(f.handle.Fd()), unix.LOCK_SH)
+	return unix.Flock(int(f.handle.Fd()), unix.LOCK_UN)
 }
 
 func (f *File) LockShared() error {
 	return unix.Flock(int(f.handle.Fd()), unix.LOCK_SH)
 }
+
+// TryLock attempts to lock f exclusively without waiting.
+// It returns false if the lock is held by another.
+func (f *File) TryLock() (bool, error) {
+	err := unix.Flock(int(f.handle.Fd()), unix.LOCK_EX|unix.LOCK_NB)
+	if err == unix.EWOULDBLOCK {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// TryUpgrade attempts to lock f, which is locked shared,
+// exclusively without waiting.  It returns false if the
+// lock is held by another, in which case f is locked
+// shared again.  The shared lock is released before the
+// exclusive lock is attempted, so others may take the lock
+// in between.
+func (f *File) TryUpgrade() (bool, error) {
+	ok, err := f.TryLock()
+	if err != nil || ok {
+		return ok, err
+	}
+	return false, f.LockShared()
+}
+
+// Upgrade locks f, which is locked shared, exclusively,
+// waiting for others to unlock it.  As with TryUpgrade,
+// the shared lock is released first, so others may take
+// the lock exclusively, and change what it protects,
+// before Upgrade returns.  Where flock upgrades
+// atomically instead, as where it is emulated with fcntl
+// locks on network file systems, two holders upgrading at
+// once may wait for each other forever.
+func (f *File) Upgrade() error {
+	return f.Lock()
+}
+
+// Downgrade locks f, which is locked exclusively, shared.
+func (f *File) Downgrade() error {
+	return f.LockShared()
+}
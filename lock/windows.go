@@ -22,9 +22,10 @@ import (
 )
 
 const (
-	reserved                 = 0
-	allBytes                 = ^uint32(0)
-	_LOCKFILE_EXCLUSIVE_LOCK = 2
+	reserved                   = 0
+	allBytes                   = ^uint32(0)
+	_LOCKFILE_EXCLUSIVE_LOCK   = 2
+	_LOCKFILE_FAIL_IMMEDIATELY = 1
 )
 
 func (f *File) Lock() error {
@@ -42,3 +43,35 @@ func (f *File) Unlock() error {
 func (f *File) LockShared() error {
 	return f.Lock()
 }
+
+// TryLock attempts to lock f exclusively without waiting.
+// It returns false if the lock is held by another.
+func (f *File) TryLock() (bool, error) {
+	ol := new(windows.Overlapped)
+	h := windows.Handle(f.handle.Fd())
+	err := windows.LockFileEx(h, _LOCKFILE_EXCLUSIVE_LOCK|_LOCKFILE_FAIL_IMMEDIATELY,
+		reserved, allBytes, allBytes, ol)
+	if err == windows.ERROR_LOCK_VIOLATION {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// TryUpgrade returns true, since LockShared locks f
+// exclusively.
+func (f *File) TryUpgrade() (bool, error) {
+	return true, nil
+}
+
+// Upgrade does nothing, since LockShared locks f
+// exclusively: locking f again with Lock would wait for
+// itself forever.
+func (f *File) Upgrade() error {
+	return nil
+}
+
+// Downgrade does nothing, since LockShared locks f
+// exclusively.
+func (f *File) Downgrade() error {
+	return nil
+}
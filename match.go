@@ -0,0 +1,68 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import "sort"
+
+// match is a document found by a search for documents
+// like a query document, with its score.
+type match struct {
+	doc   Doc
+	score float64
+}
+
+// matches returns the documents of x with ids in dids,
+// other than doc itself and deleted documents, for which
+// score returns true, with their scores, in decreasing
+// order of score.
+func (x *Index) matches(doc *Doc, dids []uint64, score func(did uint64) (float64, bool, error)) ([]match, error) {
+	// the search tables may be older than the deletes.
+	if err := x.updateDeleted(); err != nil {
+		return nil, err
+	}
+	self, hasSelf := dmdKey{}, false
+	if fid, ok, err := x.fnames.find(doc.Path); err == nil && ok {
+		self, hasSelf = dmdKey{fid, doc.Start, doc.End}, true
+	}
+	var res []match
+	for _, did := range dids {
+		if x.tombs.Has(did) {
+			continue
+		}
+		s, ok, err := score(did)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+		fid, start, end, err := x.dmd.Lookup(did)
+		if err != nil {
+			return nil, err
+		}
+		if hasSelf && (dmdKey{fid, start, end}) == self {
+			continue
+		}
+		m := match{score: s}
+		if err := x.docid2Doc(did, &m.doc); err != nil {
+			return nil, err
+		}
+		res = append(res, m)
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].score > res[j].score
+	})
+	return res, nil
+}
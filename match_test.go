@@ -0,0 +1,223 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// nearTestDocs returns n documents of random words followed
+// by a variant of each of the first m, with a run of words
+// replaced.
+func nearTestDocs(n, m int) []string {
+	r := rand.New(rand.NewSource(3))
+	word := func() string { return fmt.Sprintf("w%d", r.Intn(100000)) }
+	var res []string
+	var words [][]string
+	for i := 0; i < n; i++ {
+		ws := make([]string, 200)
+		for j := range ws {
+			ws[j] = word()
+		}
+		words = append(words, ws)
+		res = append(res, strings.Join(ws, " "))
+	}
+	for i := 0; i < m; i++ {
+		ws := append([]string(nil), words[i]...)
+		for j := 100; j < 105; j++ {
+			ws[j] = word()
+		}
+		res = append(res, strings.Join(ws, " "))
+	}
+	return res
+}
+
+// matchTest is a search for documents like a query
+// document, tested by testMatches.
+type matchTest struct {
+	name string
+	// config makes the index keep what the search needs.
+	config func(cfg *Config)
+	// find returns the documents found like doc and their
+	// scores.
+	find func(idx *Index, doc *Doc) ([]Doc, []float64, error)
+	// variant returns whether score is plausible for a
+	// variant of a document.
+	variant func(score float64) bool
+	// errNone is the error of find on an index made
+	// without config.
+	errNone error
+	// bad, if not nil, is a search which must fail.
+	bad func(idx *Index, doc *Doc) error
+}
+
+var matchTests = []matchTest{
+	{
+		name:   "NearDuplicates",
+		config: func(cfg *Config) { cfg.MinHash = 64 },
+		find: func(idx *Index, doc *Doc) ([]Doc, []float64, error) {
+			near, err := idx.NearDuplicates(doc, 0.6)
+			var docs []Doc
+			var scores []float64
+			for i := range near {
+				docs = append(docs, near[i].Doc)
+				scores = append(scores, near[i].Similarity)
+			}
+			return docs, scores, err
+		},
+		variant: func(sim float64) bool { return sim >= 0.6 && sim < 1 },
+		errNone: ErrNoMinHash,
+	},
+	{
+		name:   "SimilarDocs",
+		config: func(cfg *Config) { cfg.SimHash = true },
+		find: func(idx *Index, doc *Doc) ([]Doc, []float64, error) {
+			similar, err := idx.SimilarDocs(doc, 12)
+			var docs []Doc
+			var scores []float64
+			for i := range similar {
+				docs = append(docs, similar[i].Doc)
+				scores = append(scores, float64(similar[i].Distance))
+			}
+			return docs, scores, err
+		},
+		variant: func(dist float64) bool { return dist > 0 && dist <= 12 },
+		errNone: ErrNoSimHash,
+		bad: func(idx *Index, doc *Doc) error {
+			_, err := idx.SimilarDocs(doc, 64)
+			return err
+		},
+	},
+}
+
+// matchPaths returns the base names of the paths of docs.
+func matchPaths(docs []Doc) string {
+	var res []string
+	for i := range docs {
+		res = append(res, filepath.Base(docs[i].Path))
+	}
+	return strings.Join(res, " ")
+}
+
+func TestMatches(t *testing.T) {
+	for i := range matchTests {
+		mt := &matchTests[i]
+		t.Run(mt.name, func(t *testing.T) {
+			testMatches(t, mt)
+		})
+	}
+}
+
+func testMatches(t *testing.T, mt *matchTest) {
+	const n, m = 10, 5
+	docs := nearTestDocs(n, m)
+	root := testIndexConfig(t, docs, mt.config)
+	// variant i, which is not its own match.
+	variant := func(i int) *Doc {
+		path := filepath.Join(filepath.Dir(root), fmt.Sprintf("doc%d", n+i))
+		return &Doc{Path: path, End: uint64(len(docs[n+i]))}
+	}
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < m; i++ {
+		got, scores, err := mt.find(idx, variant(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("doc%d", i); matchPaths(got) != want {
+			t.Errorf("matches of variant of doc%d: got %s, want %s", i, matchPaths(got), want)
+		}
+		if len(got) != 0 && !mt.variant(scores[0]) {
+			t.Errorf("variant of doc%d scored %.2f", i, scores[0])
+		}
+	}
+	// documents which are not indexed.
+	got, _, err := mt.find(idx, NewDoc("query", docs[n]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := fmt.Sprintf("doc%d doc0", n); matchPaths(got) != want {
+		t.Errorf("matches of query: got %s, want %s", matchPaths(got), want)
+	}
+	if mt.bad != nil {
+		if err := mt.bad(idx, variant(0)); err == nil {
+			t.Errorf("no error for bad search")
+		}
+	}
+
+	// deleted documents do not match, even in an index
+	// whose search tables were built before the delete.
+	idxr, err := OpenIndexer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idxr.Delete(filepath.Join(filepath.Dir(root), "doc1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err = mt.find(idx, variant(1))
+	idx.Close()
+	if err != nil || len(got) != 0 {
+		t.Errorf("matches of variant of doc1 deleted while open: %s %v", matchPaths(got), err)
+	}
+	if idx, err = OpenIndex(root); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err = mt.find(idx, variant(1))
+	idx.Close()
+	if err != nil || len(got) != 0 {
+		t.Errorf("matches of variant of deleted doc1: %s %v", matchPaths(got), err)
+	}
+
+	// merging keeps what find needs.
+	other := testIndexConfig(t, docs[:2], mt.config)
+	out := filepath.Join(filepath.Dir(root), "merged")
+	if err := MergeIndices(out, other, root); err != nil {
+		t.Fatal(err)
+	}
+	if idx, err = OpenIndex(out); err != nil {
+		t.Fatal(err)
+	}
+	got, _, err = mt.find(idx, variant(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "doc1"; matchPaths(got) != want || filepath.Dir(got[0].Path) != filepath.Dir(other) {
+		t.Errorf("matches of variant of doc1 in merged index: got %v", got)
+	}
+	ps, err := idx.Verify()
+	idx.Close()
+	if err != nil || len(ps) != 0 {
+		t.Errorf("merged index has problems %v %v", ps, err)
+	}
+
+	idx, err = OpenIndex(testIndex(t, docs[:1]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	if _, _, err := mt.find(idx, NewDoc("query", docs[0])); !errors.Is(err, mt.errNone) {
+		t.Errorf("search of index without its data gave %v", err)
+	}
+}
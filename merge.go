@@ -0,0 +1,178 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"sort"
+)
+
+// Passage is a maximal run of text duplicated in
+// two documents.  A and B give the path and the
+// position of the text in each document; calling
+// Load on them reads the text.
+type Passage struct {
+	A, B Doc
+	// Blots is the number of consecutive duplicate
+	// blots merged into the passage.
+	Blots int
+}
+
+// Passages merges the runs of consecutive duplicate blots
+// containing blot.Blot into passages, one for every pair
+// of documents of blot sharing such a run.
+//
+// If blot.Docs is nil, the documents are first retrieved
+// with q.Get.
+func (q *Query) Passages(blot *Blot) ([]Passage, error) {
+	if blot.Docs == nil {
+		if err := q.Get(blot); err != nil {
+			return nil, err
+		}
+	}
+	return q.index.passages(blot.Blot, blot.Docs)
+}
+
+func (x *Index) passages(blot uint32, docs []Doc) ([]Passage, error) {
+	var err error
+	wins := make([][]window, len(docs))
+	for i := range docs {
+		doc := &docs[i]
+		loaded := doc.Dat != nil
+		wins[i], err = x.allWindows(nil, doc)
+		if err != nil {
+			return nil, err
+		}
+		if !loaded {
+			defer func() { doc.Dat = nil }()
+		}
+	}
+	var res []Passage
+	for i := range docs {
+		for j := i + 1; j < len(docs); j++ {
+			res = mergePair(res, blot, x.sameText, &docs[i], wins[i], &docs[j], wins[j])
+		}
+	}
+	return res, nil
+}
+
+// run is a run of matching windows of two documents,
+// keyed by the difference of the indices of their last
+// words.
+type run struct {
+	// the index of the last word of the last window of
+	// the run in the first document.
+	last         int
+	aStart, aEnd uint64
+	bStart, bEnd uint64
+	blots        int
+	seed         bool
+}
+
+// extends returns whether the windows ending at word of
+// the first document extend r.
+func (r *run) extends(word int) bool {
+	return r != nil && (r.last == word-1 || r.last == word)
+}
+
+// add adds the matching windows wa and wb to r.
+func (r *run) add(wa, wb *window) {
+	if r.blots == 0 || wa.start < r.aStart {
+		r.aStart = wa.start
+	}
+	if r.blots == 0 || wb.start < r.bStart {
+		r.bStart = wb.start
+	}
+	if wa.end > r.aEnd {
+		r.aEnd = wa.end
+	}
+	if wb.end > r.bEnd {
+		r.bEnd = wb.end
+	}
+	r.last = wa.word
+	r.blots++
+}
+
+// mergePair appends to dst the passages shared by a and b
+// which contain blot.  wa and wb are the windows of a and b,
+// and same tells whether the texts of windows are the same.
+//
+// Runs follow the diagonals of the word indices of a and
+// b.  A match of windows leaving out a word, given by
+// skip-gram blotters, may also move a run to a next
+// diagonal, so that passages span an inserted or deleted
+// word.
+func mergePair(dst []Passage, blot uint32, same func(a, b []byte) bool, a *Doc, wa []window, b *Doc, wb []window) []Passage {
+	pos := make(map[uint32][]int)
+	for j := range wb {
+		pos[wb[j].blot] = append(pos[wb[j].blot], j)
+	}
+	start := len(dst)
+	flush := func(r *run) {
+		if !r.seed {
+			return
+		}
+		dst = append(dst, Passage{
+			A:     Doc{Path: a.Path, Start: r.aStart, End: r.aEnd},
+			B:     Doc{Path: b.Path, Start: r.bStart, End: r.bEnd},
+			Blots: r.blots})
+	}
+	runs := make(map[int]*run)
+	for i := range wa {
+		word := wa[i].word
+		for _, j := range pos[wa[i].blot] {
+			if !same(a.text(&wa[i]), b.text(&wb[j])) {
+				// blot collision
+				continue
+			}
+			d := wb[j].word - word
+			r := runs[d]
+			if !r.extends(word) && (wa[i].skipEnd != 0 || wb[j].skipEnd != 0) {
+				for _, e := range [2]int{d - 1, d + 1} {
+					if o := runs[e]; o.extends(word) {
+						delete(runs, e)
+						if r != nil {
+							flush(r)
+						}
+						r = o
+						runs[d] = r
+						break
+					}
+				}
+			}
+			if !r.extends(word) {
+				if r != nil {
+					flush(r)
+				}
+				r = &run{}
+				runs[d] = r
+			}
+			r.add(&wa[i], &wb[j])
+			if wa[i].blot == blot {
+				r.seed = true
+			}
+		}
+	}
+	for _, r := range runs {
+		flush(r)
+	}
+	ps := dst[start:]
+	sort.Slice(ps, func(i, j int) bool {
+		if ps[i].A.Start != ps[j].A.Start {
+			return ps[i].A.Start < ps[j].A.Start
+		}
+		return ps[i].B.Start < ps[j].B.Start
+	})
+	return dst
+}
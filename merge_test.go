@@ -0,0 +1,174 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-air/dupi/token"
+)
+
+func TestPassages(t *testing.T) {
+	idx, err := OpenIndex(testIndex(t, queryTestDocs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	q := idx.StartQuery(QueryMaxBlot)
+	shape := []Blot{{}}
+	found := 0
+	for {
+		_, err := q.Next(shape)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps, err := q.Passages(&shape[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ps) == 0 {
+			t.Errorf("blot %x has no passages", shape[0].Blot)
+		}
+		for i := range ps {
+			p := &ps[i]
+			if filepath.Base(p.A.Path) != "doc0" || filepath.Base(p.B.Path) != "doc1" {
+				continue
+			}
+			found++
+			if p.A.Start != 0 || p.A.End != uint64(len(queryTestDocs[0])-1) {
+				t.Errorf("passage A got %d:%d", p.A.Start, p.A.End)
+			}
+			if p.B.Start != uint64(len("yesterday ")) || p.B.End != uint64(len(queryTestDocs[1])-1) {
+				t.Errorf("passage B got %d:%d", p.B.Start, p.B.End)
+			}
+			if err := p.A.Load(); err != nil {
+				t.Fatal(err)
+			}
+			if want := queryTestDocs[0][:p.A.End]; string(p.A.Dat) != want {
+				t.Errorf("passage text got '%s'", p.A.Dat)
+			}
+		}
+		shape[0].Docs = nil
+	}
+	if found == 0 {
+		t.Errorf("no passage found between doc0 and doc1")
+	}
+}
+
+var goCloneDocs = []string{
+	"func sum(xs []int) int {\n\ttotal := 0\n\tfor _, x := range xs {\n\t\ttotal += x\n\t}\n\treturn total\n}\n",
+	"func add(vals []int) int {\n\tacc := 0\n\tfor _, v := range vals {\n\t\tacc += v\n\t}\n\treturn acc\n}\n",
+	"type point struct {\n\tx, y float64\n}\n"}
+
+// goCloneIndex indexes docs as Go source with placeholders
+// for identifiers and literals, returning the index root.
+func goCloneIndex(t *testing.T, docs []string) string {
+	t.Helper()
+	tmp, err := ioutil.TempDir(".", "dupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+	cfg, err := NewConfig(filepath.Join(tmp, "dupi"), 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.TokenConfig = token.Config{Name: "go.source", Placeholders: true}
+	idxr, err := IndexerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range docs {
+		path := filepath.Join(tmp, fmt.Sprintf("doc%d", i))
+		if err := ioutil.WriteFile(path, []byte(d), 0644); err != nil {
+			t.Fatal(err)
+		}
+		doc := &Doc{Path: path, End: uint64(len(d)), Dat: []byte(d)}
+		if err := idxr.Add(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return cfg.IndexRoot
+}
+
+func TestRenamedClone(t *testing.T) {
+	idx, err := OpenIndex(goCloneIndex(t, goCloneDocs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+
+	// like: the windows of doc0 are found in its renamed clone.
+	a := &Doc{Path: filepath.Join(filepath.Dir(idx.Root()), "doc0")}
+	wins, err := idx.docWindows(nil, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := make(map[uint32][]byte)
+	for i := range wins {
+		m[wins[i].blot] = a.text(&wins[i])
+	}
+	b := &Doc{Path: filepath.Join(filepath.Dir(idx.Root()), "doc1")}
+	found, err := idx.FindBlots(m, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != len(m) {
+		t.Errorf("found %d of %d blots in clone", len(found), len(m))
+	}
+
+	// passages: the whole function is a passage.
+	q := idx.StartQuery(QueryMaxBlot)
+	shape := []Blot{{}}
+	npass := 0
+	for {
+		_, err := q.Next(shape)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps, err := q.Passages(&shape[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range ps {
+			p := &ps[i]
+			if filepath.Base(p.A.Path) != "doc0" || filepath.Base(p.B.Path) != "doc1" {
+				continue
+			}
+			npass++
+			if p.A.Start != 0 || p.B.Start != 0 {
+				t.Errorf("passage starts at %d, %d", p.A.Start, p.B.Start)
+			}
+		}
+		shape[0].Docs = nil
+	}
+	if npass == 0 {
+		t.Errorf("no passage found between clones")
+	}
+}
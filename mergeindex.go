@@ -0,0 +1,236 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+
+	"github.com/go-air/dupi/dmd"
+	"github.com/go-air/dupi/internal/shard"
+	"github.com/go-air/dupi/lock"
+	"github.com/go-air/dupi/minhash"
+	"github.com/go-air/dupi/simhash"
+)
+
+// MergeIndices creates a new index at out containing the
+// documents of the indices at roots.  Document ids are
+// renumbered in the order of roots, and deleted documents
+// are dropped.  The taboo lists of the indices are merged.
+// The new index has the current format version, so
+// merging also upgrades indices with older versions.
+//
+// All indices must have the same tokenizer, blotter,
+// sequence length, number of shards and MinHash signature
+// size, and either all or none keep SimHash fingerprints.
+func MergeIndices(out string, roots ...string) error {
+	if len(roots) == 0 {
+		return fmt.Errorf("no indices to merge")
+	}
+	idxs := make([]*Index, 0, len(roots))
+	defer func() {
+		for _, idx := range idxs {
+			idx.Close()
+		}
+	}()
+	for _, root := range roots {
+		idx, err := OpenIndex(root)
+		if err != nil {
+			return fmt.Errorf("error opening %s: %w", root, err)
+		}
+		idxs = append(idxs, idx)
+	}
+	for _, idx := range idxs[1:] {
+		if err := mergeable(idxs[0].config, idx.config); err != nil {
+			return err
+		}
+	}
+	abs, err := filepath.Abs(out)
+	if err != nil {
+		return err
+	}
+	cfg := *idxs[0].config
+	cfg.IndexRoot = abs
+	cfg.Version = FormatVersion
+	lk, err := lock.New(cfg.LockPath())
+	if err != nil {
+		return err
+	}
+	defer lk.Close()
+	if err := lk.Lock(); err != nil {
+		return err
+	}
+	if err := os.Mkdir(cfg.IndexRoot, 0755); err != nil {
+		return err
+	}
+	if err := mergeTo(&cfg, idxs); err != nil {
+		if rerr := os.RemoveAll(cfg.IndexRoot); rerr != nil {
+			log.Printf("couldn't remove partial merge %s: %s", cfg.IndexRoot, rerr)
+		}
+		return err
+	}
+	return nil
+}
+
+// mergeable returns an error explaining why the indices
+// with configs a and b cannot be merged, if they cannot.
+func mergeable(a, b *Config) error {
+	why := ""
+	switch {
+	case !reflect.DeepEqual(a.TokenConfig, b.TokenConfig):
+		why = fmt.Sprintf("token config %+v differs from %+v", b.TokenConfig, a.TokenConfig)
+	case !reflect.DeepEqual(a.BlotConfig, b.BlotConfig):
+		why = fmt.Sprintf("blot config %+v differs from %+v", b.BlotConfig, a.BlotConfig)
+	case a.SeqLen != b.SeqLen:
+		why = fmt.Sprintf("sequence length %d differs from %d", b.SeqLen, a.SeqLen)
+	case a.NumShards != b.NumShards:
+		why = fmt.Sprintf("number of shards %d differs from %d", b.NumShards, a.NumShards)
+	case a.blotBits() != b.blotBits():
+		why = fmt.Sprintf("blot bits %d differs from %d", b.blotBits(), a.blotBits())
+	case a.MinHash != b.MinHash:
+		return fmt.Errorf("cannot merge %s with %s: MinHash signature size %d differs from %d",
+			b.IndexRoot, a.IndexRoot, b.MinHash, a.MinHash)
+	case a.SimHash != b.SimHash:
+		return fmt.Errorf("cannot merge %s with %s: only one keeps SimHash fingerprints",
+			b.IndexRoot, a.IndexRoot)
+	default:
+		return nil
+	}
+	return fmt.Errorf("cannot merge %s with %s: %s, so their blots are incompatible",
+		b.IndexRoot, a.IndexRoot, why)
+}
+
+func mergeTo(cfg *Config, idxs []*Index) error {
+	fns := newFnames()
+	dmds, err := dmd.NewAdder(cfg.IndexRoot, cfg.DocFlushRate)
+	if err != nil {
+		return err
+	}
+	var sigs *minhash.Adder
+	if cfg.MinHash != 0 {
+		sigs, err = minhash.NewAdder(cfg.MinHashPath(), cfg.MinHash)
+		if err != nil {
+			return err
+		}
+		defer sigs.Close()
+	}
+	var sig []uint32
+	var fps *simhash.Adder
+	if cfg.SimHash {
+		fps, err = simhash.NewAdder(cfg.SimHashPath())
+		if err != nil {
+			return err
+		}
+		defer fps.Close()
+	}
+	taboo := &Taboo{}
+	// didMaps[i][did] is the docid in the merged
+	// index of docid did in idxs[i], or 0 if the
+	// document is dropped.
+	didMaps := make([][]uint64, len(idxs))
+	for i, idx := range idxs {
+		n, err := idx.dmd.NumDocs()
+		if err != nil {
+			return err
+		}
+		didMap := make([]uint64, n)
+		fids := make(map[uint32]uint32)
+		for did := uint64(1); did < n; did++ {
+			if idx.tombs.Has(did) {
+				continue
+			}
+			fid, start, end, err := idx.dmd.Lookup(did)
+			if err != nil {
+				return fmt.Errorf("%s: doc %d: %w", idx.Root(), did, err)
+			}
+			ofid, ok := fids[fid]
+			if !ok {
+				ofid, err = fns.addPath(idx.fnames.abs(fid))
+				if err != nil {
+					return err
+				}
+				fids[fid] = ofid
+			}
+			didMap[did], err = dmds.Add(ofid, start, end)
+			if err != nil {
+				return err
+			}
+			if sigs != nil {
+				if sig, err = idx.sigs.Signature(sig[:0], did); err != nil {
+					return err
+				}
+				sigs.Add(didMap[did], sig)
+			}
+			if fps != nil {
+				fp, err := idx.fps.Fingerprint(did)
+				if err != nil {
+					return err
+				}
+				fps.Add(didMap[did], fp)
+			}
+		}
+		didMaps[i] = didMap
+		for _, e := range idx.taboo.Entries {
+			taboo.Add(e.Name, e.Text)
+		}
+	}
+	if err := dmds.Close(); err != nil {
+		return err
+	}
+	if sigs != nil {
+		if err := sigs.Sync(); err != nil {
+			return err
+		}
+	}
+	if fps != nil {
+		if err := fps.Sync(); err != nil {
+			return err
+		}
+	}
+	for s := 0; s < cfg.NumShards; s++ {
+		srcs := make([]shard.Source, len(idxs))
+		for i, idx := range idxs {
+			didMap := didMaps[i]
+			srcs[i] = shard.Source{
+				Index: &idx.shards[s],
+				Map: func(did uint64) (uint64, bool) {
+					if did >= uint64(len(didMap)) || didMap[did] == 0 {
+						return 0, false
+					}
+					return didMap[did], true
+				}}
+		}
+		if err := shard.Merge(cfg.PostPath(s), cfg.Version, srcs); err != nil {
+			return fmt.Errorf("error merging shard %d: %w", s, err)
+		}
+	}
+	fnf, err := os.OpenFile(cfg.FnamesPath(), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer fnf.Close()
+	if err := fns.write(fnf, cfg.FormatVersion()); err != nil {
+		return err
+	}
+	if len(taboo.Entries) != 0 {
+		if err := taboo.write(cfg); err != nil {
+			return err
+		}
+	}
+	return cfg.Write()
+}
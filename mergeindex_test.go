@@ -0,0 +1,72 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMergeIndices(t *testing.T) {
+	d := queryTestDocs
+	a := testIndex(t, []string{d[0], d[2]})
+	b := testIndex(t, []string{d[1], d[3], d[4]})
+	out := filepath.Join(filepath.Dir(a), "merged")
+	if err := MergeIndices(out, a, b); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := OpenIndex(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	st, err := idx.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if st.NumDocs != 6 {
+		t.Errorf("merged index has %d docs, want 6", st.NumDocs)
+	}
+	q := idx.StartQuery(QueryMaxBlot)
+	shape := []Blot{{}}
+	if _, err := q.Next(shape); err != nil {
+		t.Fatal(err)
+	}
+	docs := shape[0].Docs
+	if len(docs) != 2 {
+		t.Fatalf("got %d docs for blot %x", len(docs), shape[0].Blot)
+	}
+	if want, _ := filepath.Abs(filepath.Join(filepath.Dir(a), "doc0")); docs[0].Path != want {
+		t.Errorf("got path %s", docs[0].Path)
+	}
+	if want, _ := filepath.Abs(filepath.Join(filepath.Dir(b), "doc0")); docs[1].Path != want {
+		t.Errorf("got path %s", docs[1].Path)
+	}
+
+	tmp := t.TempDir()
+	c := filepath.Join(tmp, "c")
+	idxr, err := CreateIndexer(c, 4, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	err = MergeIndices(filepath.Join(tmp, "bad"), a, c)
+	if err == nil || !strings.Contains(err.Error(), "number of shards") {
+		t.Errorf("merging incompatible indices gave %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package minhash
+
+import (
+	"fmt"
+
+	"github.com/go-air/dupi/internal/docrec"
+)
+
+// The signature file of an index, like its dmd file, is a
+// sequence of fixed size records, one per document id.
+// Record i holds the k entries of the signature of
+// document i.  Record 0, which is not a document, is a
+// header with Magic, Version and k.
+const (
+	Magic   = 0x646d68ff // "dmh\xff"
+	Version = 1
+)
+
+// Adder appends signatures to a signature file.  Add adds
+// the signature of the next document; errors are kept and
+// returned by Sync and Close.
+type Adder struct {
+	*docrec.WordAdder
+	k int
+}
+
+// NewAdder opens the signature file at path, with
+// signatures of k entries, for appending, creating it if
+// it does not exist.
+func NewAdder(path string, k int) (*Adder, error) {
+	if k < Rows || k%Rows != 0 {
+		return nil, fmt.Errorf("minhash: signature size %d is not a positive multiple of %d", k, Rows)
+	}
+	a, err := docrec.NewWordAdder(path, Magic, Version, k)
+	if err != nil {
+		return nil, err
+	}
+	return &Adder{WordAdder: a, k: k}, nil
+}
+
+// SigLen returns the number of entries of the signatures
+// of a.
+func (a *Adder) SigLen() int {
+	return a.k
+}
+
+// Truncate removes the signatures of the documents with
+// ids n and above from the signature file at path, whose
+// signatures have k entries.
+func Truncate(path string, k int, n uint64) error {
+	return docrec.Truncate(path, 4*k, n)
+}
+
+// File reads a signature file.  A File may be used by many
+// goroutines at once.
+type File struct {
+	*docrec.WordFile
+	k int
+}
+
+// Open opens the signature file at path, with signatures
+// of k entries.
+func Open(path string, k int) (*File, error) {
+	f, err := docrec.OpenWords(path, Magic, Version, k)
+	if err != nil {
+		return nil, err
+	}
+	return &File{WordFile: f, k: k}, nil
+}
+
+// Signature appends to dst the signature of document did.
+func (f *File) Signature(dst []uint32, did uint64) ([]uint32, error) {
+	return f.RecordWords(dst, did)
+}
+
+// Each calls fn with every document id of f and its
+// signature, which fn must not retain.
+func (f *File) Each(fn func(did uint64, sig []uint32) error) error {
+	return f.EachWords(fn)
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package minhash
+
+import (
+	"sort"
+
+	"github.com/go-air/dupi/internal/splitmix"
+)
+
+// LSH is a banded locality sensitive hashing index of
+// signatures.  Signatures are split into bands of Rows
+// entries, and documents whose signatures have an equal
+// band are candidates for being similar.  Documents with
+// Jaccard similarity s are candidates with probability
+// 1-(1-s^Rows)^b, where b is the number of bands, which
+// is about 1/2 for s = (1/b)^(1/Rows).
+type LSH struct {
+	// bands[i] is sorted by key.
+	bands [][]entry
+}
+
+type entry struct {
+	key uint64
+	did uint64
+}
+
+// NewLSH creates an LSH index of the signatures of the
+// documents of f for which keep returns true.  The
+// signatures of empty sets are not indexed.
+func NewLSH(f *File, keep func(did uint64) bool) (*LSH, error) {
+	res := &LSH{bands: make([][]entry, f.k/Rows)}
+	err := f.Each(func(did uint64, sig []uint32) error {
+		if !keep(did) || IsEmpty(sig) {
+			return nil
+		}
+		for i := range res.bands {
+			res.bands[i] = append(res.bands[i], entry{key: bandKey(sig, i), did: did})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, band := range res.bands {
+		sort.Slice(band, func(i, j int) bool {
+			if band[i].key != band[j].key {
+				return band[i].key < band[j].key
+			}
+			return band[i].did < band[j].did
+		})
+	}
+	return res, nil
+}
+
+// Candidates returns the documents with a band equal to
+// a band of sig, in increasing order.
+func (l *LSH) Candidates(sig []uint32) []uint64 {
+	if IsEmpty(sig) {
+		return nil
+	}
+	seen := make(map[uint64]bool)
+	for i, band := range l.bands {
+		key := bandKey(sig, i)
+		j := sort.Search(len(band), func(j int) bool { return band[j].key >= key })
+		for ; j < len(band) && band[j].key == key; j++ {
+			seen[band[j].did] = true
+		}
+	}
+	res := make([]uint64, 0, len(seen))
+	for did := range seen {
+		res = append(res, did)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+// bandKey returns the key of band i of sig.
+func bandKey(sig []uint32, i int) uint64 {
+	k := uint64(i)
+	for _, v := range sig[i*Rows : (i+1)*Rows] {
+		k = splitmix.Mix(k*0x100000001b3 ^ uint64(v))
+	}
+	return k
+}
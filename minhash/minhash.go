@@ -0,0 +1,118 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package minhash provides MinHash signatures of the sets
+// of blots of documents, the file in which an index keeps
+// them, and a banded LSH index over them for finding
+// documents with similar sets of blots.
+//
+// The signature of a set of blots has k entries, where entry
+// i is the minimum of the i'th of k hash functions over the
+// set.  The fraction of entries two signatures have in
+// common estimates the Jaccard similarity of their sets.
+package minhash
+
+import (
+	"math"
+
+	"github.com/go-air/dupi/internal/splitmix"
+)
+
+// Rows is the number of signature entries in a band of
+// the LSH index, so signature sizes must be multiples of
+// Rows.
+const Rows = 4
+
+// Empty is the value of all the entries of the signature
+// of the empty set.
+const Empty = math.MaxUint32
+
+// Hasher computes signatures with k entries.
+type Hasher struct {
+	seeds []uint64
+}
+
+func New(k int) *Hasher {
+	h := &Hasher{seeds: make([]uint64, k)}
+	s := uint64(0x6d696e68617368) // "minhash"
+	for i := range h.seeds {
+		s += 0x9e3779b97f4a7c15
+		h.seeds[i] = splitmix.Mix(s)
+	}
+	return h
+}
+
+// Len returns the number of entries of the signatures of
+// h.
+func (h *Hasher) Len() int {
+	return len(h.seeds)
+}
+
+// Reset makes sig, which must have h.Len() entries, the
+// signature of the empty set.
+func (h *Hasher) Reset(sig []uint32) {
+	for i := range sig {
+		sig[i] = Empty
+	}
+}
+
+// Add adds blot to the set with signature sig.
+func (h *Hasher) Add(sig []uint32, blot uint32) {
+	for i, seed := range h.seeds {
+		if v := uint32(splitmix.Mix(seed^uint64(blot)) >> 32); v < sig[i] {
+			sig[i] = v
+		}
+	}
+}
+
+// Sign appends to dst the signature of the set of blots.
+func (h *Hasher) Sign(dst []uint32, blots []uint32) []uint32 {
+	n := len(dst)
+	for range h.seeds {
+		dst = append(dst, 0)
+	}
+	sig := dst[n:]
+	h.Reset(sig)
+	for _, b := range blots {
+		h.Add(sig, b)
+	}
+	return dst
+}
+
+// IsEmpty returns whether sig is the signature of the
+// empty set.
+func IsEmpty(sig []uint32) bool {
+	for _, v := range sig {
+		if v != Empty {
+			return false
+		}
+	}
+	return true
+}
+
+// Similarity returns the estimated Jaccard similarity of
+// the sets with signatures a and b, which have the same
+// number of entries.
+func Similarity(a, b []uint32) float64 {
+	if len(a) == 0 {
+		return 0
+	}
+	n := 0
+	for i := range a {
+		if a[i] == b[i] {
+			n++
+		}
+	}
+	return float64(n) / float64(len(a))
+}
@@ -0,0 +1,153 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package minhash
+
+import (
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// sets returns two sets of blots of n elements with
+// Jaccard similarity j.
+func sets(r *rand.Rand, n int, j float64) ([]uint32, []uint32) {
+	// |a ∩ b| / |a ∪ b| = c / (2n - c)
+	c := int(math.Round(2 * float64(n) * j / (1 + j)))
+	a, b := make([]uint32, n), make([]uint32, n)
+	for i := range a {
+		a[i] = r.Uint32()
+		if i < c {
+			b[i] = a[i]
+		} else {
+			b[i] = r.Uint32()
+		}
+	}
+	return a, b
+}
+
+func TestSimilarity(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	h := New(256)
+	for _, j := range []float64{0, 0.2, 0.5, 0.8, 1} {
+		a, b := sets(r, 500, j)
+		got := Similarity(h.Sign(nil, a), h.Sign(nil, b))
+		if math.Abs(got-j) > 0.1 {
+			t.Errorf("similarity %.2f estimated as %.2f", j, got)
+		}
+	}
+	if sig := h.Sign(nil, nil); !IsEmpty(sig) {
+		t.Errorf("signature of empty set %v", sig[:4])
+	}
+}
+
+func TestFile(t *testing.T) {
+	const k = 16
+	tmp, err := ioutil.TempDir("", "minhash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	path := filepath.Join(tmp, "minhash")
+	r := rand.New(rand.NewSource(2))
+	h := New(k)
+	var sigs [][]uint32
+	for i := 0; i < 100; i++ {
+		base, near := sets(r, 100, 0.9)
+		sigs = append(sigs, h.Sign(nil, base), h.Sign(nil, near))
+	}
+	a, err := NewAdder(path, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, sig := range sigs[:100] {
+		a.Add(uint64(i+1), sig)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if a, err = NewAdder(path, k); err != nil {
+		t.Fatal(err)
+	}
+	for i, sig := range sigs[100:] {
+		a.Add(uint64(i+101), sig)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(path, 2*k); err == nil {
+		t.Errorf("opened signatures of size %d as %d", k, 2*k)
+	}
+	f, err := Open(path, k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if n, err := f.Len(); err != nil || n != uint64(len(sigs)+1) {
+		t.Errorf("file has %d records: %v", n, err)
+	}
+	for i, sig := range sigs {
+		got, err := f.Signature(nil, uint64(i+1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Similarity(got, sig) != 1 {
+			t.Errorf("doc %d: signature %x, want %x", i+1, got, sig)
+		}
+	}
+	l, err := NewLSH(f, func(did uint64) bool { return did != 2 })
+	if err != nil {
+		t.Fatal(err)
+	}
+	missed, extra := 0, 0
+	for i := 0; i < len(sigs); i += 2 {
+		cands := l.Candidates(sigs[i])
+		found := false
+		for _, did := range cands {
+			switch did {
+			case uint64(i + 1):
+			case uint64(i + 2):
+				found = true
+			default:
+				extra++
+			}
+		}
+		if !found && i != 0 {
+			missed++
+		}
+		if i == 0 && found {
+			t.Errorf("LSH found document 2, which is not kept")
+		}
+	}
+	if missed > 5 || extra > 5 {
+		t.Errorf("LSH missed %d similar documents and found %d others", missed, extra)
+	}
+
+	if a, err = NewAdder(path, k); err != nil {
+		t.Fatal(err)
+	}
+	a.Add(1000, sigs[0])
+	if err := a.Close(); err == nil {
+		t.Errorf("adding signatures out of order gave no error")
+	}
+	if err := Truncate(path, k, 51); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Len(); err != nil || n != 51 {
+		t.Errorf("truncated file has %d records: %v", n, err)
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/go-air/dupi/minhash"
+)
+
+// ErrNoMinHash is returned by NearDuplicates for indices
+// created without MinHash signatures.
+var ErrNoMinHash = errors.New("index has no MinHash signatures")
+
+// NearDoc is a document found by NearDuplicates, with the
+// estimated Jaccard similarity of its set of blots and
+// that of the query document.
+type NearDoc struct {
+	Doc
+	Similarity float64
+}
+
+// nearIndex is the LSH index of the signatures of an
+// Index, built when it is first used.
+type nearIndex struct {
+	once sync.Once
+	lsh  *minhash.LSH
+	err  error
+}
+
+// NearDuplicates returns the documents of x whose sets of
+// blots have an estimated Jaccard similarity of at least
+// threshold with that of doc, in decreasing order of
+// similarity.  doc itself, if it is in x, is not returned.
+//
+// Candidates are found with an LSH index of the MinHash
+// signatures of the documents of x, so documents with a
+// similarity well below (1/b)^(1/minhash.Rows), where b is
+// Config.MinHash / minhash.Rows, are likely to be missed.
+// The LSH index is built in memory on the first call and
+// only has the documents of x as of then.
+func (x *Index) NearDuplicates(doc *Doc, threshold float64) ([]NearDoc, error) {
+	if x.sigs == nil {
+		return nil, ErrNoMinHash
+	}
+	if doc.Dat == nil {
+		if err := doc.Load(); err != nil {
+			return nil, err
+		}
+	}
+	lsh, err := x.lsh()
+	if err != nil {
+		return nil, err
+	}
+	sig := minhash.New(x.config.MinHash).Sign(nil, x.BlotDoc(nil, doc))
+	var other []uint32
+	ms, err := x.matches(doc, lsh.Candidates(sig), func(did uint64) (float64, bool, error) {
+		var err error
+		if other, err = x.sigs.Signature(other[:0], did); err != nil {
+			return 0, false, err
+		}
+		sim := minhash.Similarity(sig, other)
+		return sim, sim >= threshold, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var res []NearDoc
+	for i := range ms {
+		res = append(res, NearDoc{Doc: ms[i].doc, Similarity: ms[i].score})
+	}
+	return res, nil
+}
+
+// lsh returns the LSH index of the signatures of the
+// documents of x which are not deleted.
+func (x *Index) lsh() (*minhash.LSH, error) {
+	n := x.near
+	n.once.Do(func() {
+		n.lsh, n.err = minhash.NewLSH(x.sigs, func(did uint64) bool {
+			return !x.tombs.Has(did)
+		})
+	})
+	return n.lsh, n.err
+}
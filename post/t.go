@@ -18,25 +18,39 @@ package post
 
 import "fmt"
 
-// a post is a tuple of document id, blot
-type T uint64
+// T is a post: a blot in a document.  The check of a
+// post holds the upper bits of blots wider than 32 bits.
+type T struct {
+	docid uint64
+	blot  uint32
+	check uint32
+}
 
-func (p T) Docid() uint32 {
-	return uint32(p >> 32)
+func (p T) Docid() uint64 {
+	return p.docid
 }
 
 func (p T) String() string {
-	return fmt.Sprintf("<%d,%x>", p.Docid(), p.Blot()&0xffff)
+	return fmt.Sprintf("<%d,%x>", p.Docid(), p.Blot())
 }
 
 func (p T) Blot() uint32 {
-	return uint32(p)
+	return p.blot
+}
+
+func (p T) Check() uint32 {
+	return p.check
 }
 
-func (p T) Split() (docid, blot uint32) {
+func (p T) Split() (docid uint64, blot uint32) {
 	return p.Docid(), p.Blot()
 }
 
-func Make(docid, blot uint32) T {
-	return T(uint64(docid)<<32 | uint64(blot))
+func Make(docid uint64, blot uint32) T {
+	return T{docid: docid, blot: blot}
+}
+
+// MakeCheck makes a post with check.
+func MakeCheck(docid uint64, blot, check uint32) T {
+	return T{docid: docid, blot: blot, check: check}
 }
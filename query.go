@@ -18,9 +18,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"math"
+	"sort"
 
 	"github.com/go-air/dupi/internal/shard"
+	"github.com/go-air/dupi/internal/splitmix"
 )
 
 type qstate struct {
@@ -28,6 +29,22 @@ type qstate struct {
 	i           uint32
 	n           uint32
 	nilCount    uint32
+
+	// QueryMaxDoc: docids in descending order of
+	// the number of duplicated blots they carry, shared
+	// by the queries of the index, the blots of the
+	// current doc yet to be visited and the set of blots
+	// visited so far.
+	docs     []uint64
+	docBlots []uint32
+	seen     []uint64
+	docsDone bool
+
+	// QueryRandom: a permutation of all blots.
+	perm *blotPerm
+
+	// the next position in docs or perm.
+	at int
 }
 
 func (s *qstate) setMax() {
@@ -46,27 +63,86 @@ func (s *qstate) setMax() {
 
 var ErrInvalidQueryState = errors.New("query state invalid")
 
+// QueryStrategy determines the order in which
+// Query.Next visits blots.
 type QueryStrategy int
 
 const (
+	// QueryMaxBlot visits blots in descending order
+	// of the number of associated documents.
 	QueryMaxBlot QueryStrategy = iota
+	// QueryMaxDoc visits blots grouped by document,
+	// starting with the documents which carry the
+	// most duplicated blots.
 	QueryMaxDoc
+	// QueryRandom visits duplicated blots in a
+	// random order determined by a seed.
 	QueryRandom
 )
 
+func (s QueryStrategy) String() string {
+	switch s {
+	case QueryMaxBlot:
+		return "blot"
+	case QueryMaxDoc:
+		return "doc"
+	case QueryRandom:
+		return "random"
+	default:
+		return fmt.Sprintf("QueryStrategy(%d)", int(s))
+	}
+}
+
+// ParseQueryStrategy returns the QueryStrategy
+// whose String() is name.
+func ParseQueryStrategy(name string) (QueryStrategy, error) {
+	for _, s := range []QueryStrategy{QueryMaxBlot, QueryMaxDoc, QueryRandom} {
+		if s.String() == name {
+			return s, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown query strategy '%s'", name)
+}
+
+// A Query visits the blots of an index.  A Query must
+// only be used by one goroutine at a time; queries which
+// run in parallel must each have their own, started from
+// the same Index.  Documents deleted before a Query starts
+// are not returned by it.
 type Query struct {
 	index    *Index
 	state    *qstate
 	strategy QueryStrategy
+	// error reading the deleted documents when the
+	// query started.
+	err error
 }
 
 func (q *Query) Get(blot *Blot) error {
-	shard := blot.Blot % q.state.n
-	shardblot := uint16(blot.Blot / q.state.n)
+	return q.get(blot, false, 0)
+}
+
+// GetChecked is like Get, but for indices with blots wider
+// than 32 bits only gives the documents whose blot has the
+// upper bits check, as given by Index.BlotPositions.
+// Documents whose blots only collide with the blot in the
+// lower bits are discarded without reading them.
+func (q *Query) GetChecked(blot *Blot, check uint32) error {
+	return q.get(blot, q.index.config.checkBytes() != 0, check)
+}
+
+func (q *Query) get(blot *Blot, checked bool, check uint32) error {
+	if q.err != nil {
+		return q.err
+	}
+	shard, shardblot := q.index.SplitBlot(blot.Blot)
 	rs := q.index.shards[shard].ReadStateFor(shardblot)
 	var (
 		lim   = blot.Docs != nil
-		docid uint32
+		docid uint64
+		last  uint64
+		found bool
+		ck    uint32
 		err   error
 	)
 
@@ -74,27 +150,86 @@ func (q *Query) Get(blot *Blot) error {
 		if lim && len(blot.Docs) == cap(blot.Docs) {
 			return nil
 		}
-		docid, err = rs.Next()
+		docid, ck, err = rs.NextCheck()
 		if err == io.EOF {
 			return nil
 		}
 		if err != nil {
 			return err
 		}
+		// a document may have several posts of the blot
+		// with different checks.
+		if checked && ck != check || found && docid == last {
+			continue
+		}
+		last, found = docid, true
+		if q.index.tombs.Has(docid) {
+			continue
+		}
 		if err = q.index.docid2Doc(docid, blot.Next(lim)); err != nil {
 			return fmt.Errorf("internal error docid2Doc: %w\n", err)
 		}
 	}
 }
 
+// Seek positions q, which must be a QueryMaxBlot query,
+// at blot, so that Next continues from blot with the blots
+// which follow it in decreasing order of the number of
+// associated documents.  An extraction may be resumed by
+// seeking a new query to the last blot it visited.
+func (q *Query) Seek(blot uint32) error {
+	if q.strategy != QueryMaxBlot {
+		return fmt.Errorf("%w: seek in %s query", ErrInvalidQueryState, q.strategy)
+	}
+	if q.err != nil {
+		return q.err
+	}
+	s, sblot := q.index.SplitBlot(blot)
+	ct := q.index.shards[s].Count(sblot)
+	state := q.state
+	for i := range q.index.shards {
+		sh := &q.index.shards[i]
+		// the rank of the first blot of shard i visited
+		// after blot: Next visits shards with larger
+		// counts first, and of equal counts the first
+		// shard.
+		at := sh.Rank(sblot)
+		if uint32(i) != s {
+			at = uint32(sort.Search(int(sh.NumBlots()), func(r int) bool {
+				c := sh.Count(sh.BlotAt(uint32(r)))
+				return c < ct || (c == ct && uint32(i) > s)
+			}))
+		}
+		state.shardStates[i] = nil
+		if uint64(at) == sh.NumBlots() || (at > 0 && sh.Count(sh.BlotAt(at-1)) <= 1) {
+			// as advance, shards are done after their
+			// last blot with more than 1 document.
+			continue
+		}
+		state.shardStates[i] = sh.ReadStateAt(at)
+	}
+	state.nilCount = 0
+	state.setMax()
+	return nil
+}
+
 func (q *Query) Next(dst []Blot) (n int, err error) {
+	if q.err != nil {
+		return 0, q.err
+	}
+	switch q.strategy {
+	case QueryMaxDoc:
+		return q.nextMaxDoc(dst)
+	case QueryRandom:
+		return q.nextRandom(dst)
+	}
 	state := q.state
 	for n < len(dst) {
 		dstBlot := &dst[n]
 		shardState := state.shardStates[state.i]
 		if shardState == nil {
 			state.nilCount++
-			if state.nilCount == state.n {
+			if state.nilCount >= state.n {
 				if n == 0 {
 					err = io.EOF
 					return
@@ -107,6 +242,10 @@ func (q *Query) Next(dst []Blot) (n int, err error) {
 			}
 			continue
 		}
+		if q.index.IsTaboo(q.index.JoinBlot(state.i, shardState.Blot)) {
+			q.advance(shardState, state.i)
+			continue
+		}
 		lim := dstBlot.Docs != nil
 		_, err = q.fillBlot(dstBlot, shardState, state.i)
 		if err != nil {
@@ -128,12 +267,12 @@ func (q *Query) Next(dst []Blot) (n int, err error) {
 
 func (q *Query) fillBlot(dst *Blot, src *shard.ReadState, srcPos uint32) (int, error) {
 	var (
-		docid uint32
+		docid uint64
 		err   error
 		n     int
 		lim   bool
 	)
-	dst.Blot = uint32(src.Blot)*q.state.n + q.state.i
+	dst.Blot = src.Blot*q.state.n + q.state.i
 	lim = dst.Docs != nil
 	for !lim || dst.Len() < dst.Cap() {
 		docid, err = src.Next()
@@ -143,6 +282,9 @@ func (q *Query) fillBlot(dst *Blot, src *shard.ReadState, srcPos uint32) (int, e
 		} else if err != nil {
 			return 0, err
 		}
+		if q.index.tombs.Has(docid) {
+			continue
+		}
 		err = q.index.docid2Doc(docid, dst.Next(lim))
 		if err != nil {
 			return n, err
@@ -154,7 +296,7 @@ func (q *Query) fillBlot(dst *Blot, src *shard.ReadState, srcPos uint32) (int, e
 
 func (q *Query) advance(src *shard.ReadState, pos uint32) *shard.ReadState {
 	var rs *shard.ReadState
-	if src.At == math.MaxUint16 {
+	if uint64(src.At)+1 == q.index.shards[pos].NumBlots() {
 
 	} else if src.Total <= 1 {
 		//fmt.Printf("read state at %d has %d, exhausted\n", pos, src.Total)
@@ -166,3 +308,223 @@ func (q *Query) advance(src *shard.ReadState, pos uint32) *shard.ReadState {
 	q.state.setMax()
 	return q.state.shardStates[q.state.i]
 }
+
+func (q *Query) nextMaxDoc(dst []Blot) (n int, err error) {
+	state := q.state
+	if !state.docsDone {
+		state.docs, err = q.index.docOrder()
+		if err != nil {
+			return
+		}
+		state.docsDone = true
+	}
+	for n < len(dst) {
+		if len(state.docBlots) == 0 {
+			if state.at == len(state.docs) {
+				break
+			}
+			docid := state.docs[state.at]
+			state.at++
+			if q.index.tombs.Has(docid) {
+				continue
+			}
+			state.docBlots, err = q.dupBlots(docid, state.docBlots)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		blot := state.docBlots[0]
+		state.docBlots = state.docBlots[1:]
+		ok, err := q.visit(&dst[n], blot)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+func (q *Query) nextRandom(dst []Blot) (n int, err error) {
+	state := q.state
+	for n < len(dst) && uint64(state.at) < state.perm.n {
+		blot := state.perm.at(uint64(state.at))
+		state.at++
+		ok, err := q.visit(&dst[n], blot)
+		if err != nil {
+			return n, err
+		}
+		if ok {
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, io.EOF
+	}
+	return n, nil
+}
+
+// visit fills dst with the documents of blot, returning
+// whether blot is duplicated and not taboo.
+func (q *Query) visit(dst *Blot, blot uint32) (bool, error) {
+	if q.count(blot) <= 1 || q.index.IsTaboo(blot) {
+		return false, nil
+	}
+	lim := dst.Docs != nil
+	dst.Blot = blot
+	if err := q.Get(dst); err != nil {
+		return false, err
+	}
+	if len(dst.Docs) > 1 {
+		return true, nil
+	}
+	if lim {
+		dst.Docs = dst.Docs[:0]
+	} else {
+		dst.Docs = nil
+	}
+	return false, nil
+}
+
+func (q *Query) count(blot uint32) uint32 {
+	shard, sblot := q.index.SplitBlot(blot)
+	return q.index.shards[shard].Count(sblot)
+}
+
+// dupBlots appends to dst the duplicated blots of the
+// document docid which have not yet been visited and
+// marks them as visited.
+func (q *Query) dupBlots(docid uint64, dst []uint32) ([]uint32, error) {
+	var doc Doc
+	if err := q.index.docid2Doc(docid, &doc); err != nil {
+		return dst, err
+	}
+	if err := doc.Load(); err != nil {
+		return dst, err
+	}
+	seen := q.state.seen
+	for _, b := range q.index.BlotDoc(nil, &doc) {
+		b = q.index.ReduceBlot(b)
+		w, m := b/64, uint64(1)<<(b%64)
+		if seen[w]&m != 0 {
+			continue
+		}
+		seen[w] |= m
+		if q.count(b) <= 1 {
+			continue
+		}
+		dst = append(dst, b)
+	}
+	return dst, nil
+}
+
+// docOrder returns the docids which carry duplicated
+// blots, in descending order of the number of such
+// blots.  The order is computed by the first QueryMaxDoc
+// query of x and shared by the rest, which skip documents
+// deleted since: deleting a document does not change the
+// blot counts of the others.
+func (x *Index) docOrder() ([]uint64, error) {
+	x.ordMu.Lock()
+	defer x.ordMu.Unlock()
+	if x.ordDone {
+		return x.ord, nil
+	}
+	docs, err := x.countDocOrder()
+	if err != nil {
+		return nil, err
+	}
+	x.ord, x.ordDone = docs, true
+	return docs, nil
+}
+
+// countDocOrder computes the order of docOrder.
+func (x *Index) countDocOrder() ([]uint64, error) {
+	nDocs, err := x.dmd.NumDocs()
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]uint32, nDocs)
+	for i := range x.shards {
+		shrd := &x.shards[i]
+		for b := uint32(0); uint64(b) < shrd.NumBlots(); b++ {
+			if shrd.Count(b) <= 1 {
+				continue
+			}
+			rs := shrd.ReadStateForBlotAt(b, 0)
+			for {
+				docid, err := rs.Next()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				if docid >= nDocs {
+					return nil, fmt.Errorf("docid %d out of range", docid)
+				}
+				counts[docid]++
+			}
+		}
+	}
+	var docs []uint64
+	for did, ct := range counts {
+		if ct != 0 {
+			docs = append(docs, uint64(did))
+		}
+	}
+	sort.SliceStable(docs, func(i, j int) bool {
+		return counts[docs[i]] > counts[docs[j]]
+	})
+	return docs, nil
+}
+
+// blotPerm is a permutation of the n blots of an index
+// determined by a seed, computed a blot at a time rather
+// than held in memory.  A Feistel network permutes the
+// numbers of 2*half bits, the fewest with n of them, and
+// at walks its values of n or more through it again until
+// they are blots.  There are less than 4n such numbers,
+// so the walk takes less than 4 steps on average.
+type blotPerm struct {
+	n    uint64
+	half uint
+	keys [4]uint64
+}
+
+func newBlotPerm(n uint64, seed int64) *blotPerm {
+	p := &blotPerm{n: n}
+	for uint64(1)<<(2*p.half) < n {
+		p.half++
+	}
+	k := uint64(seed)
+	for i := range p.keys {
+		k = splitmix.Mix(k + 0x9e3779b97f4a7c15)
+		p.keys[i] = k
+	}
+	return p
+}
+
+// at returns the i'th blot of p, for i less than p.n.
+func (p *blotPerm) at(i uint64) uint32 {
+	for {
+		i = p.feistel(i)
+		if i < p.n {
+			return uint32(i)
+		}
+	}
+}
+
+func (p *blotPerm) feistel(v uint64) uint64 {
+	mask := uint64(1)<<p.half - 1
+	l, r := v>>p.half, v&mask
+	for _, k := range p.keys {
+		l, r = r, l^(splitmix.Mix(r^k)&mask)
+	}
+	return l<<p.half | r
+}
@@ -0,0 +1,358 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var queryTestDocs = []string{
+	"the quick brown fox jumps over the lazy dog while the cat sleeps on the mat all day long.",
+	"yesterday the quick brown fox jumps over the lazy dog while the cat sleeps on the mat all day long.",
+	"a completely different text which shares nothing with the others at all in any way whatsoever",
+	"the quick brown fox jumps over the lazy dog while nobody watches the garden",
+	"nothing to see here except words which are not duplicated anywhere else in this small corpus",
+}
+
+// testIndex creates an index under a temporary directory
+// from files containing docs and returns the index root.
+func testIndex(t *testing.T, docs []string) string {
+	t.Helper()
+	return testIndexConfig(t, docs, nil)
+}
+
+// testIndexConfig is like testIndex, but applies edit to
+// the config of the index if it is not nil.
+func testIndexConfig(t *testing.T, docs []string, edit func(cfg *Config)) string {
+	t.Helper()
+	tmp, err := ioutil.TempDir(".", "dupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(tmp) })
+	cfg, err := NewConfig(filepath.Join(tmp, "dupi"), 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if edit != nil {
+		edit(cfg)
+	}
+	root := cfg.IndexRoot
+	idxr, err := IndexerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range docs {
+		path := filepath.Join(tmp, fmt.Sprintf("doc%d", i))
+		if err := ioutil.WriteFile(path, []byte(d), 0644); err != nil {
+			t.Fatal(err)
+		}
+		doc := &Doc{Path: path, End: uint64(len(d)), Dat: []byte(d)}
+		if err := idxr.Add(doc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return root
+}
+
+func queryBlots(t *testing.T, q *Query) []uint32 {
+	t.Helper()
+	var res []uint32
+	shape := make([]Blot, 3)
+	for {
+		n, err := q.Next(shape)
+		if err == io.EOF {
+			return res
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := range shape[:n] {
+			if len(shape[i].Docs) < 2 {
+				t.Errorf("blot %x has %d docs", shape[i].Blot, len(shape[i].Docs))
+			}
+			res = append(res, shape[i].Blot)
+			shape[i].Docs = nil
+		}
+	}
+}
+
+func sortedBlots(bs []uint32) []uint32 {
+	res := append([]uint32(nil), bs...)
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
+
+func TestQueryStrategies(t *testing.T) {
+	idx, err := OpenIndex(testIndex(t, queryTestDocs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	want := sortedBlots(queryBlots(t, idx.StartQuery(QueryMaxBlot)))
+	if len(want) == 0 {
+		t.Fatal("no duplicated blots")
+	}
+	for _, s := range []QueryStrategy{QueryMaxDoc, QueryRandom} {
+		got := sortedBlots(queryBlots(t, idx.StartQuery(s)))
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("%s: got blots %x want %x", s, got, want)
+		}
+	}
+	a := queryBlots(t, idx.StartQuerySeed(QueryRandom, 7))
+	b := queryBlots(t, idx.StartQuerySeed(QueryRandom, 7))
+	if fmt.Sprint(a) != fmt.Sprint(b) {
+		t.Errorf("same seed gave different orders")
+	}
+}
+
+func TestBlotPerm(t *testing.T) {
+	for _, n := range []uint64{0, 1, 2, 3, 16, 17, 1000, 1 << 16, 3 << 16} {
+		p := newBlotPerm(n, 1)
+		seen := make([]bool, n)
+		var fixed uint64
+		for i := uint64(0); i < n; i++ {
+			b := uint64(p.at(i))
+			if b >= n || seen[b] {
+				t.Fatalf("n=%d: blot %d of %d repeated or out of range", n, b, i)
+			}
+			seen[b] = true
+			if b == i {
+				fixed++
+			}
+		}
+		if n >= 1000 && fixed > n/100 {
+			t.Errorf("n=%d: %d blots in place", n, fixed)
+		}
+	}
+	a, b := newBlotPerm(1000, 1), newBlotPerm(1000, 2)
+	same := 0
+	for i := uint64(0); i < 1000; i++ {
+		if a.at(i) == b.at(i) {
+			same++
+		}
+	}
+	if same > 10 {
+		t.Errorf("seeds 1 and 2 agree on %d of 1000 blots", same)
+	}
+}
+
+func TestQueryNextEOF(t *testing.T) {
+	idx, err := OpenIndex(testIndex(t, queryTestDocs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	q := idx.StartQuery(QueryMaxBlot)
+	// a shape larger than the number of blots, so that
+	// Next returns the last blots without io.EOF.
+	n, err := q.Next(make([]Blot, 1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Fatal("no duplicated blots")
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := q.Next(make([]Blot, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != io.EOF {
+			t.Errorf("got %v want io.EOF", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Next did not return after the last blots")
+	}
+}
+
+func TestQuerySeek(t *testing.T) {
+	docs := make([]string, 0, 12)
+	for i := 0; i < cap(docs); i++ {
+		docs = append(docs, fmt.Sprintf("%s copy %d.", queryTestDocs[i*i%len(queryTestDocs)], i))
+	}
+	idx, err := OpenIndex(testIndex(t, docs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	all := queryBlots(t, idx.StartQuery(QueryMaxBlot))
+	if len(all) < 2 {
+		t.Fatalf("only %d duplicated blots", len(all))
+	}
+	for k, blot := range all {
+		q := idx.StartQuery(QueryMaxBlot)
+		if err := q.Seek(blot); err != nil {
+			t.Fatal(err)
+		}
+		if got := queryBlots(t, q); fmt.Sprint(got) != fmt.Sprint(all[k:]) {
+			t.Errorf("seek to %d'th blot %x got %x want %x", k, blot, got, all[k:])
+		}
+	}
+	if err := idx.StartQuery(QueryRandom).Seek(all[0]); !errors.Is(err, ErrInvalidQueryState) {
+		t.Errorf("seek in random query gave %v", err)
+	}
+}
+
+func TestParseQueryStrategy(t *testing.T) {
+	for _, s := range []QueryStrategy{QueryMaxBlot, QueryMaxDoc, QueryRandom} {
+		p, err := ParseQueryStrategy(s.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p != s {
+			t.Errorf("got %s want %s", p, s)
+		}
+	}
+	if _, err := ParseQueryStrategy("nope"); err == nil {
+		t.Errorf("no error for unknown strategy")
+	}
+}
+
+// extractDocs returns the blots and documents found by a
+// query of idx, as dupi extract does.
+func extractDocs(idx *Index) (string, error) {
+	q := idx.StartQuery(QueryMaxBlot)
+	shape := make([]Blot, 3)
+	var res []string
+	for {
+		n, err := q.Next(shape)
+		if err == io.EOF {
+			sort.Strings(res)
+			return strings.Join(res, "\n"), nil
+		}
+		if err != nil {
+			return "", err
+		}
+		for i := range shape[:n] {
+			res = append(res, fmt.Sprintf("%x %v", shape[i].Blot, shape[i].Docs))
+			shape[i].Docs = nil
+		}
+	}
+}
+
+// likeDocs returns the indexed documents sharing text with
+// doc, as dupi like does.
+func likeDocs(idx *Index, doc *Doc) (string, error) {
+	wins, err := idx.docWindows(nil, doc)
+	if err != nil {
+		return "", err
+	}
+	texts := make(map[uint32][]byte, len(wins))
+	checks := make(map[uint32]uint32, len(wins))
+	for i := range wins {
+		texts[wins[i].blot] = doc.text(&wins[i])
+		checks[wins[i].blot] = wins[i].check
+	}
+	q := idx.StartQuery(QueryMaxBlot)
+	var res []string
+	for blot := range texts {
+		b := &Blot{Blot: blot}
+		if err := q.GetChecked(b, checks[blot]); err != nil {
+			return "", err
+		}
+		for i := range b.Docs {
+			d := &b.Docs[i]
+			found, err := idx.FindBlots(texts, d)
+			if err != nil {
+				return "", err
+			}
+			if len(found) != 0 {
+				res = append(res, fmt.Sprintf("%s %d:%d", d.Path, d.Start, d.End))
+			}
+		}
+	}
+	sort.Strings(res)
+	return strings.Join(res, "\n"), nil
+}
+
+// TestQueryConcurrent runs extract and like queries in
+// parallel on one Index, and is meant to be run with the
+// race detector.
+func TestQueryConcurrent(t *testing.T) {
+	// many copies, so that queries look up many documents.
+	docs := make([]string, 0, 20*len(queryTestDocs))
+	for i := 0; i < cap(docs); i++ {
+		docs = append(docs, fmt.Sprintf("%s copy %d.", queryTestDocs[i%len(queryTestDocs)], i))
+	}
+	root := testIndex(t, docs)
+	for _, opts := range [][]IndexOption{nil, {WithMmap()}} {
+		idx, err := OpenIndex(root, opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		extract := queryDocs(t, idx)
+		likes := make([]string, len(queryTestDocs))
+		for i, d := range queryTestDocs {
+			likes[i], err = likeDocs(idx, &Doc{Dat: []byte(d), End: uint64(len(d))})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		var wg sync.WaitGroup
+		errs := make(chan error, 16)
+		for g := 0; g < cap(errs); g++ {
+			wg.Add(1)
+			go func(g int) {
+				defer wg.Done()
+				for n := 0; n < 10; n++ {
+					var (
+						got, want string
+						err       error
+					)
+					if g%2 == 0 {
+						got, err = extractDocs(idx)
+						want = extract
+					} else {
+						i := (g + n) % len(queryTestDocs)
+						d := queryTestDocs[i]
+						got, err = likeDocs(idx, &Doc{Dat: []byte(d), End: uint64(len(d))})
+						want = likes[i]
+					}
+					if err == nil && got != want {
+						err = fmt.Errorf("goroutine %d got\n%s\nwant\n%s", g, got, want)
+					}
+					if err != nil {
+						errs <- err
+						return
+					}
+				}
+			}(g)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			t.Error(err)
+		}
+		if err := idx.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
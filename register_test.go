@@ -0,0 +1,188 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-air/dupi/blotter"
+	"github.com/go-air/dupi/token"
+)
+
+func init() {
+	token.Register("test.lower", func(cfg *token.Config) (token.TokenizerFunc, error) {
+		if cfg.Params["case"] != "lower" {
+			return nil, fmt.Errorf("unsupported case %q", cfg.Params["case"])
+		}
+		return func(dst []token.T, dat []byte, offset uint64) []token.T {
+			start := len(dst)
+			dst = token.Tokenize(dst, dat, offset)
+			for i := range dst[start:] {
+				tok := &dst[start+i]
+				tok.Norm = bytes.ToLower(tok.Lit)
+			}
+			return dst
+		}, nil
+	})
+}
+
+func TestRegisteredTokenizer(t *testing.T) {
+	docs := []string{
+		"The Quick Brown Fox Jumps Over The Lazy Dog Again And Again Today.",
+		"the quick brown fox jumps over the lazy dog again and again today."}
+	tmp, err := ioutil.TempDir(".", "dupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cfg, err := NewConfig(filepath.Join(tmp, "dupi"), 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.TokenConfig = token.Config{Name: "test.lower", Params: map[string]string{"case": "upper"}}
+	if _, err := IndexerFromConfig(cfg); err == nil {
+		t.Fatal("expected error for invalid params")
+	}
+	cfg.TokenConfig.Params["case"] = "lower"
+	idxr, err := IndexerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, d := range docs {
+		path := filepath.Join(tmp, fmt.Sprintf("doc%d", i))
+		if err := ioutil.WriteFile(path, []byte(d), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := idxr.Add(&Doc{Path: path, End: uint64(len(d)), Dat: []byte(d)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := OpenIndex(cfg.IndexRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shape := make([]Blot, 1)
+	_, err = idx.StartQuery(QueryMaxBlot).Next(shape)
+	idx.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shape[0].Docs) != 2 {
+		t.Errorf("case folded docs don't share blots: %v", shape[0].Docs)
+	}
+
+	cfg.TokenConfig.Name = "test.missing"
+	if err := cfg.Write(); err != nil {
+		t.Fatal(err)
+	}
+	_, err = OpenIndex(cfg.IndexRoot)
+	if !errors.Is(err, token.ErrUnregistered) {
+		t.Errorf("opening index with unregistered tokenizer gave %v", err)
+	}
+}
+
+// narrow is a blotter which only implements blotter.T, as
+// blotters registered before blotters could be Wide.
+type narrow struct {
+	c *blotter.Circ
+}
+
+func (n narrow) Config() *blotter.Config {
+	res := n.c.Config()
+	res.Name = "test.narrow"
+	return res
+}
+
+func (n narrow) Blot(tok []byte) uint32 { return n.c.Blot(tok) }
+func (n narrow) Interleaving() int      { return 1 }
+
+func init() {
+	blotter.Register("test.narrow", func(cfg *blotter.Config) (blotter.T, error) {
+		return narrow{blotter.NewCirc(cfg.SeqLen)}, nil
+	})
+}
+
+func TestRegisteredBlotter(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "dupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cfg, err := NewConfig(filepath.Join(tmp, "dupi"), 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.BlotConfig.Name = "test.narrow"
+	cfg.BlotConfig.Width = 64
+	if _, err := IndexerFromConfig(cfg); err == nil {
+		t.Fatal("expected error for wide blots of a narrow blotter")
+	}
+	cfg.BlotConfig.Width = 0
+	idxr, err := IndexerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		path := filepath.Join(tmp, fmt.Sprintf("doc%d", i))
+		d := queryTestDocs[0]
+		if err := ioutil.WriteFile(path, []byte(d), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := idxr.Add(&Doc{Path: path, End: uint64(len(d)), Dat: []byte(d)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	idx, err := OpenIndex(cfg.IndexRoot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	shape := make([]Blot, 1)
+	if _, err := idx.StartQuery(QueryMaxBlot).Next(shape); err != nil {
+		t.Fatal(err)
+	}
+	if len(shape[0].Docs) != 2 {
+		t.Errorf("copies don't share blots: %v", shape[0].Docs)
+	}
+}
+
+func TestUnregisteredBlotter(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "dupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	cfg, err := NewConfig(filepath.Join(tmp, "dupi"), 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.BlotConfig.Name = "test.missing"
+	_, err = IndexerFromConfig(cfg)
+	if !errors.Is(err, blotter.ErrUnregistered) {
+		t.Errorf("creating index with unregistered blotter gave %v", err)
+	}
+}
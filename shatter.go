@@ -18,28 +18,42 @@ import (
 	"sync"
 
 	"github.com/go-air/dupi/blotter"
+	"github.com/go-air/dupi/minhash"
 	"github.com/go-air/dupi/post"
+	"github.com/go-air/dupi/simhash"
 	"github.com/go-air/dupi/token"
 )
 
 type shatterReq struct {
-	docid    uint32
-	offset   uint32
+	docid    uint64
+	offset   uint64
 	d        []byte
 	shutdown bool
 }
 
-func startShatter(ns, n, s int, lastDid uint32,
+func startShatter(ns, n int, nBlots uint64, s int, lastDid uint64,
 	tf token.TokenizerFunc, blotcfg *blotter.Config,
-	chns []chan []post.T) (chan *shatterReq, error) {
+	taboo map[uint64]bool, sigs *minhash.Adder, fps *simhash.Adder,
+	chns []chan []post.T) (chan *shatterReq, *mono, error) {
 	rch := make(chan *shatterReq)
 	mono := newMono(lastDid)
 	for i := 0; i < ns; i++ {
 		bler, err := blotter.FromConfig(blotcfg)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
+		}
+		sh := newShatter(n, nBlots, newBlotSel(bler, s, blotcfg.Winnow), tf, mono)
+		sh.check = checkMask(blotcfg)
+		sh.taboo = taboo
+		if sigs != nil {
+			sh.sigs = sigs
+			sh.mh = minhash.New(sigs.SigLen())
+			sh.sig = make([]uint32, sigs.SigLen())
+		}
+		if fps != nil {
+			sh.fps = fps
+			sh.sh = simhash.New()
 		}
-		sh := newShatter(n, s, tf, bler, mono)
 		copy(sh.shardChns, chns)
 		go func(sh *shatter) {
 			for {
@@ -54,34 +68,60 @@ func startShatter(ns, n, s int, lastDid uint32,
 			}
 		}(sh)
 	}
-	return rch, nil
+	return rch, mono, nil
 }
 
 type mono struct {
-	docid uint32
+	docid uint64
 	cond  *sync.Cond
 }
 
-func newMono(docid uint32) *mono {
+func newMono(docid uint64) *mono {
 	var mu sync.Mutex
 	return &mono{cond: sync.NewCond(&mu), docid: docid}
 }
 
+// wait waits until the posts of all documents with ids
+// up to docid have been sent to the shards.
+func (m *mono) wait(docid uint64) {
+	m.cond.L.Lock()
+	defer m.cond.L.Unlock()
+	for m.docid < docid {
+		m.cond.Wait()
+	}
+}
+
 type shatter struct {
-	tokfn     token.TokenizerFunc
-	tokb      []token.T
-	bler      blotter.T
-	seqlen    int
+	tokfn token.TokenizerFunc
+	tokb  []token.T
+	sel   *blotSel
+	// the number of blots over all shards.
+	nBlots uint64
+	// the mask of the upper 32 bits of blots posted as
+	// checks.
+	check     uint32
 	d         [][]post.T
 	shardChns []chan []post.T
 	mono      *mono
+	// blots not to post, shared read-only
+	taboo map[uint64]bool
+	// if not nil, where the MinHash signatures of the
+	// blots selected for documents are added, in order
+	// of docid.
+	sigs *minhash.Adder
+	mh   *minhash.Hasher
+	sig  []uint32
+	// likewise for SimHash fingerprints of the words of
+	// documents.
+	fps *simhash.Adder
+	sh  *simhash.Hasher
 }
 
-func newShatter(n, s int, tf token.TokenizerFunc, bler blotter.T, mono *mono) *shatter {
+func newShatter(n int, nBlots uint64, sel *blotSel, tf token.TokenizerFunc, mono *mono) *shatter {
 	res := &shatter{
 		tokfn:     tf,
-		bler:      bler,
-		seqlen:    s,
+		sel:       sel,
+		nBlots:    nBlots,
 		shardChns: make([]chan []post.T, n),
 		d:         make([][]post.T, n),
 		mono:      mono}
@@ -91,28 +131,158 @@ func newShatter(n, s int, tf token.TokenizerFunc, bler blotter.T, mono *mono) *s
 	return res
 }
 
-func (s *shatter) do(did, offset uint32, msg []byte) {
+func (s *shatter) do(did, offset uint64, msg []byte) {
 	s.tokb = s.tokfn(s.tokb[:0], msg, offset)
-	var (
-		words = 0
-		b     = uint32(0)
-	)
+	s.sel.reset()
+	if s.mh != nil {
+		s.mh.Reset(s.sig)
+	}
+	if s.sh != nil {
+		s.sh.Reset()
+	}
 	for i := range s.tokb {
 		tok := &s.tokb[i]
 		switch tok.Tag {
 		case token.Word:
-			b = s.bler.Blot(tok.Lit)
-			words++
-			if words > s.seqlen {
-				s.blot(did, b)
+			if s.sh != nil {
+				s.sh.Add(tok.Key())
+			}
+			for _, sb := range s.sel.add(tok.Key()) {
+				s.blot(did, sb.blot)
 			}
 		default:
 		}
 	}
+	for _, sb := range s.sel.end() {
+		s.blot(did, sb.blot)
+	}
 	s.send(did)
 }
 
-func (s *shatter) send(did uint32) {
+// blotSel blots the words of a document and selects the
+// blots which are posted for it.
+//
+// Without winnowing, the blot of every word after the
+// first seqLen is posted.  With winnowing, the blots
+// of every word from the seqLen'th on, whose windows are
+// full, are winnowed.  Blotters giving several blots per
+// word, which are of seqLen+1 words, start at the word
+// after, and the blots of each window are winnowed apart.
+type blotSel struct {
+	bler blotter.T
+	// bler as a Wide, Multi or WideMulti blotter, if it
+	// is one.
+	wide      blotter.Wide
+	multi     blotter.Multi
+	wideMulti blotter.WideMulti
+	blots32   []uint32
+	// skips[k] is the skip of the k'th blot of a word,
+	// 0 for blotters giving one blot per word.
+	skips   []int
+	seqLen  int
+	first   int
+	winnows []*blotter.Winnow
+	words   int
+	blots   []uint64
+	sel     []selBlot
+}
+
+// selBlot is a blot selected by a blotSel.  The window of
+// the blot ends at word, and if skip is not 0, leaves out
+// the word skip words before it.
+type selBlot struct {
+	word, skip int
+	blot       uint64
+}
+
+// newBlotSel creates a blotSel for bler and seqLen,
+// winnowing windows of w blots if w > 1.
+func newBlotSel(bler blotter.T, seqLen, w int) *blotSel {
+	res := &blotSel{bler: bler, seqLen: seqLen, skips: []int{0}, first: seqLen}
+	res.wide, _ = bler.(blotter.Wide)
+	if m, ok := bler.(blotter.Multi); ok {
+		res.multi = m
+		res.wideMulti, _ = bler.(blotter.WideMulti)
+		res.skips = m.Skips()
+	} else if w > 1 {
+		res.first = seqLen - 1
+	}
+	if w > 1 {
+		res.winnows = make([]*blotter.Winnow, len(res.skips))
+		for k := range res.winnows {
+			res.winnows[k] = blotter.NewWinnow(w)
+		}
+	}
+	return res
+}
+
+// newBlotSelAll is like newBlotSel, but if w > 1 selects
+// all the blots winnowing chooses from.
+func newBlotSelAll(bler blotter.T, seqLen, w int) *blotSel {
+	res := newBlotSel(bler, seqLen, w)
+	for k := range res.winnows {
+		res.winnows[k] = blotter.NewWinnow(1)
+	}
+	return res
+}
+
+// reset starts a new document.
+func (s *blotSel) reset() {
+	s.words = 0
+	for _, w := range s.winnows {
+		w.Reset()
+	}
+}
+
+// add blots the next word, with key, returning the blots
+// selected.  The result is valid until the next call to
+// add or end.
+func (s *blotSel) add(key []byte) []selBlot {
+	switch {
+	case s.wideMulti != nil:
+		s.blots = s.wideMulti.Blots64(s.blots[:0], key)
+	case s.multi != nil:
+		s.blots32 = s.multi.Blots(s.blots32[:0], key)
+		s.blots = s.blots[:0]
+		for _, b := range s.blots32 {
+			s.blots = append(s.blots, uint64(b))
+		}
+	case s.wide != nil:
+		s.blots = append(s.blots[:0], s.wide.Blot64(key))
+	default:
+		s.blots = append(s.blots[:0], uint64(s.bler.Blot(key)))
+	}
+	word := s.words
+	s.words++
+	s.sel = s.sel[:0]
+	if word < s.first {
+		return nil
+	}
+	for k, b := range s.blots {
+		if s.winnows == nil {
+			s.sel = append(s.sel, selBlot{word: word, skip: s.skips[k], blot: b})
+			continue
+		}
+		if pos, b, ok := s.winnows[k].Add(b); ok {
+			s.sel = append(s.sel, selBlot{word: pos + s.first, skip: s.skips[k], blot: b})
+		}
+	}
+	return s.sel
+}
+
+// end ends the document, returning the last blots
+// selected, as add.
+func (s *blotSel) end() []selBlot {
+	s.sel = s.sel[:0]
+	for k, w := range s.winnows {
+		if pos, b, ok := w.End(); ok {
+			s.sel = append(s.sel, selBlot{word: pos + s.first, skip: s.skips[k], blot: b})
+		}
+	}
+	return s.sel
+}
+
+func (s *shatter) send(did uint64) {
 	s.mono.cond.L.Lock()
 	for s.mono.docid != did-1 {
 		s.mono.cond.Wait()
@@ -130,13 +300,44 @@ func (s *shatter) send(did uint32) {
 		}(i, ps)
 	}
 	wg.Wait()
+	if s.sigs != nil {
+		s.sigs.Add(did, s.sig)
+	}
+	if s.fps != nil {
+		s.fps.Add(did, s.sh.Sum())
+	}
 	s.mono.docid = did
 	s.mono.cond.Broadcast()
 	s.mono.cond.L.Unlock()
 }
 
-func (s *shatter) blot(docid, b uint32) {
+// blot posts the blot b selected for document docid,
+// unless it is taboo.  Taboo blots are still part of the
+// MinHash signature of the document, which must not depend
+// on the taboo list at the time of indexing.
+//
+// The lower 32 bits of b are reduced to the blot space of
+// the index, and its upper bits are posted as the check of
+// the post.
+func (s *shatter) blot(docid uint64, b uint64) {
+	lo := uint32(b)
+	if s.mh != nil {
+		s.mh.Add(s.sig, lo)
+	}
+	if s.taboo[b] {
+		return
+	}
+	lo = uint32(uint64(lo) % s.nBlots)
 	n := uint32(len(s.d))
-	i := b % n
-	s.d[i] = append(s.d[i], post.Make(docid, b/n))
+	i := lo % n
+	s.d[i] = append(s.d[i], post.MakeCheck(docid, lo/n, uint32(b>>32)&s.check))
+}
+
+// checkMask returns the mask of the upper 32 bits of the
+// blots of cfg which are kept as checks.
+func checkMask(cfg *blotter.Config) uint32 {
+	if cfg.Width <= 32 {
+		return 0
+	}
+	return uint32(1<<uint(cfg.Width-32) - 1)
 }
@@ -0,0 +1,93 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simhash
+
+import "github.com/go-air/dupi/internal/docrec"
+
+// The fingerprint file of an index, like its dmd file, is
+// a sequence of 8 byte records, one per document id.
+// Record i holds the fingerprint of document i.  Record 0,
+// which is not a document, is a header with Magic and
+// Version.
+const (
+	Magic   = 0x647368ff // "dsh\xff"
+	Version = 1
+)
+
+// Adder appends fingerprints to a fingerprint file.
+type Adder struct {
+	*docrec.WordAdder
+	buf [2]uint32
+}
+
+// NewAdder opens the fingerprint file at path for
+// appending, creating it if it does not exist.
+func NewAdder(path string) (*Adder, error) {
+	a, err := docrec.NewWordAdder(path, Magic, Version, 2)
+	if err != nil {
+		return nil, err
+	}
+	return &Adder{WordAdder: a}, nil
+}
+
+// Add adds fp as the fingerprint of document did, which
+// must be the next document.  Errors are kept and returned
+// by Sync and Close.
+func (a *Adder) Add(did uint64, fp uint64) {
+	a.buf = [2]uint32{uint32(fp >> 32), uint32(fp)}
+	a.WordAdder.Add(did, a.buf[:])
+}
+
+// Truncate removes the fingerprints of the documents with
+// ids n and above from the fingerprint file at path.
+func Truncate(path string, n uint64) error {
+	return docrec.Truncate(path, 8, n)
+}
+
+// File reads a fingerprint file.  A File may be used by
+// many goroutines at once.
+type File struct {
+	*docrec.WordFile
+}
+
+// Open opens the fingerprint file at path.
+func Open(path string) (*File, error) {
+	f, err := docrec.OpenWords(path, Magic, Version, 2)
+	if err != nil {
+		return nil, err
+	}
+	return &File{WordFile: f}, nil
+}
+
+// Fingerprint returns the fingerprint of document did.
+func (f *File) Fingerprint(did uint64) (uint64, error) {
+	var buf [2]uint32
+	if _, err := f.RecordWords(buf[:0], did); err != nil {
+		return 0, err
+	}
+	return join(buf[:]), nil
+}
+
+// Each calls fn with every document id of f and its
+// fingerprint.
+func (f *File) Each(fn func(did, fp uint64) error) error {
+	return f.EachWords(func(did uint64, words []uint32) error {
+		return fn(did, join(words))
+	})
+}
+
+func join(words []uint32) uint64 {
+	return uint64(words[0])<<32 | uint64(words[1])
+}
@@ -0,0 +1,97 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package simhash provides 64 bit SimHash fingerprints of
+// the token streams of documents, the file in which an
+// index keeps them, and permuted sorted tables for finding
+// fingerprints within a Hamming distance of a fingerprint.
+//
+// The features of a stream of tokens are its distinct
+// pairs of consecutive tokens, and its first token.  The
+// fingerprint of the stream has bit i set if more features
+// have a hash with bit i set than not.  Streams with many
+// features in common have fingerprints differing in few
+// bits.  Since features are counted once, frequent words
+// do not dominate fingerprints.
+package simhash
+
+import (
+	"bytes"
+	"hash"
+	"hash/fnv"
+	"math/bits"
+
+	"github.com/go-air/dupi/internal/splitmix"
+)
+
+// Hasher computes the fingerprint of a stream of tokens.
+type Hasher struct {
+	// counts[i] is the number of features with bit i set
+	// less the number without.
+	counts [64]int32
+	// the hash of the last token, and the features seen.
+	last uint64
+	seen map[uint64]bool
+	fn   hash.Hash64
+}
+
+func New() *Hasher {
+	return &Hasher{fn: fnv.New64a(), seen: make(map[uint64]bool)}
+}
+
+// Reset starts a new stream.
+func (h *Hasher) Reset() {
+	h.counts = [64]int32{}
+	h.last = 0
+	for v := range h.seen {
+		delete(h.seen, v)
+	}
+}
+
+// Add adds the token with key tok to the stream.
+func (h *Hasher) Add(tok []byte) {
+	h.fn.Reset()
+	h.fn.Write(bytes.ToLower(tok))
+	th := h.fn.Sum64()
+	v := splitmix.Mix(h.last*0x100000001b3 ^ th)
+	h.last = th
+	if h.seen[v] {
+		return
+	}
+	h.seen[v] = true
+	for i := range h.counts {
+		if v&(1<<uint(i)) != 0 {
+			h.counts[i]++
+		} else {
+			h.counts[i]--
+		}
+	}
+}
+
+// Sum returns the fingerprint of the stream.
+func (h *Hasher) Sum() uint64 {
+	var res uint64
+	for i, c := range h.counts {
+		if c > 0 {
+			res |= 1 << uint(i)
+		}
+	}
+	return res
+}
+
+// Distance returns the Hamming distance between the
+// fingerprints a and b.
+func Distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
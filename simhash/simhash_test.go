@@ -0,0 +1,112 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simhash
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"testing"
+)
+
+func sum(words []string) uint64 {
+	h := New()
+	for _, w := range words {
+		h.Add([]byte(w))
+	}
+	return h.Sum()
+}
+
+func TestHasher(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	words := make([]string, 500)
+	for i := range words {
+		words[i] = fmt.Sprintf("w%d", r.Intn(100000))
+	}
+	near := append([]string(nil), words...)
+	for i := 0; i < 10; i++ {
+		near[r.Intn(len(near))] = "x"
+	}
+	other := make([]string, 500)
+	for i := range other {
+		other[i] = fmt.Sprintf("w%d", r.Intn(100000))
+	}
+	a, b, c := sum(words), sum(near), sum(other)
+	if d := Distance(a, b); d > 8 {
+		t.Errorf("near streams have distance %d", d)
+	}
+	if d := Distance(a, c); d < 16 {
+		t.Errorf("unrelated streams have distance %d", d)
+	}
+	if sum([]string{"Foo"}) != sum([]string{"foo"}) {
+		t.Errorf("fingerprints depend on case")
+	}
+}
+
+func TestTables(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "simhash")
+	a, err := NewAdder(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := rand.New(rand.NewSource(2))
+	fps := []uint64{0}
+	for did := uint64(1); did < 2000; did++ {
+		fp := r.Uint64()
+		if did%2 == 0 {
+			// near the previous one.
+			fp = fps[did-1]
+			for i := r.Intn(6); i > 0; i-- {
+				fp ^= 1 << uint(r.Intn(64))
+			}
+		}
+		fps = append(fps, fp)
+		a.Add(did, fp)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+	f, err := Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if fp, err := f.Fingerprint(7); err != nil || fp != fps[7] {
+		t.Errorf("fingerprint 7 read as %x %v, want %x", fp, err, fps[7])
+	}
+	for _, d := range []int{0, 3, 5} {
+		tabs, err := NewTables(f, d, func(did uint64) bool { return did != 3 })
+		if err != nil {
+			t.Fatal(err)
+		}
+		for q := 1; q < 200; q++ {
+			var want []uint64
+			for did := uint64(1); did < uint64(len(fps)); did++ {
+				if did != 3 && Distance(fps[did], fps[q]) <= d {
+					want = append(want, did)
+				}
+			}
+			if got := tabs.Find(fps[q]); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("d=%d: found %v near %d, want %v", d, got, q, want)
+			}
+		}
+	}
+	if err := Truncate(path, 10); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Len(); err != nil || n != 10 {
+		t.Errorf("truncated file has %d records: %v", n, err)
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package simhash
+
+import "sort"
+
+// Tables finds the fingerprints within a Hamming distance
+// d of a fingerprint, as the permuted sorted tables of
+// Manku, Jain and Das Sarma.  Fingerprints are cut into d+1
+// blocks of bits, so that any two fingerprints within
+// distance d agree on some block, and there is a table of
+// the fingerprints sorted by each block.  A query looks up
+// the fingerprints agreeing with it on each block in turn.
+type Tables struct {
+	d      int
+	blocks []block
+}
+
+// block is a table of fingerprints sorted by the bits
+// shift to shift+width of fingerprints.
+type block struct {
+	shift, width uint
+	ents         []entry
+}
+
+type entry struct {
+	fp, did uint64
+}
+
+func (b *block) key(fp uint64) uint64 {
+	if b.width == 64 {
+		return fp
+	}
+	return fp >> b.shift & (1<<b.width - 1)
+}
+
+// NewTables creates Tables for distance d, between 0 and
+// 63, of the fingerprints of the documents of f for which
+// keep returns true.
+func NewTables(f *File, d int, keep func(did uint64) bool) (*Tables, error) {
+	n := d + 1
+	res := &Tables{d: d, blocks: make([]block, n)}
+	for i := range res.blocks {
+		lo, hi := i*64/n, (i+1)*64/n
+		res.blocks[i] = block{shift: uint(lo), width: uint(hi - lo)}
+	}
+	var ents []entry
+	err := f.Each(func(did, fp uint64) error {
+		if keep(did) {
+			ents = append(ents, entry{fp: fp, did: did})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	for i := range res.blocks {
+		b := &res.blocks[i]
+		b.ents = append([]entry(nil), ents...)
+		sort.Slice(b.ents, func(i, j int) bool {
+			return b.key(b.ents[i].fp) < b.key(b.ents[j].fp)
+		})
+	}
+	return res, nil
+}
+
+// Find returns the documents whose fingerprints are within
+// distance t.d of fp, in increasing order.
+func (t *Tables) Find(fp uint64) []uint64 {
+	seen := make(map[uint64]bool)
+	for i := range t.blocks {
+		b := &t.blocks[i]
+		key := b.key(fp)
+		j := sort.Search(len(b.ents), func(j int) bool { return b.key(b.ents[j].fp) >= key })
+		for ; j < len(b.ents) && b.key(b.ents[j].fp) == key; j++ {
+			if e := &b.ents[j]; Distance(e.fp, fp) <= t.d {
+				seen[e.did] = true
+			}
+		}
+	}
+	res := make([]uint64, 0, len(seen))
+	for did := range seen {
+		res = append(res, did)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res
+}
@@ -0,0 +1,116 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/go-air/dupi/simhash"
+)
+
+// ErrNoSimHash is returned by SimilarDocs for indices
+// created without SimHash fingerprints.
+var ErrNoSimHash = errors.New("index has no SimHash fingerprints")
+
+// SimilarDoc is a document found by SimilarDocs, with the
+// Hamming distance of its SimHash fingerprint from that of
+// the query document.
+type SimilarDoc struct {
+	Doc
+	Distance int
+}
+
+// similarIndex holds the search tables of the fingerprints
+// of an Index for each distance, built when first used.
+type similarIndex struct {
+	mu     sync.Mutex
+	tables map[int]*simhash.Tables
+}
+
+// SimilarDocs returns the documents of x whose SimHash
+// fingerprints differ from that of doc in at most maxBits
+// bits, between 0 and 63, in increasing order of distance.
+// doc itself, if it is in x, is not returned.
+//
+// Fingerprints are computed from the words of documents,
+// so that documents with most of their text in common have
+// nearby fingerprints.  The search tables for maxBits are
+// built in memory on the first call with it and only have
+// the documents of x as of then.  Each table has a copy of
+// every fingerprint, and there are maxBits+1 tables, so
+// small values of maxBits are cheaper.
+func (x *Index) SimilarDocs(doc *Doc, maxBits int) ([]SimilarDoc, error) {
+	if x.fps == nil {
+		return nil, ErrNoSimHash
+	}
+	if maxBits < 0 || maxBits > 63 {
+		return nil, fmt.Errorf("invalid SimHash distance %d", maxBits)
+	}
+	if doc.Dat == nil {
+		if err := doc.Load(); err != nil {
+			return nil, err
+		}
+	}
+	tables, err := x.simTables(maxBits)
+	if err != nil {
+		return nil, err
+	}
+	h := simhash.New()
+	for _, tok := range x.docWords(doc) {
+		h.Add(tok.Key())
+	}
+	fp := h.Sum()
+	// the closest documents have the highest score.
+	ms, err := x.matches(doc, tables.Find(fp), func(did uint64) (float64, bool, error) {
+		other, err := x.fps.Fingerprint(did)
+		if err != nil {
+			return 0, false, err
+		}
+		return -float64(simhash.Distance(fp, other)), true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	var res []SimilarDoc
+	for i := range ms {
+		res = append(res, SimilarDoc{Doc: ms[i].doc, Distance: -int(ms[i].score)})
+	}
+	return res, nil
+}
+
+// simTables returns the search tables for distance d of
+// the fingerprints of the documents of x which are not
+// deleted.
+func (x *Index) simTables(d int) (*simhash.Tables, error) {
+	s := x.similar
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tables[d]; ok {
+		return t, nil
+	}
+	t, err := simhash.NewTables(x.fps, d, func(did uint64) bool {
+		return !x.tombs.Has(did)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if s.tables == nil {
+		s.tables = make(map[int]*simhash.Tables)
+	}
+	s.tables[d] = t
+	return t, nil
+}
@@ -0,0 +1,115 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-air/dupi/blotter"
+)
+
+// skipTestDocs returns pairs of documents of 2*seqLen-2
+// random words, the second of each pair with a word
+// inserted in the middle, so that no run of seqLen words
+// is shared.
+func skipTestDocs(n, seqLen int) []string {
+	r := rand.New(rand.NewSource(5))
+	var res []string
+	for i := 0; i < n; i++ {
+		ws := make([]string, 2*seqLen-2)
+		for j := range ws {
+			ws[j] = fmt.Sprintf("w%d", r.Intn(100000))
+		}
+		ins := append(append(append([]string(nil), ws[:seqLen-1]...), "inserted"), ws[seqLen-1:]...)
+		res = append(res, strings.Join(ws, " ")+".", strings.Join(ins, " ")+".")
+	}
+	return res
+}
+
+func TestSkipGram(t *testing.T) {
+	const seqLen = 10
+	docs := skipTestDocs(4, seqLen)
+	for _, fanout := range []int{0, 2, seqLen} {
+		root := testIndexConfig(t, docs, func(cfg *Config) {
+			cfg.BlotConfig.Name = blotter.SkipName
+			cfg.BlotConfig.Fanout = fanout
+		})
+		idx, err := OpenIndex(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < len(docs); i += 2 {
+			d := docs[i+1]
+			got, err := likeDocs(idx, &Doc{Dat: []byte(d), End: uint64(len(d))})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if found := strings.Contains(got, fmt.Sprintf("doc%d ", i)); found != (fanout > 1) {
+				t.Errorf("fanout %d: like doc%d found doc%d: %t", fanout, i+1, i, found)
+			}
+		}
+		if fanout == seqLen {
+			checkSkipPassages(t, idx, docs)
+		}
+		ps, err := idx.Verify()
+		if err != nil || len(ps) != 0 {
+			t.Errorf("fanout %d: problems %v %v", fanout, ps, err)
+		}
+		idx.Close()
+	}
+}
+
+// checkSkipPassages checks that the passages of each pair
+// of skipTestDocs are the whole documents but the first
+// word, which is in no window of seqLen words.
+func checkSkipPassages(t *testing.T, idx *Index, docs []string) {
+	t.Helper()
+	q := idx.StartQuery(QueryMaxBlot)
+	shape := []Blot{{}}
+	for {
+		_, err := q.Next(shape)
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		ps, err := q.Passages(&shape[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// blots with no passages are collisions.
+		if len(ps) > 1 {
+			t.Errorf("blot %x has %d passages", shape[0].Blot, len(ps))
+		}
+		for i := range ps {
+			p := &ps[i]
+			var a, b int
+			fmt.Sscanf(filepath.Base(p.A.Path), "doc%d", &a)
+			fmt.Sscanf(filepath.Base(p.B.Path), "doc%d", &b)
+			start := uint64(strings.Index(docs[a], " ") + 1)
+			if a/2 != b/2 || p.A.Start != start || p.B.Start != start ||
+				p.A.End != uint64(len(docs[a])-1) || p.B.End != uint64(len(docs[b])-1) {
+				t.Errorf("passage %s %d:%d %s %d:%d", p.A.Path, p.A.Start, p.A.End, p.B.Path, p.B.Start, p.B.End)
+			}
+		}
+		shape[0].Docs = nil
+	}
+}
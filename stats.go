@@ -17,17 +17,19 @@ package dupi
 import "fmt"
 
 type Stats struct {
-	Root      string
-	NumDocs   uint64
-	NumPaths  uint64
-	NumPosts  uint64
-	NumBlots  uint64
-	BlotMean  float64
-	BlotSigma float64
+	Root       string
+	NumDocs    uint64
+	NumDeleted uint64
+	NumPaths   uint64
+	NumPosts   uint64
+	NumBlots   uint64
+	BlotMean   float64
+	BlotSigma  float64
 }
 
 const stFmt = `dupi index at %s:
 	- %d docs
+	- %d deleted docs
 	- %d nodes in path tree
 	- %d posts
 	- %d blots
@@ -36,7 +38,7 @@ const stFmt = `dupi index at %s:
 `
 
 func (st *Stats) String() string {
-	return fmt.Sprintf(stFmt, st.Root, st.NumDocs,
+	return fmt.Sprintf(stFmt, st.Root, st.NumDocs, st.NumDeleted,
 		st.NumPaths, st.NumPosts, st.NumBlots,
 		st.BlotMean, st.BlotSigma)
 }
@@ -0,0 +1,158 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/go-air/dupi/blotter"
+	"github.com/go-air/dupi/lock"
+	"github.com/go-air/dupi/token"
+)
+
+// TabooEntry is a named boilerplate text, such as a license
+// header, which is not interesting as duplicated text.
+type TabooEntry struct {
+	Name string
+	Text string
+}
+
+// Taboo is the taboo list of an index.  The blots of taboo
+// texts are not posted when indexing and are skipped by
+// Query.Next.
+type Taboo struct {
+	Entries []TabooEntry
+}
+
+// Add adds a taboo text, replacing any entry with the
+// same name.
+func (t *Taboo) Add(name, text string) {
+	for i := range t.Entries {
+		if t.Entries[i].Name == name {
+			t.Entries[i].Text = text
+			return
+		}
+	}
+	t.Entries = append(t.Entries, TabooEntry{Name: name, Text: text})
+}
+
+// Remove removes the entry named name, returning
+// whether there was one.
+func (t *Taboo) Remove(name string) bool {
+	for i := range t.Entries {
+		if t.Entries[i].Name == name {
+			t.Entries = append(t.Entries[:i], t.Entries[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// blots returns the set of blots of the taboo texts as
+// produced by the blotter of cfg, before reduction to the
+// blot space of the index.
+func (t *Taboo) blots(cfg *Config) (map[uint64]bool, error) {
+	res := make(map[uint64]bool)
+	tokfn, err := token.FromConfig(&cfg.TokenConfig)
+	if err != nil {
+		return nil, err
+	}
+	var toks []token.T
+	for i := range t.Entries {
+		bler, err := blotter.FromConfig(&cfg.BlotConfig)
+		if err != nil {
+			return nil, err
+		}
+		// with winnowing, the blots of a taboo text
+		// winnowed in a document depend on the text
+		// around it, so all of them are taboo.
+		sel := newBlotSelAll(bler, cfg.SeqLen, cfg.BlotConfig.Winnow)
+		toks = tokfn(toks[:0], []byte(t.Entries[i].Text), 0)
+		for j := range toks {
+			tok := &toks[j]
+			if tok.Tag != token.Word {
+				continue
+			}
+			for _, sb := range sel.add(tok.Key()) {
+				res[sb.blot] = true
+			}
+		}
+		for _, sb := range sel.end() {
+			res[sb.blot] = true
+		}
+	}
+	return res, nil
+}
+
+// readTaboo reads the taboo list of the index with
+// configuration cfg.  An index without a taboo list
+// has an empty one.
+func readTaboo(cfg *Config) (*Taboo, error) {
+	d, err := ioutil.ReadFile(cfg.TabooPath())
+	if os.IsNotExist(err) {
+		return &Taboo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	t := &Taboo{}
+	if err := json.Unmarshal(d, t); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (t *Taboo) write(cfg *Config) error {
+	d, err := json.MarshalIndent(t, "", "\t")
+	if err != nil {
+		return err
+	}
+	tmp := cfg.TabooPath() + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(d, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, cfg.TabooPath())
+}
+
+// EditTaboo locks the index at root exclusively and
+// applies fn to its taboo list, storing the result if
+// fn returns nil.
+//
+// Changes apply to documents indexed afterwards and to
+// queries on indices opened afterwards.
+func EditTaboo(root string, fn func(*Taboo) error) error {
+	cfg, err := ReadConfigFromRoot(root)
+	if err != nil {
+		return err
+	}
+	lk, err := lock.New(cfg.LockPath())
+	if err != nil {
+		return err
+	}
+	defer lk.Close()
+	if err := lk.Lock(); err != nil {
+		return err
+	}
+	t, err := readTaboo(cfg)
+	if err != nil {
+		return err
+	}
+	if err := fn(t); err != nil {
+		return err
+	}
+	return t.write(cfg)
+}
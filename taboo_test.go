@@ -0,0 +1,78 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTaboo(t *testing.T) {
+	root := testIndex(t, queryTestDocs)
+	err := EditTaboo(root, func(tb *Taboo) error {
+		tb.Add("boilerplate", queryTestDocs[0])
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Taboo().Entries) != 1 {
+		t.Fatalf("got %d taboo entries", len(idx.Taboo().Entries))
+	}
+	if blots := queryBlots(t, idx.StartQuery(QueryMaxBlot)); len(blots) != 0 {
+		t.Errorf("query gave taboo blots %x", blots)
+	}
+	counts := make(map[uint32]uint32)
+	for b := range idx.tabooBlots {
+		counts[b] = idx.shards[b%2].Count(b / 2)
+	}
+	idx.Close()
+
+	idxr, err := OpenIndexer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(filepath.Dir(root), "doc0")
+	doc := &Doc{Path: path, End: uint64(len(queryTestDocs[0])), Dat: []byte(queryTestDocs[0])}
+	if err := idxr.Add(doc); err != nil {
+		t.Fatal(err)
+	}
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	idx, err = OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for b, ct := range counts {
+		if got := idx.shards[b%2].Count(b / 2); got != ct {
+			t.Errorf("taboo blot %x count got %d want %d", b, got, ct)
+		}
+	}
+	idx.Close()
+	err = EditTaboo(root, func(tb *Taboo) error {
+		if !tb.Remove("boilerplate") {
+			t.Errorf("couldn't remove taboo entry")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
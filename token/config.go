@@ -14,17 +14,95 @@
 
 package token
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
 
 // Config describes tokenizer configurations.
+//
+// Built in tokenizers are
+//
+//	words.simple: alphanumeric words of text.
+//	go.source: Go source code, see TokenizeGo.
+//
+// Others may be added with Register.
 type Config struct {
 	Name string
-	// nothing here yet.
+	// For go.source, whether to replace identifiers
+	// and literals with placeholders, see
+	// TokenizeGoNormalized.
+	Placeholders bool `json:",omitempty"`
+
+	// Normalization of words, applied by any tokenizer.
+	// For words.simple, setting any of these also keeps
+	// combining marks in words, see TokenizeWords.
+
+	// NFKC applies Unicode NFKC normalization, which
+	// unifies composed and decomposed characters, full
+	// width forms, ligatures and the like.
+	NFKC bool `json:",omitempty"`
+	// Fold applies full Unicode case folding.
+	Fold bool `json:",omitempty"`
+	// StripMarks removes diacritical marks, so that
+	// "café" and "cafe" are the same word.
+	StripMarks bool `json:",omitempty"`
+	// JoinWords makes words joined by an apostrophe or
+	// hyphen single words, ignoring the apostrophe or
+	// hyphen, so that "don't", "don’t" and "dont" are
+	// the same word.
+	JoinWords bool `json:",omitempty"`
+	// Params holds parameters for registered
+	// tokenizers, which dupi does not interpret.
+	Params map[string]string `json:",omitempty"`
 }
 
 // TokenizerFunc is the type of a function used
 // for tokenizing document data.
-type TokenizerFunc func(dst []T, dat []byte, offset uint32) []T
+type TokenizerFunc func(dst []T, dat []byte, offset uint64) []T
+
+// Factory creates a tokenizer function from a config.
+type Factory func(cfg *Config) (TokenizerFunc, error)
+
+// ErrUnregistered is returned by FromConfig when no
+// tokenizer is registered under the name in the config.
+var ErrUnregistered = errors.New("tokenizer not registered")
+
+var (
+	regMu    sync.Mutex
+	registry = map[string]Factory{
+		"words.simple": func(cfg *Config) (TokenizerFunc, error) {
+			if cfg.normalizes() {
+				join := cfg.JoinWords
+				return func(dst []T, d []byte, offset uint64) []T {
+					return TokenizeWords(dst, d, offset, join)
+				}, nil
+			}
+			return Tokenize, nil
+		},
+		"go.source": func(cfg *Config) (TokenizerFunc, error) {
+			if cfg.Placeholders {
+				return TokenizeGoNormalized, nil
+			}
+			return TokenizeGo, nil
+		}}
+)
+
+// Register makes the tokenizer created by f available
+// under name.  Register panics if name is already
+// registered or f is nil.
+func Register(name string, f Factory) {
+	regMu.Lock()
+	defer regMu.Unlock()
+	if f == nil {
+		panic("token: Register factory is nil")
+	}
+	if _, dup := registry[name]; dup {
+		panic(fmt.Sprintf("token: Register called twice for %q", name))
+	}
+	registry[name] = f
+}
 
 // DefaultConfig returns the default tokenizer config
 // for dupy.
@@ -35,10 +113,18 @@ func DefaultConfig() *Config {
 // FromConfig attempts to create a tokenizer function
 // from a configuration.
 func FromConfig(cfg *Config) (TokenizerFunc, error) {
-	switch cfg.Name {
-	case "words.simple":
-		return Tokenize, nil
-	default:
-		return nil, fmt.Errorf("unrecognized token config: '%#v'", cfg)
+	regMu.Lock()
+	f := registry[cfg.Name]
+	regMu.Unlock()
+	if f == nil {
+		return nil, fmt.Errorf("%w: %q", ErrUnregistered, cfg.Name)
+	}
+	tf, err := f(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.normalizes() {
+		tf = normalized(cfg, tf)
 	}
+	return tf, nil
 }
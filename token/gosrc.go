@@ -0,0 +1,101 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"bytes"
+	"go/scanner"
+	gotoken "go/token"
+	"go/types"
+)
+
+// placeholders for normalized Go identifiers and literals.
+var goNorms = map[gotoken.Token][]byte{
+	gotoken.IDENT:  []byte("$id"),
+	gotoken.INT:    []byte("$int"),
+	gotoken.FLOAT:  []byte("$float"),
+	gotoken.IMAG:   []byte("$imag"),
+	gotoken.CHAR:   []byte("$char"),
+	gotoken.STRING: []byte("$string")}
+
+// TokenizeGo tokenizes Go source code.  Keywords,
+// operators, delimiters, identifiers and literals are all
+// Words.  Comments and automatically inserted semicolons are
+// dropped, and text the Go scanner does not accept is Other.
+func TokenizeGo(dst []T, d []byte, offset uint64) []T {
+	return tokenizeGo(dst, d, offset, false)
+}
+
+// TokenizeGoNormalized is like TokenizeGo, but sets
+// the Norm of identifiers and literals to a placeholder
+// for their kind, so that code which differs only in names
+// and constants has the same blots.  Predeclared
+// identifiers, such as int and len, are not normalized.
+func TokenizeGoNormalized(dst []T, d []byte, offset uint64) []T {
+	return tokenizeGo(dst, d, offset, true)
+}
+
+func tokenizeGo(dst []T, d []byte, offset uint64, norm bool) []T {
+	fset := gotoken.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(d))
+	var s scanner.Scanner
+	// errors are reported as ILLEGAL tokens.
+	s.Init(file, d, nil, 0)
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == gotoken.EOF {
+			return dst
+		}
+		if tok == gotoken.SEMICOLON && lit == "\n" {
+			continue
+		}
+		off := file.Offset(pos)
+		n := goTokenLen(d[off:], tok, lit)
+		t := T{
+			Tag: Word,
+			Lit: d[off : off+n],
+			Pos: offset + uint64(off)}
+		switch {
+		case tok == gotoken.ILLEGAL:
+			t.Tag = Other
+		case norm && tok == gotoken.IDENT:
+			if types.Universe.Lookup(lit) == nil {
+				t.Norm = goNorms[tok]
+			}
+		case norm && tok.IsLiteral():
+			t.Norm = goNorms[tok]
+		}
+		dst = append(dst, t)
+	}
+}
+
+// goTokenLen gives the length in the source d of the token
+// tok with literal lit starting at d[0].
+func goTokenLen(d []byte, tok gotoken.Token, lit string) int {
+	switch {
+	case tok == gotoken.STRING && lit[0] == '`':
+		// carriage returns are removed from lit.
+		if i := bytes.IndexByte(d[1:], '`'); i != -1 {
+			return i + 2
+		}
+		return len(d)
+	case lit != "":
+		return len(lit)
+	case tok == gotoken.ILLEGAL:
+		return 1
+	default:
+		return len(tok.String())
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const goSrcA = `// sum sums.
+func sum(xs []int) int {
+	s := 0
+	for _, x := range xs {
+		s += x * 2 // double
+	}
+	return s
+}
+`
+
+const goSrcB = `func total(vals []int) int {
+	acc := 0
+	for _, v := range vals {
+		acc += v * 3
+	}
+	return acc
+}`
+
+func keys(toks []T) []string {
+	res := make([]string, len(toks))
+	for i := range toks {
+		res[i] = string(toks[i].Key())
+	}
+	return res
+}
+
+func TestTokenizeGo(t *testing.T) {
+	toks := TokenizeGo(nil, []byte(goSrcA), 7)
+	got := strings.Join(keys(toks), " ")
+	exp := "func sum ( xs [ ] int ) int { s := 0 for _ , x := range xs { s += x * 2 } return s }"
+	if got != exp {
+		t.Errorf("got\n%s\nexpected\n%s", got, exp)
+	}
+	for i := range toks {
+		tok := &toks[i]
+		if tok.Tag != Word {
+			t.Errorf("%s not a word", tok)
+		}
+		pos := tok.Pos - 7
+		if src := goSrcA[pos : pos+uint64(len(tok.Lit))]; src != string(tok.Lit) {
+			t.Errorf("%s: source is '%s'", tok, src)
+		}
+	}
+}
+
+func TestTokenizeGoNormalized(t *testing.T) {
+	a := keys(TokenizeGoNormalized(nil, []byte(goSrcA), 0))
+	b := keys(TokenizeGoNormalized(nil, []byte(goSrcB), 0))
+	if strings.Join(a, " ") != strings.Join(b, " ") {
+		t.Errorf("normalized clones differ:\n%s\n%s", a, b)
+	}
+	for _, k := range a {
+		if k == "sum" || k == "xs" {
+			t.Errorf("identifier %s not normalized", k)
+		}
+	}
+	if a[6] != "int" {
+		t.Errorf("predeclared identifier normalized to %s", a[6])
+	}
+}
+
+func TestTokenizeGoIllegal(t *testing.T) {
+	src := "x := `a\r\nb` # y"
+	toks := TokenizeGo(nil, []byte(src), 0)
+	if len(toks) != 5 {
+		t.Fatalf("got %d tokens: %v", len(toks), toks)
+	}
+	if string(toks[2].Lit) != "`a\r\nb`" {
+		t.Errorf("raw string lit %q", toks[2].Lit)
+	}
+	if toks[3].Tag != Other || string(toks[3].Lit) != "#" {
+		t.Errorf("illegal token %s", &toks[3])
+	}
+}
+
+func TestFromConfigGo(t *testing.T) {
+	if _, err := FromConfig(&Config{Name: "go.source", Placeholders: true}); err != nil {
+		t.Error(err)
+	}
+	if _, err := FromConfig(&Config{Name: "go.sauce"}); err == nil {
+		t.Error("expected error for unknown tokenizer")
+	}
+}
+
+// TestConfigJSON checks that configs without placeholders
+// are written as before they were added.
+func TestConfigJSON(t *testing.T) {
+	d, err := json.Marshal(DefaultConfig())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := string(d), `{"Name":"words.simple"}`; got != want {
+		t.Errorf("got %s want %s", got, want)
+	}
+}
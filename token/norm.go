@@ -0,0 +1,127 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"bytes"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizes returns whether cfg asks for words to be
+// normalized.
+func (cfg *Config) normalizes() bool {
+	return cfg.NFKC || cfg.Fold || cfg.StripMarks || cfg.JoinWords
+}
+
+// isJoiner returns whether r is an apostrophe or hyphen
+// which joins words under Config.JoinWords.
+func isJoiner(r rune) bool {
+	switch r {
+	case '\'', '‘', '’', 'ʼ', '＇',
+		'-', '‐', '‑', '­':
+		return true
+	}
+	return false
+}
+
+// TokenizeWords is like Tokenize, but includes combining
+// marks following a letter or digit in words, so that
+// decomposed text is tokenized like composed text.  If
+// join is true, apostrophes and hyphens between letters or
+// digits are part of words as well.
+func TokenizeWords(dst []T, d []byte, offset uint64, join bool) []T {
+	if !utf8.Valid(d) {
+		return dst
+	}
+	start, inWord := 0, false
+	add := func(end int) {
+		tag := Other
+		if inWord {
+			tag = Word
+		}
+		dst = append(dst,
+			T{
+				Lit: d[start:end],
+				Pos: offset + uint64(start),
+				Tag: tag})
+		start = end
+	}
+	for i := 0; i < len(d); {
+		r, n := utf8.DecodeRune(d[i:])
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r) ||
+			(inWord && unicode.Is(unicode.Mark, r))
+		if !isWord && inWord && join && isJoiner(r) {
+			next, _ := utf8.DecodeRune(d[i+n:])
+			isWord = unicode.IsLetter(next) || unicode.IsDigit(next)
+		}
+		if isWord != inWord && start < i {
+			add(i)
+		}
+		inWord = isWord
+		i += n
+	}
+	if start < len(d) {
+		add(len(d))
+	}
+	return dst
+}
+
+// normalized wraps tf so that the words it returns have
+// their Norm set according to the normalization options
+// of cfg.
+func normalized(cfg *Config, tf TokenizerFunc) TokenizerFunc {
+	return func(dst []T, d []byte, offset uint64) []T {
+		start := len(dst)
+		dst = tf(dst, d, offset)
+		// transformers are not safe for concurrent use,
+		// so each call has its own.
+		t := cfg.transformer()
+		for i := range dst[start:] {
+			tok := &dst[start+i]
+			if tok.Tag != Word {
+				continue
+			}
+			key, _, err := transform.Bytes(t, tok.Key())
+			if err != nil || bytes.Equal(key, tok.Key()) {
+				continue
+			}
+			tok.Norm = key
+		}
+		return dst
+	}
+}
+
+func (cfg *Config) transformer() transform.Transformer {
+	var ts []transform.Transformer
+	if cfg.JoinWords {
+		ts = append(ts, runes.Remove(runes.Predicate(isJoiner)))
+	}
+	if cfg.NFKC {
+		ts = append(ts, norm.NFKC)
+	}
+	if cfg.Fold {
+		ts = append(ts, cases.Fold())
+	}
+	if cfg.StripMarks {
+		ts = append(ts, norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	}
+	return transform.Chain(ts...)
+}
@@ -0,0 +1,80 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package token
+
+import (
+	"strings"
+	"testing"
+)
+
+func wordKeys(t *testing.T, cfg *Config, s string) string {
+	t.Helper()
+	tf, err := FromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res []string
+	for _, tok := range tf(nil, []byte(s), 3) {
+		if s[tok.Pos-3:tok.Pos-3+uint64(len(tok.Lit))] != string(tok.Lit) {
+			t.Errorf("%s: wrong position", &tok)
+		}
+		if tok.Tag == Word {
+			res = append(res, string(tok.Key()))
+		}
+	}
+	return strings.Join(res, " ")
+}
+
+func TestNormalize(t *testing.T) {
+	all := &Config{Name: "words.simple", NFKC: true, Fold: true, StripMarks: true, JoinWords: true}
+	for _, tc := range []struct {
+		cfg  *Config
+		a, b string
+	}{
+		{&Config{Name: "words.simple", NFKC: true}, "un café noir", "un café noir"},
+		{&Config{Name: "words.simple", NFKC: true}, "room １０１", "room 101"},
+		{&Config{Name: "words.simple", NFKC: true}, "the ﬁle", "the file"},
+		{&Config{Name: "words.simple", Fold: true}, "STRASSE", "straße"},
+		{&Config{Name: "words.simple", StripMarks: true}, "crème brûlée", "creme brulee"},
+		{&Config{Name: "words.simple", JoinWords: true}, "don’t re-use", "dont reuse"},
+		{all, "Don’t ＥＡＴ the Crème-Brûlée.", "dont eat the cremebrulee."},
+	} {
+		a, b := wordKeys(t, tc.cfg, tc.a), wordKeys(t, tc.cfg, tc.b)
+		if a != b {
+			t.Errorf("%+v: '%s' gave '%s' but '%s' gave '%s'", tc.cfg, tc.a, a, tc.b, b)
+		}
+	}
+}
+
+func TestNormalizeDefault(t *testing.T) {
+	a := wordKeys(t, DefaultConfig(), "un café noir")
+	b := wordKeys(t, DefaultConfig(), "un café noir")
+	if a == b {
+		t.Errorf("default config normalizes '%s'", a)
+	}
+}
+
+func TestTokenizeWordsJoin(t *testing.T) {
+	toks := TokenizeWords(nil, []byte("it's 'quoted' - x-"), 0, true)
+	var words []string
+	for _, tok := range toks {
+		if tok.Tag == Word {
+			words = append(words, string(tok.Lit))
+		}
+	}
+	if got := strings.Join(words, "|"); got != "it's|quoted|x" {
+		t.Errorf("got words %s", got)
+	}
+}
@@ -48,7 +48,19 @@ func (t Tag) String() string {
 type T struct {
 	Tag Tag
 	Lit []byte
-	Pos uint32
+	Pos uint64
+	// Norm, if not nil, replaces Lit when
+	// computing blots.
+	Norm []byte
+}
+
+// Key returns the bytes from which the blot of
+// t is computed.
+func (t *T) Key() []byte {
+	if t.Norm != nil {
+		return t.Norm
+	}
+	return t.Lit
 }
 
 func (t *T) String() string {
@@ -56,7 +68,7 @@ func (t *T) String() string {
 }
 
 // Tokenize is a tokenizer function.
-func Tokenize(dst []T, d []byte, offset uint32) []T {
+func Tokenize(dst []T, d []byte, offset uint64) []T {
 	if !utf8.Valid(d) {
 		return dst
 	}
@@ -69,7 +81,7 @@ func Tokenize(dst []T, d []byte, offset uint32) []T {
 				dst = append(dst,
 					T{
 						Lit: d[j:i],
-						Pos: offset + uint32(j),
+						Pos: offset + uint64(j),
 						Tag: Other})
 				j = i
 			}
@@ -80,7 +92,7 @@ func Tokenize(dst []T, d []byte, offset uint32) []T {
 			dst = append(dst,
 				T{
 					Lit: d[j:i],
-					Pos: offset + uint32(j),
+					Pos: offset + uint64(j),
 					Tag: Word})
 			j = i
 		}
@@ -91,13 +103,13 @@ func Tokenize(dst []T, d []byte, offset uint32) []T {
 			dst = append(dst,
 				T{
 					Lit: d[j:i],
-					Pos: offset + uint32(j),
+					Pos: offset + uint64(j),
 					Tag: Word})
 		} else {
 			dst = append(dst,
 				T{
 					Lit: d[j:i],
-					Pos: offset + uint32(j),
+					Pos: offset + uint64(j),
 					Tag: Other})
 		}
 	}
@@ -0,0 +1,226 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/go-air/dupi/internal/shard"
+	"github.com/go-air/dupi/lock"
+)
+
+// Problem is an inconsistency in an index found by
+// Verify.
+type Problem struct {
+	// Shard is the shard with the problem, or -1 if the
+	// problem is not in a shard.
+	Shard int
+	// Blot is the blot whose posting list has the
+	// problem, if Shard is not -1.
+	Blot uint32
+	Err  error
+}
+
+func (p *Problem) String() string {
+	if p.Shard == -1 {
+		return p.Err.Error()
+	}
+	return fmt.Sprintf("shard %d blot %x: %s", p.Shard, p.Blot, p.Err)
+}
+
+// Repairable returns whether p can be repaired by
+// RepairCounts.
+func (p *Problem) Repairable() bool {
+	return errors.Is(p.Err, shard.ErrCount)
+}
+
+// CheckIndex opens the index at root like OpenIndex and
+// verifies it, returning the open index and the problems
+// found.  Unlike OpenIndex, CheckIndex leaves an interrupted
+// indexer or compaction as it is, reporting it as a
+// problem, and reports an invalid config or a file of the
+// index which cannot be loaded as a problem.  The returned
+// index is nil if it could not be loaded, or if it was not
+// closed by its indexer, in which case it is not checked
+// further.
+func CheckIndex(root string, opts ...IndexOption) (*Index, []Problem, error) {
+	var err error
+	x := &Index{config: &Config{IndexRoot: root}}
+	for _, opt := range opts {
+		opt(x)
+	}
+	x.lock, err = lock.New(x.config.LockPath())
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := x.lock.LockShared(); err != nil {
+		x.lock.Close()
+		return nil, nil, err
+	}
+	var res []Problem
+	add := func(err error) {
+		res = append(res, Problem{Shard: -1, Err: err})
+	}
+	if ok, err := compacted(root); err != nil {
+		x.lock.Close()
+		return nil, nil, err
+	} else if ok {
+		add(errors.New("index has an interrupted compaction, which opening it completes or discards"))
+	}
+	if x.config, err = ReadConfigFromRoot(root); err != nil {
+		x.lock.Close()
+		add(fmt.Errorf("config: %w", err))
+		return nil, res, nil
+	}
+	if ok, err := needsRecovery(x.config); err != nil {
+		add(fmt.Errorf("journal: %w", err))
+	} else if ok {
+		// the posts past the checkpoint disagree with
+		// the rest of the index until rolled back.
+		x.lock.Close()
+		add(errors.New("index was not closed by its indexer: it is being indexed, " +
+			"or opening it will roll it back to its last checkpoint"))
+		return nil, res, nil
+	}
+	if err := x.open(); err != nil {
+		x.lock.Close()
+		add(err)
+		return nil, res, nil
+	}
+	ps, err := x.Verify()
+	if err != nil {
+		x.Close()
+		return nil, nil, err
+	}
+	return x, append(res, ps...), nil
+}
+
+// Verify checks the integrity of x, returning the
+// problems found.  Verify checks that
+//
+//   - every document refers to a file name of x, and
+//     has a MinHash signature and SimHash fingerprint if
+//     x keeps them;
+//   - every posting list can be read, has strictly
+//     increasing document ids less than the number of
+//     documents, and has the length recorded for it.
+func (x *Index) Verify() ([]Problem, error) {
+	var res []Problem
+	add := func(err error) {
+		res = append(res, Problem{Shard: -1, Err: err})
+	}
+	numDocs, err := x.dmd.NumDocs()
+	if err != nil {
+		return nil, err
+	}
+	for did := uint64(1); did < numDocs; did++ {
+		fid, start, end, err := x.dmd.Lookup(did)
+		if err != nil {
+			return nil, err
+		}
+		if fid == 0 || int(fid) >= len(x.fnames.d) {
+			add(fmt.Errorf("document %d: file id %d has no file name", did, fid))
+		}
+		if end < start {
+			add(fmt.Errorf("document %d: end %d is before start %d", did, end, start))
+		}
+	}
+	if x.sigs != nil {
+		n, err := x.sigs.Len()
+		if err != nil {
+			return nil, err
+		}
+		if n != numDocs {
+			add(fmt.Errorf("%d MinHash signatures for %d documents", n-1, numDocs-1))
+		}
+	}
+	if x.fps != nil {
+		n, err := x.fps.Len()
+		if err != nil {
+			return nil, err
+		}
+		if n != numDocs {
+			add(fmt.Errorf("%d SimHash fingerprints for %d documents", n-1, numDocs-1))
+		}
+	}
+	for _, did := range x.tombs.Dids() {
+		if did == 0 || did >= numDocs {
+			add(fmt.Errorf("deleted document %d does not exist", did))
+		}
+	}
+	for i := range x.shards {
+		err := x.shards[i].Verify(numDocs, func(p *shard.Problem) {
+			res = append(res, Problem{
+				Shard: i,
+				Blot:  x.JoinBlot(uint32(i), p.Blot),
+				Err:   p.Err})
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// RepairCounts repairs the problems found by Verify
+// where the count of a blot differs from the length of its
+// posting list, returning how many were repaired.
+//
+// Like Compact, RepairCounts waits for all other users of
+// the index to close it, and if x cannot be reopened once
+// repaired, x is closed.
+func (x *Index) RepairCounts() (n int, err error) {
+	downgrade, err := x.upgrade()
+	if err != nil {
+		return 0, err
+	}
+	defer downgrade(&err)
+	numDocs, err := x.dmd.NumDocs()
+	if err != nil {
+		return 0, err
+	}
+	fixes := make([][]shard.Problem, len(x.shards))
+	for i := range x.shards {
+		err := x.shards[i].Verify(numDocs, func(p *shard.Problem) {
+			if errors.Is(p.Err, shard.ErrCount) {
+				fixes[i] = append(fixes[i], *p)
+				n++
+			}
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+	if n == 0 {
+		return 0, nil
+	}
+	cfg := x.config
+	err = x.reopen(func() error {
+		for i, ps := range fixes {
+			if len(ps) == 0 {
+				continue
+			}
+			if err := shard.RepairCounts(cfg.PostPath(i), cfg.FormatVersion(), cfg.blotBits(), ps); err != nil {
+				return fmt.Errorf("error repairing shard %d: %w", i, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return n, nil
+}
@@ -0,0 +1,151 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-air/dupi/internal/shard"
+)
+
+func TestVerify(t *testing.T) {
+	root := testIndex(t, queryTestDocs)
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	problems, err := idx.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 0 {
+		t.Fatalf("new index has problems %v", problems)
+	}
+	blot := queryBlots(t, idx.StartQuery(QueryMaxBlot))[0]
+	sh, sblot := idx.SplitBlot(blot)
+	cfg := idx.config
+	if err := idx.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bad := []shard.Problem{{Blot: sblot, Err: shard.ErrCount, Count: 7}}
+	if err := shard.RepairCounts(cfg.PostPath(int(sh)), cfg.FormatVersion(), cfg.blotBits(), bad); err != nil {
+		t.Fatal(err)
+	}
+
+	idx, err = OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	problems, err = idx.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(problems) != 1 {
+		t.Fatalf("got problems %v", problems)
+	}
+	p := &problems[0]
+	if p.Shard != int(sh) || p.Blot != blot || !p.Repairable() {
+		t.Errorf("got problem %s want count of blot %x", p, blot)
+	}
+	n, err := idx.RepairCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("repaired %d counts", n)
+	}
+	if problems, err = idx.Verify(); err != nil || len(problems) != 0 {
+		t.Errorf("repaired index has problems %v: %v", problems, err)
+	}
+	b := &Blot{Blot: blot}
+	if err := idx.StartQuery(QueryMaxBlot).Get(b); err != nil {
+		t.Fatal(err)
+	}
+	if len(b.Docs) < 2 {
+		t.Errorf("blot %x has docs %v after repair", blot, b.Docs)
+	}
+}
+
+func TestCheckIndex(t *testing.T) {
+	root := testIndex(t, queryTestDocs)
+	idx, ps, err := CheckIndex(root)
+	if err != nil || idx == nil || len(ps) != 0 {
+		t.Fatalf("checking new index gave %v %v", ps, err)
+	}
+	cfg := idx.config
+	idx.Close()
+
+	iix := cfg.PostPath(0) + ".iix"
+	d, err := ioutil.ReadFile(iix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(iix, d[:len(d)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, ps, err = CheckIndex(root)
+	if err != nil || idx != nil || len(ps) != 1 || !errors.Is(ps[0].Err, ErrCorrupt) {
+		t.Errorf("checking index with truncated iix gave %v %v", ps, err)
+	}
+	if err := ioutil.WriteFile(cfg.Path(), []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	idx, ps, err = CheckIndex(root)
+	if err != nil || idx != nil || len(ps) != 1 || !errors.Is(ps[0].Err, ErrCorrupt) {
+		t.Errorf("checking index with corrupt config gave %v %v", ps, err)
+	}
+}
+
+func TestCheckIndexCrashed(t *testing.T) {
+	tmp, err := ioutil.TempDir(".", "dupi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(tmp)
+	root := filepath.Join(tmp, "dupi")
+	cfg, err := NewConfig(root, 2, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.CheckpointRate = len(queryTestDocs)
+	idxr, err := IndexerFromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addDocs(t, idxr, queryTestDocs)
+	addDocs(t, idxr, journalTestDocs)
+	crash(t, idxr)
+
+	for i := 0; i < 2; i++ {
+		idx, ps, err := CheckIndex(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(ps) != 1 || ps[0].Shard != -1 {
+			t.Errorf("checking crashed index gave %v", ps)
+		}
+		if idx != nil {
+			idx.Close()
+		}
+	}
+	if lost, err := Recover(root); err != nil || lost != uint64(len(journalTestDocs)) {
+		t.Errorf("checked index lost %d docs on recovery: %v", lost, err)
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-air/dupi/blotter"
+)
+
+// wideTestDocs returns 30 documents of random words and a
+// copy of the first.
+func wideTestDocs() []string {
+	r := rand.New(rand.NewSource(6))
+	var docs []string
+	for i := 0; i < 30; i++ {
+		ws := make([]string, 40)
+		for j := range ws {
+			ws[j] = fmt.Sprintf("w%d", r.Intn(100000))
+		}
+		docs = append(docs, strings.Join(ws, " ")+".")
+	}
+	return append(docs, "copied: "+docs[0])
+}
+
+// wideConfig makes blots of 64 bits in a small blot space,
+// so that many documents post a blot with several checks.
+func wideConfig(cfg *Config) {
+	cfg.BlotBits = MinBlotBits
+	cfg.BlotConfig.Name = blotter.PolyName
+	cfg.BlotConfig.Width = 64
+}
+
+// TestWideBlots checks that with blots of 64 bits in a
+// small blot space, GetChecked discards the documents
+// whose blots collide only in the blot space.
+func TestWideBlots(t *testing.T) {
+	docs := wideTestDocs()
+	root := testIndexConfig(t, docs, wideConfig)
+	out := filepath.Join(filepath.Dir(root), "merged")
+	if err := MergeIndices(out, root); err != nil {
+		t.Fatal(err)
+	}
+	for _, root := range []string{root, out} {
+		idx, err := OpenIndex(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		doc := &Doc{Dat: []byte(docs[0]), End: uint64(len(docs[0]))}
+		poss, err := idx.BlotPositions(doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		q := idx.StartQuery(QueryMaxBlot)
+		all := 0
+		for _, p := range poss {
+			b := &Blot{Blot: p.Blot}
+			if err := q.Get(b); err != nil {
+				t.Fatal(err)
+			}
+			all += len(b.Docs)
+			b = &Blot{Blot: p.Blot}
+			if err := q.GetChecked(b, p.Check); err != nil {
+				t.Fatal(err)
+			}
+			if len(b.Docs) != 2 || filepath.Base(b.Docs[0].Path) != "doc0" || filepath.Base(b.Docs[1].Path) != "doc30" {
+				t.Errorf("%s: blot %x check %x has docs %v", root, p.Blot, p.Check, b.Docs)
+			}
+		}
+		if all <= 2*len(poss) {
+			t.Errorf("%s: blots of %d windows have only %d docs", root, len(poss), all)
+		}
+		like, err := likeDocs(idx, doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := make(map[string]bool)
+		for _, l := range strings.Split(like, "\n") {
+			found[filepath.Base(strings.Fields(l)[0])] = true
+		}
+		if len(found) != 2 || !found["doc0"] || !found["doc30"] {
+			t.Errorf("%s: like found %v", root, found)
+		}
+		ps, err := idx.Verify()
+		if err != nil || len(ps) != 0 {
+			t.Errorf("%s: problems %v %v", root, ps, err)
+		}
+		if err := idx.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cfg, err := ReadConfigFromRoot(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.Version = FormatVersion3
+	if err := cfg.check(); err == nil {
+		t.Errorf("no error for wide blots in format version %d", FormatVersion3)
+	}
+}
+
+// TestWideAppend checks that appending to an index with
+// wide blots gives the index made at once.
+func TestWideAppend(t *testing.T) {
+	docs := wideTestDocs()
+	want := testIndexConfig(t, docs, wideConfig)
+	root := testIndexConfig(t, docs[:15], wideConfig)
+	idxr, err := OpenIndexer(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addDocs(t, idxr, docs[15:])
+	if err := idxr.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := indexBlots(t, root), indexBlots(t, want); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("appended blots got %x want %x", got, want)
+	}
+	stats := func(root string) *Stats {
+		t.Helper()
+		idx, err := OpenIndex(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer idx.Close()
+		if ps, err := idx.Verify(); err != nil || len(ps) != 0 {
+			t.Errorf("%s: problems %v %v", root, ps, err)
+		}
+		st, err := idx.Stats()
+		if err != nil {
+			t.Fatal(err)
+		}
+		st.Root, st.NumPaths = "", 0
+		return st
+	}
+	if got, want := stats(root), stats(want); *got != *want {
+		t.Errorf("appended stats got %+v want %+v", got, want)
+	}
+}
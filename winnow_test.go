@@ -0,0 +1,117 @@
+// Copyright 2021 the Dupi authors
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dupi
+
+import (
+	"fmt"
+	"math/rand"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWinnow(t *testing.T) {
+	const (
+		seqLen = 10
+		w      = 4
+		words  = 80
+	)
+	r := rand.New(rand.NewSource(1))
+	randWords := func(n int) []string {
+		res := make([]string, n)
+		for i := range res {
+			res[i] = fmt.Sprintf("w%d", r.Intn(100000))
+		}
+		return res
+	}
+	// pairs of documents sharing a run of seqLen+w-1 words
+	// at random places.
+	var docs []string
+	for i := 0; i < 10; i++ {
+		shared := randWords(seqLen + w - 1)
+		for j := 0; j < 2; j++ {
+			d := randWords(words)
+			at := r.Intn(words)
+			d = append(d[:at], append(append([]string(nil), shared...), d[at:]...)...)
+			docs = append(docs, strings.Join(d, " "))
+		}
+	}
+	root := testIndexConfig(t, docs, func(cfg *Config) {
+		cfg.BlotConfig.Winnow = w
+	})
+	idx, err := OpenIndex(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer idx.Close()
+	st, err := idx.Stats()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numPosts uint64
+	q := idx.StartQuery(QueryMaxBlot)
+	for i, d := range docs {
+		path := filepath.Join(filepath.Dir(root), fmt.Sprintf("doc%d", i))
+		doc := &Doc{Path: path, End: uint64(len(d)), Dat: []byte(d)}
+		blots := idx.BlotDoc(nil, doc)
+		numPosts += uint64(len(blots))
+		for _, b := range blots {
+			start, end, err := idx.FindBlot(idx.ReduceBlot(b), doc)
+			if err != nil || start >= end {
+				t.Errorf("doc%d: FindBlot(%x) gave %d:%d %v", i, b, start, end, err)
+			}
+			db := &Blot{Blot: idx.ReduceBlot(b)}
+			if err := q.Get(db); err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for j := range db.Docs {
+				found = found || db.Docs[j].Path == path
+			}
+			if !found {
+				t.Errorf("doc%d: blot %x not posted", i, b)
+			}
+		}
+		like, err := likeDocs(idx, doc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		other := fmt.Sprintf("doc%d ", i^1)
+		if !strings.Contains(like, other) {
+			t.Errorf("doc%d: shared run not found, like gave %s", i, like)
+		}
+	}
+	if st.NumPosts != numPosts {
+		t.Errorf("index has %d posts, BlotDoc gave %d", st.NumPosts, numPosts)
+	}
+	shape := []Blot{{}}
+	for q := idx.StartQuery(QueryMaxBlot); ; shape[0].Docs = nil {
+		if _, err := q.Next(shape); err != nil {
+			break
+		}
+		ps, err := q.Passages(&shape[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		// blots without passages are collisions.
+		if len(ps) > 1 || len(ps) == 1 && ps[0].Blots != w {
+			t.Errorf("blot %x has passages %v", shape[0].Blot, ps)
+		}
+	}
+	all := uint64(len(docs) * (words + w - 1))
+	if numPosts*2 > all {
+		t.Errorf("winnowing posted %d of %d blots", numPosts, all)
+	}
+}